@@ -73,3 +73,33 @@ func TestDetectOS(t *testing.T) {
 		t.Logf("Operating System is %s %s", name, version)
 	}
 } // func TestDetectOS(t *testing.T)
+
+func TestPrivilegedCommand(t *testing.T) {
+	var saved = escalation
+
+	defer func() { escalation = saved }()
+
+	escalation = "/usr/bin/sudo"
+
+	var path, args, env = privilegedCommand(
+		[]string{"DEBIAN_FRONTEND=noninteractive"},
+		"/usr/bin/apt-get",
+		"-y", "install", "vim")
+
+	if path != escalation {
+		t.Errorf("Unexpected command %s", path)
+	} else if env != nil {
+		t.Errorf("Environment should be passed via env(1): %v", env)
+	} else if cmdline := strings.Join(args, " "); cmdline != "/usr/bin/env DEBIAN_FRONTEND=noninteractive /usr/bin/apt-get -y install vim" {
+		t.Errorf("Unexpected command line %q", cmdline)
+	}
+
+	escalation = ""
+
+	if path, args, env = privilegedCommand(nil, "/usr/bin/dnf", "clean", "all"); path != "/usr/bin/dnf" || len(args) != 2 || env != nil {
+		t.Errorf("Command should run as is without escalation: %s %v %v",
+			path,
+			args,
+			env)
+	}
+} // func TestPrivilegedCommand(t *testing.T)
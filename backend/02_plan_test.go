@@ -0,0 +1,87 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/02_plan_test.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	type testCase struct {
+		a, b   string
+		expect int
+	}
+
+	var cases = []testCase{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.10", "1.9", 1},
+		{"5.2.15-2+b1", "5.2.15-2+b2", -1},
+		{"1.0~rc1", "1.0", -1},
+		{"28.2p1", "28.2p2", -1},
+		{"1:2.06-95.fc38", "1:2.06-94.fc38", 1},
+		{"8.0.1", "8.0.1_1", -1},
+		{"2.0a", "2.0", 1},
+	}
+
+	for _, c := range cases {
+		var res = compareVersions(c.a, c.b)
+
+		switch {
+		case res < 0:
+			res = -1
+		case res > 0:
+			res = 1
+		}
+
+		if res != c.expect {
+			t.Errorf("compareVersions(%q, %q) = %d, expected %d",
+				c.a,
+				c.b,
+				res,
+				c.expect)
+		}
+	}
+} // func TestCompareVersions(t *testing.T)
+
+func TestParseSize(t *testing.T) {
+	type testCase struct {
+		str    string
+		expect *int64
+	}
+
+	var cases = []testCase{
+		{"41 M", newSize(41 * 1024 * 1024)},
+		{"100 k", newSize(100 * 1024)},
+		{"12.5 MiB", newSize(12.5 * 1024 * 1024)},
+		{"45.6 MB", newSize(45600000)},
+		{"1,234 kB", newSize(1234000)},
+		{"4,5 MB", newSize(4500000)},
+		{"512 B", newSize(512)},
+		{"a lot", nil},
+	}
+
+	for _, c := range cases {
+		if res := parseSize(c.str); fmtSize(res) != fmtSize(c.expect) {
+			t.Errorf("parseSize(%q) = %s, expected %s",
+				c.str,
+				fmtSize(res),
+				fmtSize(c.expect))
+		}
+	}
+} // func TestParseSize(t *testing.T)
+
+// fmtSize renders a size that may be unknown for comparisons and error
+// messages.
+func fmtSize(n *int64) string {
+	if n == nil {
+		return "unknown"
+	}
+
+	return strconv.FormatInt(*n, 10)
+} // func fmtSize(n *int64) string
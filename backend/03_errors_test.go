@@ -0,0 +1,44 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/03_errors_test.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import "testing"
+
+func TestClassifyError(t *testing.T) {
+	var cases = []struct {
+		stderr string
+		kind   error
+	}{
+		{"E: Unable to locate package frobnicate", ErrNotFound},
+		{"E: Could not get lock /var/lib/dpkg/lock-frontend. It is held by process 1234 (unattended-upgr)", ErrLocked},
+		{"E: Could not open lock file /var/lib/dpkg/lock-frontend - open (13: Permission denied)", ErrPermission},
+		{"error: you cannot perform this operation unless you are root.", ErrPermission},
+		{"Error: This command has to be run with superuser privileges (under the root user on most systems).", ErrPermission},
+		{"error: target not found: frobnicate", ErrNotFound},
+		{"error: package 'frobnicate' was not found", ErrNotFound},
+		{"No packages matched for pattern 'frobnicate'", ErrNotFound},
+		{"Problem finding frobnicate", ErrNotFound},
+		{"error: failed to init transaction (unable to lock database)", ErrLocked},
+		{"System management is locked by the application with pid 4711 (zypper).", ErrLocked},
+		{"Error: Failed to download metadata for repo 'fedora': Cannot download repomd.xml", ErrNetwork},
+		{"W: Failed to fetch http://deb.debian.org/debian/dists/bookworm/InRelease  Temporary failure resolving 'deb.debian.org'", ErrNetwork},
+		{"Error: \n Problem: conflicting requests\n  - nothing provides libfoo.so.1 needed by bar-1.0-1.x86_64", ErrConflict},
+		{"E: Unmet dependencies. Try 'apt --fix-broken install' with no packages (or specify a solution).", ErrConflict},
+		{"E: You don't have enough free space in /var/cache/apt/archives/.", ErrDiskFull},
+		{"pkg: Insufficient privileges to install packages", ErrPermission},
+		{"Something went wrong, and we do not know what.", nil},
+	}
+
+	for _, c := range cases {
+		if kind := classifyError(c.stderr); kind != c.kind {
+			t.Errorf("classifyError(%q) = %v, expected %v",
+				c.stderr,
+				kind,
+				c.kind)
+		}
+	}
+} // func TestClassifyError(t *testing.T)
@@ -0,0 +1,40 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/04_lock_test.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestPidFileLock(t *testing.T) {
+	var (
+		info *LockInfo
+		path = filepath.Join(t.TempDir(), "zypp.pid")
+	)
+
+	if info = pidFileLock(path)(); info != nil {
+		t.Errorf("Missing PID file reported as lock: %s", info)
+	}
+
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		t.Fatalf("Cannot write PID file: %s", err.Error())
+	} else if info = pidFileLock(path)(); info == nil {
+		t.Error("Lock held by the test process was not found")
+	} else if info.PID != os.Getpid() {
+		t.Errorf("Unexpected PID %d, expected %d", info.PID, os.Getpid())
+	}
+
+	// PIDs are limited to 2^22 on Linux, and less than that elsewhere.
+	if err := os.WriteFile(path, []byte("99999999\n"), 0644); err != nil {
+		t.Fatalf("Cannot write PID file: %s", err.Error())
+	} else if info = pidFileLock(path)(); info != nil {
+		t.Errorf("Stale PID file reported as lock: %s", info)
+	}
+} // func TestPidFileLock(t *testing.T)
@@ -0,0 +1,124 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/05_runner_test.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+var testLog = log.New(io.Discard, "", 0)
+
+// fakeRunner serves canned results instead of running commands. Results are
+// looked up by the command line, commands it does not know exit with status
+// 1. It remembers the commands it was asked to run, and passes their stdout
+// to the Command's Output, if it has one.
+type fakeRunner struct {
+	results map[string]*Result
+	ran     []Command
+}
+
+func (r *fakeRunner) Run(ctx context.Context, c *Command) (*Result, error) {
+	r.ran = append(r.ran, *c)
+
+	var res, ok = r.results[c.String()]
+
+	if !ok {
+		res = &Result{
+			Stderr:   fmt.Sprintf("fakeRunner: no result for %s", c),
+			ExitCode: 1,
+		}
+	}
+
+	if c.Output != nil {
+		io.WriteString(c.Output, res.Stdout) // nolint: errcheck
+	}
+
+	if res.ExitCode != 0 {
+		return res, newCmdError(c, res, nil)
+	}
+
+	return res, nil
+} // func (r *fakeRunner) Run(ctx context.Context, c *Command) (*Result, error)
+
+func TestExecRunnerTimeout(t *testing.T) {
+	var (
+		err         error
+		start       = time.Now()
+		r           = NewExecRunner(testLog)
+		ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	)
+
+	defer cancel()
+
+	if _, _, err = runCommand(ctx, r, "sleep", "10"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the command to time out, got %v", err)
+	} else if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Command was not stopped, it took %s", elapsed)
+	}
+} // func TestExecRunnerTimeout(t *testing.T)
+
+func TestExecRunnerExitStatus(t *testing.T) {
+	var (
+		err  error
+		res  *Result
+		cerr *CmdError
+		r    = NewExecRunner(testLog)
+	)
+
+	if _, err = r.LookPath("no-such-command-here"); err == nil {
+		t.Error("Looking up a command that does not exist should fail")
+	}
+
+	res, err = r.Run(context.Background(), &Command{
+		Path: "sh",
+		Args: []string{"-c", "echo E: Unable to locate package foo >&2; exit 100"},
+	})
+
+	if !errors.As(err, &cerr) {
+		t.Fatalf("Expected a *CmdError, got %v", err)
+	} else if res.ExitCode != 100 || cerr.ExitCode != 100 {
+		t.Errorf("Unexpected exit status %d", res.ExitCode)
+	} else if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Error was not classified as ErrNotFound: %s", err.Error())
+	}
+} // func TestExecRunnerExitStatus(t *testing.T)
+
+func TestFakeRunner(t *testing.T) {
+	var (
+		err    error
+		pkList []Package
+		ctx    = context.Background()
+		r      = &fakeRunner{
+			results: map[string]*Result{
+				"apt-cache search yasr": {
+					Stdout: "yasr - General-purpose console screen reader\n",
+				},
+			},
+		}
+		pk = &PkgApt{log: testLog, run: r}
+	)
+
+	if pkList, err = pk.Search(ctx, "yasr"); err != nil {
+		t.Errorf("Search failed: %s", err.Error())
+	} else if len(pkList) != 1 || pkList[0].Name != "yasr" {
+		t.Errorf("Unexpected search result: %v", pkList)
+	}
+
+	if err = pk.Install(ctx, "yasr"); err == nil {
+		t.Error("Install should have failed")
+	} else if last := r.ran[len(r.ran)-1]; !last.Privileged || last.String() != "apt-get -y install yasr" {
+		t.Errorf("Unexpected command %s (privileged: %t)",
+			&last,
+			last.Privileged)
+	}
+} // func TestFakeRunner(t *testing.T)
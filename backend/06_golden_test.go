@@ -0,0 +1,244 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/06_golden_test.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The golden tests feed the output of the native tools, captured in
+// testdata/golden/<backend>/*.out, to the backends via a fakeRunner, and
+// compare what the backends make of it to testdata/golden/<backend>/*.json.
+// After changing a parser, run
+//
+//	go test ./backend -run Golden -update
+//
+// and review the diff of the JSON files.
+
+var update = flag.Bool("update", false, "Rewrite the golden files")
+
+// goldenBackends create the backends the golden tests cover.
+var goldenBackends = map[string]func(Runner) PkgManager{
+	"apt":     func(r Runner) PkgManager { return &PkgApt{log: testLog, run: r} },
+	"dnf":     func(r Runner) PkgManager { return &PkgDnf{log: testLog, run: r} },
+	"zypp":    func(r Runner) PkgManager { return &PkgZypp{log: testLog, run: r} },
+	"pacman":  func(r Runner) PkgManager { return &PkgPacman{log: testLog, run: r} },
+	"pkg":     func(r Runner) PkgManager { return &PkgPkg{log: testLog, run: r} },
+	"openbsd": func(r Runner) PkgManager { return &PkgOpenBSD{log: testLog, run: r} },
+}
+
+// fixture is the canned output of one command.
+type fixture struct {
+	cmd  string
+	file string
+	exit int
+}
+
+type goldenCase struct {
+	backend  string
+	name     string
+	fixtures []fixture
+	call     func(context.Context, PkgManager) (any, error)
+}
+
+func search(query string) func(context.Context, PkgManager) (any, error) {
+	return func(ctx context.Context, pk PkgManager) (any, error) {
+		return pk.Search(ctx, query)
+	}
+} // func search(query string) func(context.Context, PkgManager) (any, error)
+
+func info(name string) func(context.Context, PkgManager) (any, error) {
+	return func(ctx context.Context, pk PkgManager) (any, error) {
+		return pk.Info(ctx, name)
+	}
+} // func info(name string) func(context.Context, PkgManager) (any, error)
+
+func listInstalled(ctx context.Context, pk PkgManager) (any, error) {
+	return pk.ListInstalled(ctx)
+} // func listInstalled(ctx context.Context, pk PkgManager) (any, error)
+
+func listUpgrades(security bool) func(context.Context, PkgManager) (any, error) {
+	return func(ctx context.Context, pk PkgManager) (any, error) {
+		return pk.ListUpgrades(ctx, security)
+	}
+} // func listUpgrades(security bool) func(context.Context, PkgManager) (any, error)
+
+var goldenCases = []goldenCase{
+	// apt
+	{"apt", "search", []fixture{
+		{"apt-cache search emacs", "search.out", 0},
+	}, search("emacs")},
+	{"apt", "list", []fixture{
+		{"dpkg-query -W -f " + fmtDpkgQuery, "list.out", 0},
+	}, listInstalled},
+	{"apt", "info-installed", []fixture{
+		{"dpkg-query -W -f " + fmtInfoDpkgQuery + " emacs-nox", "info-installed.out", 0},
+	}, info("emacs-nox")},
+	{"apt", "info-available", []fixture{
+		{"apt-cache show --no-all-versions yasr", "info-available.out", 0},
+	}, info("yasr")},
+	{"apt", "upgrades", []fixture{
+		{"apt-get -s upgrade --with-new-pkgs", "upgrades.out", 0},
+	}, listUpgrades(false)},
+	{"apt", "upgrades-security", []fixture{
+		{"apt-get -s upgrade --with-new-pkgs", "upgrades.out", 0},
+	}, listUpgrades(true)},
+
+	// dnf
+	{"dnf", "search", []fixture{
+		{"dnf search emacs", "search.out", 0},
+	}, search("emacs")},
+	{"dnf", "list", []fixture{
+		{"rpm -qa --qf " + fmtRpmList, "rpm-list.out", 0},
+	}, listInstalled},
+	{"dnf", "info", []fixture{
+		{"dnf repoquery --quiet --installed --qf " + fmtInfoDnf + " emacs", "info.out", 0},
+	}, info("emacs")},
+	{"dnf", "upgrades", []fixture{
+		{"rpm -qa --qf " + fmtRpmList, "rpm-list.out", 0},
+		{"dnf repoquery --quiet --upgrades --latest-limit 1 --qf " + fmtUpgradeDnf, "upgrades.out", 0},
+	}, listUpgrades(false)},
+	{"dnf", "upgrades-security", []fixture{
+		{"rpm -qa --qf " + fmtRpmList, "rpm-list.out", 0},
+		{"dnf --quiet updateinfo list --security", "updateinfo.out", 0},
+	}, listUpgrades(true)},
+
+	// zypper, with some of its messages localized
+	{"zypp", "search", []fixture{
+		{"zypper --xmlout --non-interactive search -t package emacs", "search.out", 0},
+	}, search("emacs")},
+	{"zypp", "search-ru", []fixture{
+		{"zypper --xmlout --non-interactive search -t package emacs", "search-ru.out", 0},
+	}, search("emacs")},
+	{"zypp", "search-none", []fixture{
+		{"zypper --xmlout --non-interactive search -t package emacs", "search-none.out", 104},
+	}, search("emacs")},
+	{"zypp", "list", []fixture{
+		{"rpm -qa --qf " + fmtRpmList, "rpm-list.out", 0},
+	}, listInstalled},
+	{"zypp", "info", []fixture{
+		{"zypper --non-interactive info emacs", "info.out", 0},
+	}, info("emacs")},
+	{"zypp", "upgrades", []fixture{
+		{"zypper --xmlout --non-interactive list-updates", "list-updates.out", 0},
+	}, listUpgrades(false)},
+	{"zypp", "upgrades-old", []fixture{
+		{"zypper --xmlout --non-interactive list-updates", "list-updates-old.out", 0},
+		{"rpm -qa --qf " + fmtRpmList, "rpm-list.out", 0},
+	}, listUpgrades(false)},
+	{"zypp", "upgrades-security", []fixture{
+		{"zypper --xmlout --non-interactive list-patches --category security", "list-patches.out", 0},
+		{"zypper --non-interactive info -t patch openSUSE-SLE-15.4-2023-2345", "patch-info.out", 0},
+		{"rpm -qa --qf " + fmtRpmList, "rpm-list.out", 0},
+	}, listUpgrades(true)},
+
+	// pacman
+	{"pacman", "search", []fixture{
+		{"pacman -Ss emacs", "search.out", 0},
+	}, search("emacs")},
+	{"pacman", "list", []fixture{
+		{"pacman -Q", "list.out", 0},
+	}, listInstalled},
+	{"pacman", "info", []fixture{
+		{"pacman -Qi emacs", "info.out", 0},
+	}, info("emacs")},
+	{"pacman", "info-sync", []fixture{
+		{"pacman -Si emacs-nox", "info-sync.out", 0},
+	}, info("emacs-nox")},
+	{"pacman", "upgrades", []fixture{
+		{"pacman -Qu", "upgrades.out", 0},
+	}, listUpgrades(false)},
+
+	// FreeBSD pkg
+	{"pkg", "search", []fixture{
+		{"pkg rquery -x " + fmtPackagePkg + " emacs", "search.out", 0},
+	}, search("emacs")},
+	{"pkg", "list", []fixture{
+		{"pkg query " + fmtPackagePkg, "list.out", 0},
+	}, listInstalled},
+	{"pkg", "info", []fixture{
+		{"pkg query " + fmtInfoPkg + " emacs", "info.out", 0},
+	}, info("emacs")},
+	{"pkg", "upgrades", []fixture{
+		{"pkg version -vl<", "version.out", 0},
+	}, listUpgrades(false)},
+	{"pkg", "upgrades-security", []fixture{
+		{"pkg audit", "audit.out", 1},
+		{"pkg version -vl<", "version.out", 0},
+	}, listUpgrades(true)},
+
+	// OpenBSD pkg_*, whose package names include the flavor
+	{"openbsd", "search", []fixture{
+		{"pkg_info -Q emacs", "search.out", 0},
+	}, search("emacs")},
+	{"openbsd", "list", []fixture{
+		{"pkg_info", "list.out", 0},
+	}, listInstalled},
+	{"openbsd", "info", []fixture{
+		{"pkg_info emacs", "info.out", 0},
+	}, info("emacs")},
+	{"openbsd", "info-remote", []fixture{
+		{"pkg_info mg", "info-remote.out", 0},
+	}, info("mg")},
+	{"openbsd", "upgrades", []fixture{
+		{"pkg_add -u -n", "upgrades.out", 0},
+	}, listUpgrades(false)},
+}
+
+func TestGolden(t *testing.T) {
+	for _, c := range goldenCases {
+		t.Run(c.backend+"/"+c.name, func(t *testing.T) {
+			var (
+				err    error
+				res    any
+				actual []byte
+				dir    = filepath.Join("testdata", "golden", c.backend)
+				golden = filepath.Join(dir, c.name+".json")
+				r      = &fakeRunner{results: make(map[string]*Result)}
+			)
+
+			for _, f := range c.fixtures {
+				var raw []byte
+
+				if raw, err = os.ReadFile(filepath.Join(dir, f.file)); err != nil {
+					t.Fatalf("Cannot read fixture: %s", err.Error())
+				}
+
+				r.results[f.cmd] = &Result{Stdout: string(raw), ExitCode: f.exit}
+			}
+
+			if res, err = c.call(context.Background(), goldenBackends[c.backend](r)); err != nil {
+				t.Fatalf("%s failed: %s", c.name, err.Error())
+			} else if actual, err = json.MarshalIndent(res, "", "  "); err != nil {
+				t.Fatalf("Cannot serialize result: %s", err.Error())
+			}
+
+			actual = append(actual, '\n')
+
+			if *update {
+				if err = os.WriteFile(golden, actual, 0644); err != nil {
+					t.Fatalf("Cannot write %s: %s", golden, err.Error())
+				}
+				return
+			}
+
+			var expected []byte
+
+			if expected, err = os.ReadFile(golden); err != nil {
+				t.Fatalf("Cannot read golden file: %s", err.Error())
+			} else if !bytes.Equal(actual, expected) {
+				t.Errorf("Result does not match %s:\n%s", golden, actual)
+			}
+		})
+	}
+} // func TestGolden(t *testing.T)
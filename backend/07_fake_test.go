@@ -0,0 +1,107 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/07_fake_test.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/blicero/pkman/database/action"
+	"github.com/blicero/pkman/database/event"
+)
+
+func loadDemo(t *testing.T) *PkgFake {
+	var cat, err = LoadFakeCatalog(filepath.Join("testdata", "fake", "demo.yaml"))
+
+	if err != nil {
+		t.Fatalf("Cannot load catalog: %s", err.Error())
+	}
+
+	return NewPkgFake(testLog, cat)
+} // func loadDemo(t *testing.T) *PkgFake
+
+func TestFakeInstall(t *testing.T) {
+	var (
+		err    error
+		plan   *Plan
+		pkList []Package
+		ctx    = context.Background()
+		pk     = loadDemo(t)
+	)
+
+	if plan, err = pk.Preview(ctx, event.Add, "emacs"); err != nil {
+		t.Fatalf("Preview failed: %s", err.Error())
+	} else if plan.Count(action.Install) != 3 {
+		t.Errorf("Expected 3 packages to be installed, got %v", plan.Changes)
+	} else if plan.Changes[0].Name != "emacs-common" {
+		t.Errorf("Dependencies should be installed first, got %v", plan.Changes)
+	} else if pkList, _ = pk.ListInstalled(ctx); len(pkList) != 3 {
+		t.Errorf("Preview changed the installed packages: %v", pkList)
+	}
+
+	if err = pk.Install(ctx, "emacs"); err != nil {
+		t.Fatalf("Install failed: %s", err.Error())
+	} else if pkList, _ = pk.ListInstalled(ctx); len(pkList) != 6 {
+		t.Errorf("Expected 6 installed packages, got %v", pkList)
+	}
+
+	if plan, err = pk.Preview(ctx, event.Delete, "libc6"); err != nil {
+		t.Fatalf("Preview failed: %s", err.Error())
+	} else if plan.Count(action.Remove) != 4 {
+		t.Errorf("Removing libc6 should remove 4 packages, got %v", plan.Changes)
+	} else if plan.DiskDelta == nil || *plan.DiskDelta >= 0 {
+		t.Errorf("Removing packages should free space, DiskDelta is %s", fmtSize(plan.DiskDelta))
+	}
+
+	if err = pk.Install(ctx, "no-such-package"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Installing an unknown package should fail with ErrNotFound, got %v", err)
+	}
+} // func TestFakeInstall(t *testing.T)
+
+func TestFakeUpgrades(t *testing.T) {
+	var (
+		err    error
+		upList []PendingUpgrade
+		ctx    = context.Background()
+		pk     = loadDemo(t)
+	)
+
+	if upList, err = pk.ListUpgrades(ctx, false); err != nil {
+		t.Fatalf("ListUpgrades failed: %s", err.Error())
+	} else if len(upList) != 2 {
+		t.Errorf("Expected 2 upgrades, got %v", upList)
+	} else if upList, _ = pk.ListUpgrades(ctx, true); len(upList) != 1 || upList[0].Name != "libc6" {
+		t.Errorf("Expected a security upgrade of libc6, got %v", upList)
+	}
+
+	if err = pk.Upgrade(ctx, true); err != nil {
+		t.Fatalf("Upgrade failed: %s", err.Error())
+	} else if upList, _ = pk.ListUpgrades(ctx, false); len(upList) != 1 || upList[0].Name != "tzdata" {
+		t.Errorf("Expected tzdata to be left over, got %v", upList)
+	}
+} // func TestFakeUpgrades(t *testing.T)
+
+func TestFakeFailures(t *testing.T) {
+	var (
+		err  error
+		cerr *CmdError
+		ctx  = context.Background()
+		pk   = loadDemo(t)
+	)
+
+	if err = pk.Install(ctx, "yasr"); !errors.Is(err, ErrLocked) {
+		t.Errorf("First install should fail with ErrLocked, got %v", err)
+	} else if err = pk.Install(ctx, "yasr"); !errors.Is(err, ErrNetwork) {
+		t.Errorf("Second install should fail with ErrNetwork, got %v", err)
+	} else if !errors.As(err, &cerr) || cerr.Stderr == "" {
+		t.Errorf("Expected a *CmdError with a message, got %#v", err)
+	} else if err = pk.Install(ctx, "mg"); err != nil {
+		t.Errorf("Failures for yasr should not affect mg: %s", err.Error())
+	}
+} // func TestFakeFailures(t *testing.T)
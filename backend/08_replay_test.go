@@ -0,0 +1,130 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/08_replay_test.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	var (
+		err              error
+		rec              *RecordingRunner
+		rr               *ReplayRunner
+		recorded, replay []Package
+		res              *Result
+		manifest         []byte
+		ctx              = context.Background()
+		dir              = t.TempDir()
+		fake             = &fakeRunner{
+			results: map[string]*Result{
+				"apt-cache search yasr": {
+					Stdout: "yasr - General-purpose console screen reader\n",
+				},
+				"apt-get -y install yasr": {
+					Stderr:   "E: Could not get lock /var/lib/dpkg/lock-frontend\n",
+					ExitCode: 100,
+				},
+			},
+		}
+	)
+
+	if rec, err = NewRecordingRunner(fake, dir, "Debian GNU/Linux", "12"); err != nil {
+		t.Fatalf("Cannot create RecordingRunner: %s", err.Error())
+	} else if recorded, err = (&PkgApt{log: testLog, run: rec}).Search(ctx, "yasr"); err != nil {
+		t.Fatalf("Search failed: %s", err.Error())
+	} else if err = (&PkgApt{log: testLog, run: rec}).Install(ctx, "yasr"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Install should fail with ErrLocked, got %v", err)
+	}
+
+	// Later recordings in the same directory are added, and leave the
+	// manifest alone.
+	fake.results["apt-cache search yasr"] = &Result{}
+
+	if manifest, err = os.ReadFile(filepath.Join(dir, manifestFile)); err != nil {
+		t.Fatalf("Cannot read manifest: %s", err.Error())
+	} else if rec, err = NewRecordingRunner(fake, dir, "Debian GNU/Linux", "13"); err != nil {
+		t.Fatalf("Cannot create RecordingRunner: %s", err.Error())
+	} else if _, err = rec.Run(ctx, &Command{Path: "apt-cache", Args: []string{"search", "yasr"}}); err != nil {
+		t.Fatalf("Search failed: %s", err.Error())
+	}
+
+	if rr, err = NewReplayRunner(dir); err != nil {
+		t.Fatalf("Cannot load recording: %s", err.Error())
+	} else if rr.Manifest.System != "Debian GNU/Linux" {
+		t.Errorf("Unexpected system %q in manifest", rr.Manifest.System)
+	} else if raw, _ := os.ReadFile(filepath.Join(dir, manifestFile)); !bytes.Equal(raw, manifest) {
+		t.Errorf("Manifest was rewritten:\n%s\nexpected:\n%s", raw, manifest)
+	}
+
+	var pk = &PkgApt{log: testLog, run: rr}
+
+	if replay, err = pk.Search(ctx, "yasr"); err != nil {
+		t.Errorf("Replayed search failed: %s", err.Error())
+	} else if !reflect.DeepEqual(recorded, replay) {
+		t.Errorf("Replayed search returned %v, recorded %v", replay, recorded)
+	}
+
+	// The second recording of the search came up empty, and is repeated.
+	for i := 0; i < 2; i++ {
+		if res, err = rr.Run(ctx, &Command{Path: "apt-cache", Args: []string{"search", "yasr"}}); err != nil {
+			t.Errorf("Replayed search failed: %s", err.Error())
+		} else if res.Stdout != "" {
+			t.Errorf("Expected the second recording, got %q", res.Stdout)
+		}
+	}
+
+	if err = pk.Install(ctx, "yasr"); !errors.Is(err, ErrLocked) {
+		t.Errorf("Replayed install should fail with ErrLocked, got %v", err)
+	} else if err = pk.Remove(ctx, "yasr"); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("Unrecorded command should fail with ErrNotRecorded, got %v", err)
+	}
+} // func TestRecordReplay(t *testing.T)
+
+// TestReplayArgv checks that commands are told apart by their arguments, not
+// by what they look like when joined with spaces.
+func TestReplayArgv(t *testing.T) {
+	var (
+		err  error
+		rec  *RecordingRunner
+		rr   *ReplayRunner
+		res  *Result
+		ctx  = context.Background()
+		dir  = t.TempDir()
+		fake = &fakeRunner{
+			results: map[string]*Result{
+				"pkg query -e %k = 1 %n": {Stdout: "vim\n"},
+			},
+		}
+		split  = &Command{Path: "pkg", Args: []string{"query", "-e", "%k", "=", "1", "%n"}}
+		joined = &Command{Path: "pkg", Args: []string{"query", "-e", "%k = 1", "%n"}}
+	)
+
+	if rec, err = NewRecordingRunner(fake, dir, "FreeBSD", "14.1"); err != nil {
+		t.Fatalf("Cannot create RecordingRunner: %s", err.Error())
+	} else if _, err = rec.Run(ctx, split); err != nil {
+		t.Fatalf("Running %s failed: %s", split, err.Error())
+	} else if rr, err = NewReplayRunner(dir); err != nil {
+		t.Fatalf("Cannot load recording: %s", err.Error())
+	}
+
+	if res, err = rr.Run(ctx, split); err != nil {
+		t.Errorf("Replaying %s failed: %s", split, err.Error())
+	} else if res.Stdout != "vim\n" {
+		t.Errorf("Unexpected output %q", res.Stdout)
+	}
+
+	if _, err = rr.Run(ctx, joined); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("%q should fail with ErrNotRecorded, got %v", joined.Args, err)
+	}
+} // func TestReplayArgv(t *testing.T)
@@ -0,0 +1,109 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/09_progress_test.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/blicero/pkman/backend/stage"
+)
+
+func TestParseProgress(t *testing.T) {
+	type testCase struct {
+		cmd         string
+		line        string
+		stage       stage.ID
+		pkg         string
+		done, total int
+	}
+
+	var cases = []testCase{
+		{cmdAptGet, "Get:1 http://deb.debian.org/debian bookworm/main amd64 emacs-common all 1:28.2+1-15 [13.6 MB]", stage.Download, "emacs-common", 0, 0},
+		{cmdAptGet, "Unpacking emacs-common (1:28.2+1-15) ...", stage.Install, "emacs-common", 0, 0},
+		{cmdAptGet, "Unpacking libc6:amd64 (2.36-9+deb12u3) over (2.36-9+deb12u1) ...", stage.Upgrade, "libc6", 0, 0},
+		{cmdAptGet, "Removing yasr (0.6.9-10) ...", stage.Remove, "yasr", 0, 0},
+		{cmdAptGet, "Setting up emacs-common (1:28.2+1-15) ...", stage.Output, "", 0, 0},
+		{cmdAptGet, "1 upgraded, 3 newly installed, 1 downgraded, 2 to remove and 0 not upgraded.", stage.Output, "", 0, 7},
+		{cmdDnf, "(1/3): emacs-common-28.2-3.fc38.x86_64.rpm      6.1 MB/s |  38 MB     00:06", stage.Download, "emacs-common", 1, 3},
+		{cmdDnf, "  Installing       : emacs-common-1:28.2-3.fc38.x86_64                     1/3", stage.Install, "emacs-common", 1, 3},
+		{cmdDnf, "  Erasing          : yasr-0.6.9-10.fc38.x86_64                             3/3", stage.Remove, "yasr", 3, 3},
+		{cmdDnf, "  Verifying        : yasr-0.6.9-10.fc38.x86_64                             3/3", stage.Output, "", 0, 0},
+		{cmdZypper, "Retrieving: emacs-x11-27.2-150400.3.3.1.x86_64 (Main Update Repository) (1/2),   2.4 MiB", stage.Download, "emacs-x11", 1, 2},
+		{cmdZypper, "(1/2) Installing: emacs-x11-27.2-150400.3.3.1.x86_64 ..........................[done]", stage.Install, "emacs-x11", 1, 2},
+		{cmdZypper, "(2/2) Removing yasr-0.6.9-10.1.x86_64 .........................................[done]", stage.Remove, "yasr", 2, 2},
+		{cmdPacman, " emacs-29.1-3-x86_64 downloading...", stage.Download, "emacs", 0, 0},
+		{cmdPacman, "(2/2) installing emacs                               [######################] 100%", stage.Install, "emacs", 2, 2},
+		{cmdPacman, "removing mg...", stage.Remove, "mg", 0, 0},
+		{cmdPkg, "[1/2] Fetching emacs-28.2_4,3.pkg: .......... done", stage.Download, "emacs", 1, 2},
+		{cmdPkg, "[2/2] Upgrading bash from 5.2.15 to 5.2.15_1...", stage.Upgrade, "bash", 2, 2},
+		{cmdPkg, "[1/1] Deinstalling yasr-0.6.9_1...", stage.Remove, "yasr", 1, 1},
+		{cmdPkgAdd, "curl-8.0.1->8.1.1: ok", stage.Upgrade, "curl", 0, 0},
+		{cmdPkgAdd, "mg-20230406: ok", stage.Install, "mg", 0, 0},
+		{cmdPkgDelete, "mg-20230406: ok", stage.Remove, "mg", 0, 0},
+	}
+
+	for _, c := range cases {
+		var p = progressParsers[c.cmd](c.line)
+
+		if p == nil {
+			p = &Progress{Stage: stage.Output}
+		}
+
+		if p.Stage != c.stage || p.Package != c.pkg || p.Done != c.done || p.Total != c.total {
+			t.Errorf("%s: unexpected result for %q: %s %q %d/%d",
+				c.cmd,
+				c.line,
+				p.Stage,
+				p.Package,
+				p.Done,
+				p.Total)
+		}
+	}
+} // func TestParseProgress(t *testing.T)
+
+func TestProgressWriter(t *testing.T) {
+	var (
+		err    error
+		events []*Progress
+		ctx    = WithProgress(context.Background(), func(p *Progress) { events = append(events, p) })
+		pk     = &PkgApt{
+			log: testLog,
+			run: &fakeRunner{
+				results: map[string]*Result{
+					"apt-get -y install emacs": {
+						Stdout: "0 upgraded, 2 newly installed, 0 to remove and 0 not upgraded.\n" +
+							"Reading database ... 10%\rReading database ... 100%\n\n" +
+							"Unpacking emacs-common (1:28.2+1-15) ...\n" +
+							"Unpacking emacs (1:28.2+1-15) ...",
+					},
+				},
+			},
+		}
+	)
+
+	if err = pk.Install(ctx, "emacs"); err != nil {
+		t.Fatalf("Install failed: %s", err.Error())
+	} else if len(events) != 5 {
+		t.Fatalf("Expected 5 events, got %d", len(events))
+	} else if events[2].Line != "Reading database ... 100%" {
+		t.Errorf("A carriage return should end a line, got %q", events[2].Line)
+	}
+
+	for i, name := range []string{"emacs-common", "emacs"} {
+		var p = events[i+3]
+
+		if p.Stage != stage.Install || p.Package != name || p.Done != i+1 || p.Total != 2 {
+			t.Errorf("Unexpected event for %q: %s %q %d/%d",
+				p.Line,
+				p.Stage,
+				p.Package,
+				p.Done,
+				p.Total)
+		}
+	}
+} // func TestProgressWriter(t *testing.T)
@@ -0,0 +1,231 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/10_history_test.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blicero/pkman/common"
+	"github.com/blicero/pkman/database"
+	"github.com/blicero/pkman/database/action"
+	"github.com/blicero/pkman/database/event"
+)
+
+func TestRecordHistory(t *testing.T) {
+	var (
+		err    error
+		db     *database.Database
+		pk     PkgManager
+		evList []event.Event
+		ctx    = context.Background()
+		fake   = loadDemo(t)
+	)
+
+	if db, err = database.OpenDB(common.DbPath); err != nil {
+		t.Fatalf("Cannot open database: %s", err.Error())
+	}
+
+	defer db.Close()
+
+	fake.db = db
+
+	if pk, err = recordHistory(fake); err != nil {
+		t.Fatalf("Cannot record history: %s", err.Error())
+	} else if err = pk.Install(ctx, "mg"); err != nil {
+		t.Fatalf("Install failed: %s", err.Error())
+	} else if err = pk.Install(ctx, "yasr"); err == nil {
+		t.Fatal("Install of yasr should fail")
+	} else if _, err = pk.Search(ctx, "mg"); err != nil {
+		t.Fatalf("Search failed: %s", err.Error())
+	} else if evList, err = db.EventGetRecent(ctx, 10); err != nil {
+		t.Fatalf("Cannot load events: %s", err.Error())
+	} else if len(evList) != 2 {
+		t.Fatalf("Expected 2 events, got %d: %v", len(evList), evList)
+	}
+
+	// Both events may have the same timestamp, so we do not rely on the
+	// order.
+	for _, ev := range evList {
+		var (
+			pkgs   = []string{"mg"}
+			status = int64(0)
+		)
+
+		if len(ev.Packages) == 1 && ev.Packages[0] == "yasr" {
+			pkgs, status = []string{"yasr"}, 1
+		}
+
+		if ev.Type != event.Add ||
+			ev.Status != status ||
+			ev.Command != "fake install "+pkgs[0] ||
+			ev.User == "" ||
+			!reflect.DeepEqual(ev.Packages, pkgs) {
+			t.Errorf("Unexpected event: %#v", ev)
+		}
+	}
+} // func TestRecordHistory(t *testing.T)
+
+func TestRecordChanges(t *testing.T) {
+	var (
+		err     error
+		db      *database.Database
+		pk      PkgManager
+		evList  []event.Event
+		chgList []event.Change
+		ev      *event.Event
+		ctx     = context.Background()
+		fake    = loadDemo(t)
+		names   = []string{"emacs", "emacs-common", "emacs-gtk"}
+	)
+
+	if db, err = database.OpenDB(common.DbPath); err != nil {
+		t.Fatalf("Cannot open database: %s", err.Error())
+	}
+
+	defer db.Close()
+
+	fake.db = db
+
+	if pk, err = recordHistory(fake); err != nil {
+		t.Fatalf("Cannot record history: %s", err.Error())
+	} else if err = pk.Install(ctx, "emacs"); err != nil {
+		t.Fatalf("Install failed: %s", err.Error())
+	} else if evList, err = db.EventGetRecent(ctx, 1); err != nil {
+		t.Fatalf("Cannot load events: %s", err.Error())
+	} else if len(evList) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(evList))
+	} else if chgList, err = db.ChangeGetByEvent(ctx, evList[0].ID); err != nil {
+		t.Fatalf("Cannot load changes: %s", err.Error())
+	} else if len(chgList) != len(names) {
+		t.Fatalf("Expected %d changes, got %d: %v", len(names), len(chgList), chgList)
+	}
+
+	for i, c := range chgList {
+		if c.Package != names[i] ||
+			c.Action != action.Install ||
+			c.OldVersion != "" ||
+			c.NewVersion != "1:28.2+1-15" {
+			t.Errorf("Unexpected change #%d: %#v", i, c)
+		}
+	}
+
+	if ev, err = db.EventGetByID(ctx, evList[0].ID); err != nil {
+		t.Fatalf("Cannot load event: %s", err.Error())
+	} else if ev.Output != "(1/3) Install emacs-common\n(2/3) Install emacs-gtk\n(3/3) Install emacs\n" {
+		t.Errorf("Unexpected output: %q", ev.Output)
+	}
+} // func TestRecordChanges(t *testing.T)
+
+func TestDiffInstalled(t *testing.T) {
+	var (
+		before = []Package{
+			{Name: "libc6", Version: "2.36-9+deb12u1"},
+			{Name: "mg", Version: "20230406-1"},
+			{Name: "tzdata", Version: "2024a-0+deb12u1"},
+		}
+		after = []Package{
+			{Name: "libc6", Version: "2.36-9+deb12u3"},
+			{Name: "tzdata", Version: "2023c-5"},
+			{Name: "yasr", Version: "0.6.9-11"},
+		}
+		expect = []event.Change{
+			{Package: "libc6", Action: action.Upgrade, OldVersion: "2.36-9+deb12u1", NewVersion: "2.36-9+deb12u3"},
+			{Package: "mg", Action: action.Remove, OldVersion: "20230406-1"},
+			{Package: "tzdata", Action: action.Downgrade, OldVersion: "2024a-0+deb12u1", NewVersion: "2023c-5"},
+			{Package: "yasr", Action: action.Install, NewVersion: "0.6.9-11"},
+		}
+	)
+
+	if changes := diffInstalled(before, after); !reflect.DeepEqual(changes, expect) {
+		t.Errorf("Unexpected changes:\nexpected: %v\ngot:      %v", expect, changes)
+	}
+
+	if changes := diffInstalled(before, before); len(changes) != 0 {
+		t.Errorf("Expected no changes, got %v", changes)
+	}
+} // func TestDiffInstalled(t *testing.T)
+
+func TestLogOutput(t *testing.T) {
+	var (
+		cl   = new(commandLog)
+		ctx  = context.WithValue(context.Background(), commandLogKey{}, cl)
+		line = strings.Repeat("x", 1023) + "\n"
+	)
+
+	logOutput(context.Background(), line)
+
+	for i := 0; i < 2*maxOutput/len(line); i++ {
+		logOutput(ctx, line)
+	}
+
+	logOutput(ctx, "done\n")
+
+	if out := cl.Output(); len(out) > maxOutput+len("[...]\n") {
+		t.Errorf("Output was not truncated: %d bytes", len(out))
+	} else if !strings.HasPrefix(out, "[...]\n"+line) || !strings.HasSuffix(out, line+"done\n") {
+		t.Errorf("Output was not truncated at the start of a line")
+	}
+} // func TestLogOutput(t *testing.T)
+
+// TestLastUpdate checks that LastUpdate goes by the newest successful
+// refresh or upgrade in the history, for backends that cannot tell
+// otherwise.
+func TestLastUpdate(t *testing.T) {
+	var (
+		err   error
+		db    *database.Database
+		pk    PkgManager
+		stamp time.Time
+		ctx   = context.Background()
+		later = time.Now().Add(time.Hour).Truncate(time.Second)
+		r     = &fakeRunner{
+			results: map[string]*Result{"apt-get update": {}},
+		}
+	)
+
+	if db, err = database.OpenDB(filepath.Join(t.TempDir(), "pkman.db")); err != nil {
+		t.Fatalf("Cannot open database: %s", err.Error())
+	}
+
+	defer db.Close()
+
+	if pk, err = recordHistory(&PkgApt{log: testLog, db: db, run: r}); err != nil {
+		t.Fatalf("Cannot record history: %s", err.Error())
+	} else if _, err = pk.LastUpdate(ctx); err == nil {
+		t.Error("LastUpdate should fail without any updates in the history")
+	}
+
+	if err = pk.Update(ctx); err != nil {
+		t.Fatalf("Update failed: %s", err.Error())
+	} else if stamp, err = pk.LastUpdate(ctx); err != nil {
+		t.Fatalf("LastUpdate failed: %s", err.Error())
+	} else if !common.TimeEqual(stamp, time.Now()) {
+		t.Errorf("Expected LastUpdate to return about now, got %s", stamp)
+	}
+
+	// A failed upgrade does not count.
+	if err = db.EventAdd(ctx, &event.Event{Type: event.Update, Timestamp: later, End: later, Status: 100}); err != nil {
+		t.Fatalf("Cannot add event: %s", err.Error())
+	} else if stamp, err = pk.LastUpdate(ctx); err != nil {
+		t.Fatalf("LastUpdate failed: %s", err.Error())
+	} else if stamp.Equal(later) {
+		t.Error("LastUpdate returned the time of a failed upgrade")
+	}
+
+	if err = db.EventAdd(ctx, &event.Event{Type: event.Update, Timestamp: later.Add(-time.Minute), End: later}); err != nil {
+		t.Fatalf("Cannot add event: %s", err.Error())
+	} else if stamp, err = pk.LastUpdate(ctx); err != nil {
+		t.Fatalf("LastUpdate failed: %s", err.Error())
+	} else if !stamp.Equal(later) {
+		t.Errorf("Expected LastUpdate to return %s, got %s", later, stamp)
+	}
+} // func TestLastUpdate(t *testing.T)
@@ -0,0 +1,184 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/11_exclude_test.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/blicero/pkman/database/action"
+	"github.com/blicero/pkman/database/event"
+)
+
+// TestExcludeNative checks that the native backends tell the package manager
+// to skip excluded packages when upgrading, and that those that hold
+// packages to do so release them afterwards, but leave alone the ones that
+// were held already.
+func TestExcludeNative(t *testing.T) {
+	type testCase struct {
+		backend  string
+		exclude  []string
+		fixtures []fixture
+		held     map[string]string
+		expect   []string
+	}
+
+	var cases = []testCase{
+		{
+			backend:  "apt",
+			exclude:  []string{"bash", "vim"},
+			fixtures: []fixture{{"dpkg-query -W -f " + fmtDpkgQuery, "list.out", 0}},
+			held:     map[string]string{"apt-mark showhold": "vim\n"},
+			expect: []string{
+				"dpkg-query -W -f " + fmtDpkgQuery,
+				"apt-mark showhold",
+				"apt-mark hold bash",
+				"apt-get -y upgrade --with-new-pkgs",
+				"apt-mark unhold bash",
+			},
+		},
+		{
+			backend: "dnf",
+			exclude: []string{"glibc*"},
+			expect:  []string{"dnf --exclude=glibc* -y upgrade"},
+		},
+		{
+			backend:  "zypp",
+			exclude:  []string{"curl", "emacs"},
+			fixtures: []fixture{{"rpm -qa --qf " + fmtRpmList, "rpm-list.out", 0}},
+			held: map[string]string{
+				"zypper locks": "# | Name  | Matches | Type    | Repository\n" +
+					"--+-------+---------+---------+-----------\n" +
+					"1 | emacs | 1       | package | (any)\n",
+			},
+			expect: []string{
+				"rpm -qa --qf " + fmtRpmList,
+				"zypper locks",
+				"zypper --non-interactive addlock curl",
+				"zypper --non-interactive update",
+				"zypper --non-interactive removelock curl",
+			},
+		},
+		{
+			backend: "pacman",
+			exclude: []string{"archlinux-keyring", "linux*"},
+			expect:  []string{"pacman -Su --ignore archlinux-keyring,linux* --noconfirm"},
+		},
+		{
+			backend:  "pkg",
+			exclude:  []string{"py39-*"},
+			fixtures: []fixture{{"pkg query " + fmtPackagePkg, "list.out", 0}},
+			held:     map[string]string{"pkg query -e %k = 1 %n": ""},
+			expect: []string{
+				"pkg query " + fmtPackagePkg,
+				"pkg query -e %k = 1 %n",
+				"pkg lock -y py39-pip",
+				"pkg upgrade -y",
+				"pkg unlock -y py39-pip",
+			},
+		},
+		{
+			backend:  "openbsd",
+			exclude:  []string{"py3-*"},
+			fixtures: []fixture{{"pkg_info", "list.out", 0}},
+			expect: []string{
+				"pkg_info",
+				"pkg_add -I -u bzip2 emacs gettext-runtime",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.backend, func(t *testing.T) {
+			var (
+				err error
+				ran []string
+				ctx = WithExclude(context.Background(), c.exclude)
+				r   = &fakeRunner{results: make(map[string]*Result)}
+			)
+
+			for _, f := range c.fixtures {
+				var raw []byte
+
+				if raw, err = os.ReadFile(filepath.Join("testdata", "golden", c.backend, f.file)); err != nil {
+					t.Fatalf("Cannot read fixture: %s", err.Error())
+				}
+
+				r.results[f.cmd] = &Result{Stdout: string(raw)}
+			}
+
+			for cmd, out := range c.held {
+				r.results[cmd] = &Result{Stdout: out}
+			}
+
+			for _, cmd := range c.expect {
+				if _, ok := r.results[cmd]; !ok {
+					r.results[cmd] = &Result{}
+				}
+			}
+
+			if err = goldenBackends[c.backend](r).Upgrade(ctx, false); err != nil {
+				t.Errorf("Upgrade failed: %s", err.Error())
+			}
+
+			for _, cmd := range r.ran {
+				ran = append(ran, cmd.String())
+			}
+
+			if !reflect.DeepEqual(ran, c.expect) {
+				t.Errorf("Unexpected commands:\n%q\nexpected:\n%q",
+					ran,
+					c.expect)
+			}
+		})
+	}
+} // func TestExcludeNative(t *testing.T)
+
+// TestExcludeRemove checks that excludes do not get in the way of removing
+// packages.
+func TestExcludeRemove(t *testing.T) {
+	var (
+		err error
+		ctx = WithExclude(context.Background(), []string{"vim"})
+		r   = &fakeRunner{
+			results: map[string]*Result{"apt-get -y remove vim": {}},
+		}
+		pk = &PkgApt{log: testLog, run: r}
+	)
+
+	if err = pk.Remove(ctx, "vim"); err != nil {
+		t.Errorf("Remove failed: %s", err.Error())
+	} else if len(r.ran) != 1 {
+		t.Errorf("Expected a single command, got %v", r.ran)
+	}
+} // func TestExcludeRemove(t *testing.T)
+
+func TestExcludeFake(t *testing.T) {
+	var (
+		err  error
+		plan *Plan
+		pk   = loadDemo(t)
+		ctx  = WithExclude(context.Background(), []string{"tz*"})
+	)
+
+	if plan, err = pk.Preview(ctx, event.Update); err != nil {
+		t.Fatalf("Preview failed: %s", err.Error())
+	} else if plan.Count(action.Upgrade) != 1 || plan.Changes[0].Name != "libc6" {
+		t.Errorf("Expected an upgrade of libc6 only, got %v", plan.Changes)
+	}
+
+	if err = pk.Upgrade(ctx, false); err != nil {
+		t.Fatalf("Upgrade failed: %s", err.Error())
+	} else if p := pk.pkgs["tzdata"]; !p.hasUpgrade() {
+		t.Error("tzdata was upgraded, even though it is excluded")
+	} else if p = pk.pkgs["libc6"]; p.hasUpgrade() {
+		t.Error("libc6 was not upgraded")
+	}
+} // func TestExcludeFake(t *testing.T)
@@ -0,0 +1,81 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/conformance/01_backends_test.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package conformance
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/blicero/pkman/backend"
+	"github.com/blicero/pkman/database/event"
+)
+
+// The native backends replay recordings of the suite from
+// testdata/<backend>. These are synthetic, written by hand after the
+// documented output of each tool, until someone replaces them with real
+// recordings made with -record. The fake backend works on
+// testdata/fake.yaml.
+var inTree = []struct {
+	name    string
+	backend string
+	fx      Fixture
+}{
+	{"apt", "replay:" + filepath.Join("testdata", "apt"), Fixture{
+		Available: "yasr",
+		Missing:   "no-such-package",
+		Security:  true,
+		Preview:   []event.ID{event.Add, event.Delete, event.Update},
+	}},
+	{"dnf", "replay:" + filepath.Join("testdata", "dnf"), Fixture{
+		Available: "mg",
+		Missing:   "no-such-package",
+		Security:  true,
+		Preview:   []event.ID{event.Add, event.Delete, event.Update},
+	}},
+	{"zypp", "replay:" + filepath.Join("testdata", "zypp"), Fixture{
+		Available: "mg",
+		Missing:   "no-such-package",
+		Security:  true,
+		Preview:   []event.ID{event.Add, event.Delete, event.Update},
+	}},
+	{"pacman", "replay:" + filepath.Join("testdata", "pacman"), Fixture{
+		Available: "mg",
+		Missing:   "no-such-package",
+		Preview:   []event.ID{event.Add, event.Delete, event.Update},
+	}},
+	{"pkg", "replay:" + filepath.Join("testdata", "pkg"), Fixture{
+		Available: "mg",
+		Missing:   "no-such-package",
+		Security:  true,
+		Preview:   []event.ID{event.Add, event.Delete, event.Update},
+	}},
+	{"openbsd", "replay:" + filepath.Join("testdata", "openbsd"), Fixture{
+		Available: "mg",
+		Missing:   "no-such-package",
+		Preview:   []event.ID{event.Add, event.Delete, event.Update},
+	}},
+	{"fake", "fake:" + filepath.Join("testdata", "fake.yaml"), Fixture{
+		Available: "yasr",
+		Missing:   "no-such-package",
+		Security:  true,
+		Preview:   []event.ID{event.Add, event.Delete, event.Update},
+	}},
+}
+
+func TestInTree(t *testing.T) {
+	for _, b := range inTree {
+		t.Run(b.name, func(t *testing.T) {
+			var pk, err = backend.GetPkgManager(b.backend, nil)
+
+			if err != nil {
+				t.Fatalf("Cannot create backend %s: %s", b.backend, err.Error())
+			}
+
+			Run(t, pk, b.fx)
+		})
+	}
+} // func TestInTree(t *testing.T)
@@ -0,0 +1,363 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/conformance/conformance.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+// Package conformance provides a test suite that checks if a
+// backend.PkgManager behaves the way the rest of pkman expects it to:
+// Search, Info, Install, Remove and ListInstalled agree with each other,
+// installing and removing are idempotent, failures are reported as typed
+// errors, and the backend supports what it claims to support.
+//
+// A backend's tests call Run with a PkgManager and a Fixture describing what
+// to expect of it:
+//
+//	func TestConformance(t *testing.T) {
+//		var pk, err = backend.GetPkgManager("replay:testdata/mybackend", nil)
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		conformance.Run(t, pk, conformance.Fixture{
+//			Available: "yasr",
+//			Missing:   "no-such-package",
+//			Preview:   []event.ID{event.Add, event.Delete, event.Update},
+//		})
+//	}
+//
+// The suite installs and removes packages, so unless the fixture is ReadOnly,
+// run it against a recording (see backend.ReplayRunner), the fake backend, or
+// a throwaway system, never against a system you care about.
+package conformance
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/blicero/pkman/backend"
+	"github.com/blicero/pkman/database/action"
+	"github.com/blicero/pkman/database/event"
+)
+
+// Fixture tells the suite what to expect of the PkgManager under test.
+type Fixture struct {
+	// Available is the name of a package the repositories offer, which
+	// is not installed when the suite starts.
+	Available string
+	// Missing is the name of a package that does not exist anywhere.
+	Missing string
+	// ReadOnly skips the checks that install and remove packages.
+	ReadOnly bool
+	// Security claims that ListUpgrades can single out security updates.
+	Security bool
+	// Preview lists the operations Preview supports. For all others, it
+	// must fail with backend.ErrUnsupported.
+	Preview []event.ID
+}
+
+// Run runs the suite against pk.
+// The checks run one after another, in a fixed order, on the same
+// PkgManager, so the native commands it runs are the same every time, and pk
+// may replay a recording of them. The order is: Search, Info, Install,
+// Remove, Upgrades, Preview. Install and Remove are skipped if the fixture
+// is ReadOnly.
+func Run(t *testing.T, pk backend.PkgManager, fx Fixture) {
+	var s = &suite{pk: pk, fx: fx}
+
+	t.Helper()
+
+	if fx.Available == "" || fx.Missing == "" {
+		t.Fatal("The fixture needs an Available and a Missing package")
+	}
+
+	t.Run("Search", s.search)
+	t.Run("Info", s.info)
+
+	if !fx.ReadOnly {
+		t.Run("Install", s.install)
+		t.Run("Remove", s.remove)
+	}
+
+	t.Run("Upgrades", s.upgrades)
+	t.Run("Preview", s.preview)
+} // func Run(t *testing.T, pk backend.PkgManager, fx Fixture)
+
+type suite struct {
+	pk backend.PkgManager
+	fx Fixture
+}
+
+// errorKinds are the errors a PkgManager's errors may wrap, besides a
+// *backend.CmdError, for the CLI to make sense of them.
+var errorKinds = []error{
+	backend.ErrNotFound,
+	backend.ErrLocked,
+	backend.ErrPermission,
+	backend.ErrNetwork,
+	backend.ErrConflict,
+	backend.ErrDiskFull,
+	backend.ErrUnsupported,
+	context.Canceled,
+	context.DeadlineExceeded,
+}
+
+// checkError fails the test unless err is nil, a *backend.CmdError, or wraps
+// one of the errorKinds.
+func checkError(t *testing.T, what string, err error) {
+	var cerr *backend.CmdError
+
+	t.Helper()
+
+	if err == nil || errors.As(err, &cerr) {
+		return
+	}
+
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind) {
+			return
+		}
+	}
+
+	t.Errorf("%s returned an untyped error: %s", what, err.Error())
+} // func checkError(t *testing.T, what string, err error)
+
+// count returns how many of the packages have the given name.
+func count(pkList []backend.Package, name string) int {
+	var cnt int
+
+	for _, p := range pkList {
+		if p.Name == name {
+			cnt++
+		}
+	}
+
+	return cnt
+} // func count(pkList []backend.Package, name string) int
+
+// listInstalled returns the installed packages, and checks they all have a
+// name and a version, and are listed once.
+func (s *suite) listInstalled(t *testing.T) []backend.Package {
+	var (
+		err    error
+		pkList []backend.Package
+		seen   = make(map[string]bool)
+	)
+
+	t.Helper()
+
+	if pkList, err = s.pk.ListInstalled(context.Background()); err != nil {
+		checkError(t, "ListInstalled", err)
+		t.Fatalf("ListInstalled failed: %s", err.Error())
+	}
+
+	for _, p := range pkList {
+		if p.Name == "" || p.Version == "" {
+			t.Errorf("ListInstalled returned a package without name or version: %#v", p)
+		} else if seen[p.Name] {
+			t.Errorf("ListInstalled returned %s more than once", p.Name)
+		}
+		seen[p.Name] = true
+	}
+
+	return pkList
+} // func (s *suite) listInstalled(t *testing.T) []backend.Package
+
+func (s *suite) search(t *testing.T) {
+	var (
+		err    error
+		pkList []backend.Package
+		ctx    = context.Background()
+	)
+
+	if pkList, err = s.pk.Search(ctx, s.fx.Available); err != nil {
+		checkError(t, "Search", err)
+		t.Errorf("Search for %s failed: %s", s.fx.Available, err.Error())
+	} else if count(pkList, s.fx.Available) == 0 {
+		t.Errorf("Search for %s did not find it: %v", s.fx.Available, pkList)
+	}
+
+	for _, p := range pkList {
+		if p.Name == "" {
+			t.Errorf("Search returned a package without a name: %#v", p)
+		}
+	}
+
+	// An empty result is not an error.
+	if pkList, err = s.pk.Search(ctx, s.fx.Missing); err != nil {
+		checkError(t, "Search", err)
+		t.Errorf("Search for %s failed: %s", s.fx.Missing, err.Error())
+	} else if len(pkList) != 0 {
+		t.Errorf("Search for %s found something: %v", s.fx.Missing, pkList)
+	}
+} // func (s *suite) search(t *testing.T)
+
+func (s *suite) info(t *testing.T) {
+	var (
+		err  error
+		info *backend.PackageInfo
+		ctx  = context.Background()
+	)
+
+	if info, err = s.pk.Info(ctx, s.fx.Available); err != nil {
+		checkError(t, "Info", err)
+		t.Errorf("Info on %s failed: %s", s.fx.Available, err.Error())
+	} else if info.Name != s.fx.Available {
+		t.Errorf("Info on %s returned %s", s.fx.Available, info.Name)
+	} else if info.Installed {
+		t.Errorf("Info claims %s is installed", s.fx.Available)
+	} else if info.Version == "" {
+		t.Errorf("Info on %s returned no version", s.fx.Available)
+	}
+
+	if _, err = s.pk.Info(ctx, s.fx.Missing); !errors.Is(err, backend.ErrNotFound) {
+		t.Errorf("Info on %s should fail with ErrNotFound, got %v", s.fx.Missing, err)
+	}
+} // func (s *suite) info(t *testing.T)
+
+func (s *suite) install(t *testing.T) {
+	var (
+		err  error
+		info *backend.PackageInfo
+		ctx  = context.Background()
+	)
+
+	if count(s.listInstalled(t), s.fx.Available) != 0 {
+		t.Fatalf("%s is installed already", s.fx.Available)
+	} else if err = s.pk.Install(ctx, s.fx.Available); err != nil {
+		checkError(t, "Install", err)
+		t.Fatalf("Install of %s failed: %s", s.fx.Available, err.Error())
+	} else if count(s.listInstalled(t), s.fx.Available) != 1 {
+		t.Errorf("%s is not listed as installed after installing it", s.fx.Available)
+	}
+
+	if info, err = s.pk.Info(ctx, s.fx.Available); err != nil {
+		checkError(t, "Info", err)
+		t.Errorf("Info on %s failed: %s", s.fx.Available, err.Error())
+	} else if !info.Installed {
+		t.Errorf("Info claims %s is not installed after installing it", s.fx.Available)
+	}
+
+	if err = s.pk.Install(ctx, s.fx.Available); err != nil {
+		checkError(t, "Install", err)
+		t.Errorf("Installing %s a second time failed: %s", s.fx.Available, err.Error())
+	}
+
+	if err = s.pk.Install(ctx, s.fx.Missing); !errors.Is(err, backend.ErrNotFound) {
+		t.Errorf("Install of %s should fail with ErrNotFound, got %v", s.fx.Missing, err)
+	}
+} // func (s *suite) install(t *testing.T)
+
+func (s *suite) remove(t *testing.T) {
+	var (
+		err error
+		ctx = context.Background()
+	)
+
+	if err = s.pk.Remove(ctx, s.fx.Available); err != nil {
+		checkError(t, "Remove", err)
+		t.Fatalf("Remove of %s failed: %s", s.fx.Available, err.Error())
+	} else if count(s.listInstalled(t), s.fx.Available) != 0 {
+		t.Errorf("%s is still listed as installed after removing it", s.fx.Available)
+	}
+
+	// Some package managers shrug at removing what is not installed,
+	// others complain it was not found. Both are fine.
+	if err = s.pk.Remove(ctx, s.fx.Available); err != nil && !errors.Is(err, backend.ErrNotFound) {
+		checkError(t, "Remove", err)
+		t.Errorf("Removing %s a second time failed: %s", s.fx.Available, err.Error())
+	}
+} // func (s *suite) remove(t *testing.T)
+
+func (s *suite) upgrades(t *testing.T) {
+	var (
+		err           error
+		upList, secUp []backend.PendingUpgrade
+		names         = make(map[string]bool)
+		ctx           = context.Background()
+	)
+
+	if upList, err = s.pk.ListUpgrades(ctx, false); err != nil {
+		checkError(t, "ListUpgrades", err)
+		t.Fatalf("ListUpgrades failed: %s", err.Error())
+	}
+
+	for _, u := range upList {
+		if u.Name == "" || u.Candidate == "" {
+			t.Errorf("ListUpgrades returned an upgrade without name or candidate: %#v", u)
+		}
+		names[u.Name] = true
+	}
+
+	secUp, err = s.pk.ListUpgrades(ctx, true)
+
+	switch {
+	case !s.fx.Security && !errors.Is(err, backend.ErrUnsupported):
+		t.Errorf("ListUpgrades(true) should fail with ErrUnsupported, got %v", err)
+	case !s.fx.Security:
+	case err != nil:
+		checkError(t, "ListUpgrades", err)
+		t.Errorf("ListUpgrades(true) failed: %s", err.Error())
+	default:
+		for _, u := range secUp {
+			if !names[u.Name] {
+				t.Errorf("Security update of %s is not among all updates", u.Name)
+			}
+		}
+	}
+} // func (s *suite) upgrades(t *testing.T)
+
+func (s *suite) preview(t *testing.T) {
+	var ctx = context.Background()
+
+	for _, op := range event.AllEvents() {
+		var (
+			err     error
+			plan    *backend.Plan
+			pkgs    []string
+			claimed bool
+		)
+
+		for _, p := range s.fx.Preview {
+			claimed = claimed || p == op
+		}
+
+		if op == event.Add || op == event.Delete {
+			pkgs = []string{s.fx.Available}
+		}
+
+		plan, err = s.pk.Preview(ctx, op, pkgs...)
+
+		switch {
+		case !claimed:
+			if !errors.Is(err, backend.ErrUnsupported) {
+				t.Errorf("Preview of %s should fail with ErrUnsupported, got %v", op, err)
+			}
+		case op == event.Delete && errors.Is(err, backend.ErrNotFound):
+			// Available is no longer installed, see remove.
+		case err != nil:
+			checkError(t, "Preview", err)
+			t.Errorf("Preview of %s failed: %s", op, err.Error())
+		case plan == nil:
+			t.Errorf("Preview of %s returned no plan", op)
+		case plan.Op != op:
+			t.Errorf("Preview of %s returned a plan for %s", op, plan.Op)
+		case op == event.Add && !hasChange(plan, s.fx.Available, action.Install):
+			t.Errorf("Preview of installing %s does not install it: %v",
+				s.fx.Available,
+				plan.Changes)
+		}
+	}
+} // func (s *suite) preview(t *testing.T)
+
+// hasChange returns true if the plan performs the given action on the given
+// package.
+func hasChange(plan *backend.Plan, name string, a action.ID) bool {
+	for _, c := range plan.Changes {
+		if c.Name == name && c.Action == a {
+			return true
+		}
+	}
+
+	return false
+} // func hasChange(plan *backend.Plan, name string, a action.ID) bool
@@ -0,0 +1,148 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/depgraph.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// DepEdge is an edge in a dependency graph, meaning From depends on To.
+type DepEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// DepGraph is the dependency graph of a set of installed packages.
+type DepGraph struct {
+	Nodes []string  `json:"nodes"`
+	Edges []DepEdge `json:"edges"`
+}
+
+// BuildDepGraph builds the dependency graph of the given packages and
+// everything they depend on, recursively.
+// If reverse is true, the graph is built from the packages that depend on
+// the given packages instead. The edges point from the dependent package
+// to its dependency either way.
+// If no packages are given, the graph covers all installed packages.
+//
+// Since we have to query the package manager once for every package in the
+// graph, this can take a while.
+func BuildDepGraph(ctx context.Context, pk PkgManager, reverse bool, roots ...string) (*DepGraph, error) {
+	var (
+		err     error
+		queue   []string
+		visited = make(map[string]bool)
+		g       = &DepGraph{
+			Nodes: []string{},
+			Edges: []DepEdge{},
+		}
+	)
+
+	if len(roots) == 0 {
+		var pkList []Package
+
+		if pkList, err = pk.ListInstalled(ctx); err != nil {
+			return nil, err
+		}
+
+		for _, p := range pkList {
+			roots = append(roots, p.Name)
+		}
+	}
+
+	queue = append(queue, roots...)
+
+	for len(queue) > 0 {
+		var (
+			name = queue[0]
+			deps []string
+		)
+
+		queue = queue[1:]
+
+		if visited[name] {
+			continue
+		}
+
+		visited[name] = true
+		g.Nodes = append(g.Nodes, name)
+
+		if reverse {
+			deps, err = pk.RequiredBy(ctx, name)
+		} else {
+			deps, err = pk.Depends(ctx, name)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Cannot get dependencies of %s: %w",
+				name,
+				err)
+		}
+
+		for _, d := range deps {
+			if reverse {
+				g.Edges = append(g.Edges, DepEdge{From: d, To: name})
+			} else {
+				g.Edges = append(g.Edges, DepEdge{From: name, To: d})
+			}
+
+			if !visited[d] {
+				queue = append(queue, d)
+			}
+		}
+	}
+
+	sort.Strings(g.Nodes)
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From == g.Edges[j].From {
+			return g.Edges[i].To < g.Edges[j].To
+		}
+		return g.Edges[i].From < g.Edges[j].From
+	})
+
+	return g, nil
+} // func BuildDepGraph(ctx context.Context, pk PkgManager, reverse bool, roots ...string) (*DepGraph, error)
+
+// WriteDOT renders the graph in the DOT language used by Graphviz.
+func (g *DepGraph) WriteDOT(w io.Writer) error {
+	var err error
+
+	if _, err = fmt.Fprintln(w, "digraph deps {"); err != nil {
+		return err
+	}
+
+	for _, n := range g.Nodes {
+		if _, err = fmt.Fprintf(w, "\t%s;\n", strconv.Quote(n)); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range g.Edges {
+		if _, err = fmt.Fprintf(w, "\t%s -> %s;\n",
+			strconv.Quote(e.From),
+			strconv.Quote(e.To)); err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintln(w, "}")
+	return err
+} // func (g *DepGraph) WriteDOT(w io.Writer) error
+
+// WriteJSON renders the graph as a JSON object.
+func (g *DepGraph) WriteJSON(w io.Writer) error {
+	var enc = json.NewEncoder(w)
+
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(g)
+} // func (g *DepGraph) WriteJSON(w io.Writer) error
@@ -0,0 +1,191 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/errors.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// These errors classify the failures of package manager commands. Use
+// errors.Is to check for them, errors.As with a *CmdError to get at the
+// details.
+var (
+	ErrNotFound   = errors.New("package not found")
+	ErrLocked     = errors.New("package database is locked")
+	ErrPermission = errors.New("permission denied")
+	ErrNetwork    = errors.New("repository unreachable")
+	ErrConflict   = errors.New("dependency conflict")
+	ErrDiskFull   = errors.New("not enough disk space")
+)
+
+// CmdError is the error returned when a package manager command exits with a
+// non-zero status.
+// Kind is one of the errors above, or nil if we could not tell what went
+// wrong. Stderr is what the command wrote to its standard error output.
+type CmdError struct {
+	Kind     error
+	Cmd      string
+	Args     []string
+	ExitCode int
+	Stderr   string
+	Err      error
+}
+
+func (e *CmdError) Error() string {
+	var (
+		msg  = firstLine(strings.TrimSpace(e.Stderr))
+		kind = "failed"
+	)
+
+	if e.Kind != nil {
+		kind = e.Kind.Error()
+	}
+
+	if msg == "" {
+		return fmt.Sprintf("%s: %s (exit status %d)",
+			e.Cmd,
+			kind,
+			e.ExitCode)
+	}
+
+	return fmt.Sprintf("%s: %s (exit status %d): %s",
+		e.Cmd,
+		kind,
+		e.ExitCode,
+		msg)
+} // func (e *CmdError) Error() string
+
+// Unwrap returns the underlying error, usually an *exec.ExitError.
+func (e *CmdError) Unwrap() error {
+	return e.Err
+} // func (e *CmdError) Unwrap() error
+
+// Is makes errors.Is match a CmdError against its Kind.
+func (e *CmdError) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+} // func (e *CmdError) Is(target error) bool
+
+// errorPatterns maps the messages the various package managers print to
+// stderr to the kind of error they indicate. The list is checked in order,
+// since a failed transaction may complain about more than one thing, and the
+// root cause tends to be the first match.
+var errorPatterns = []struct {
+	kind error
+	pat  *regexp.Regexp
+}{
+	{ErrDiskFull, regexp.MustCompile(`(?i)no space left on device|` +
+		`you don't have enough free space|` +
+		`not enough free (disk )?space|` +
+		`more space needed on the`)},
+	{ErrLocked, regexp.MustCompile(`(?i)could not get lock|` +
+		`unable to acquire the dpkg frontend lock|` +
+		`unable to lock database|` +
+		`system management is locked|` +
+		`waiting for process with pid|` +
+		`cannot get an (advisory|exclusive) lock|` +
+		`another instance of pkg is running`)},
+	{ErrPermission, regexp.MustCompile(`(?i)permission denied|` +
+		`are you root\?|` +
+		`unless you are root|` +
+		`root privileges are required|` +
+		`has to be run with superuser privileges|` +
+		`insufficient privileges|` +
+		`must be run as root|` +
+		`need root privileges`)},
+	{ErrNetwork, regexp.MustCompile(`(?i)temporary failure resolving|` +
+		`could not resolve|` +
+		`failed to fetch|` +
+		`failed to download metadata|` +
+		`failed retrieving file|` +
+		`download \(curl\) error|` +
+		`curl error|` +
+		`unable to update repository|` +
+		`network is unreachable|` +
+		`connection (refused|timed out)`)},
+	{ErrNotFound, regexp.MustCompile(`(?i)unable to locate package|` +
+		`no packages found|` +
+		`no match for argument|` +
+		`target not found|` +
+		`package '\S+' was not found|` +
+		`no provider of|` +
+		`not found in package names|` +
+		`no packages available to install matching|` +
+		`no packages matched for pattern|` +
+		`problem finding \S+|` +
+		`can't find \S+|` +
+		`package .* is not installed`)},
+	{ErrConflict, regexp.MustCompile(`(?i)unmet dependencies|` +
+		`are in conflict|` +
+		`conflicting (requests|dependencies|packages)|` +
+		`conflicts with|` +
+		`nothing provides|` +
+		`could not satisfy dependencies|` +
+		`unresolvable|` +
+		`can't install .* because of`)},
+}
+
+// classifyError returns the kind of error the output of a failed command
+// indicates, or nil if it does not match any of the known patterns.
+func classifyError(stderr string) error {
+	for _, e := range errorPatterns {
+		if e.pat.MatchString(stderr) {
+			return e.kind
+		}
+	}
+
+	return nil
+} // func classifyError(stderr string) error
+
+// newCmdError creates the CmdError for a command that exited with a non-zero
+// status. err is the error the command failed with, if any, e.g. an
+// *exec.ExitError.
+func newCmdError(c *Command, res *Result, err error) *CmdError {
+	var cerr = &CmdError{
+		Cmd:      c.Path,
+		Args:     c.Args,
+		ExitCode: res.ExitCode,
+		Stderr:   res.Stderr,
+		Err:      err,
+	}
+
+	// Some tools, like zypper, print their complaints to stdout.
+	if cerr.Kind = classifyError(res.Stderr); cerr.Kind == nil {
+		cerr.Kind = classifyError(res.Stdout)
+	}
+
+	return cerr
+} // func newCmdError(c *Command, res *Result, err error) *CmdError
+
+// exitCode returns the exit status of a command that ran but failed, or -1 if
+// err is nil or something else went wrong.
+func exitCode(err error) int {
+	var cerr *CmdError
+
+	if errors.As(err, &cerr) {
+		return cerr.ExitCode
+	}
+
+	return -1
+} // func exitCode(err error) int
+
+// searchFailed returns true if the error of a search command means the search
+// failed, rather than came up empty. The package managers signal an empty
+// result in all kinds of ways, some of them with a non-zero exit status.
+func searchFailed(err error) bool {
+	var cerr *CmdError
+
+	if err == nil {
+		return false
+	} else if !errors.As(err, &cerr) {
+		return true
+	}
+
+	return cerr.Kind != nil && cerr.Kind != ErrNotFound
+} // func searchFailed(err error) bool
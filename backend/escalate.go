@@ -0,0 +1,78 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/escalate.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// escalators are the commands we know how to gain root privileges with, in
+// order of preference.
+var escalators = []string{"sudo", "doas", "pkexec"}
+
+// escalation is the command used to run commands that need root privileges,
+// e.g. /usr/bin/sudo. It is empty if we are root already or if escalation is
+// disabled.
+var escalation string
+
+const cmdEnv = "/usr/bin/env"
+
+// SetEscalation configures how commands that change the system are run when
+// we are not root.
+// name is one of "sudo", "doas" or "pkexec", "none" to run them as they are,
+// or empty to use the first of the three that is installed.
+// Commands that only read the state of the system are never escalated.
+func SetEscalation(name string) error {
+	var err error
+
+	escalation = ""
+
+	if os.Geteuid() == 0 || name == "none" {
+		return nil
+	} else if name != "" {
+		if escalation, err = exec.LookPath(name); err != nil {
+			return fmt.Errorf("Cannot find escalation command %s: %w",
+				name,
+				err)
+		}
+		return nil
+	}
+
+	for _, e := range escalators {
+		if escalation, err = exec.LookPath(e); err == nil {
+			return nil
+		}
+	}
+
+	// Nothing is installed, so we try our luck without.
+	escalation = ""
+	return nil
+} // func SetEscalation(name string) error
+
+// privilegedCommand returns the command line to run the given command with
+// root privileges.
+// Since sudo and friends scrub the environment, the variables in env are
+// passed via env(1) in that case.
+func privilegedCommand(env []string, path string, args ...string) (string, []string, []string) {
+	if escalation == "" {
+		return path, args, env
+	}
+
+	var cmdline = make([]string, 0, len(env)+len(args)+2)
+
+	if len(env) > 0 {
+		cmdline = append(cmdline, cmdEnv)
+		cmdline = append(cmdline, env...)
+	}
+
+	cmdline = append(cmdline, path)
+	cmdline = append(cmdline, args...)
+
+	return escalation, cmdline, nil
+} // func privilegedCommand(env []string, path string, args ...string) (string, []string, []string)
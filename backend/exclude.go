@@ -0,0 +1,135 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/exclude.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"context"
+	"path"
+
+	"github.com/blicero/pkman/common"
+	"github.com/blicero/pkman/database/event"
+)
+
+type excludeKey struct{}
+
+// WithExclude returns a Context that makes the PkgManager leave the packages
+// matching any of the given shell patterns alone when it installs or
+// upgrades packages, or previews doing so. The package manager skips them
+// rather than failing the whole transaction.
+// Packages the caller asks for by name are not protected, it is up to the
+// caller to refuse those.
+func WithExclude(ctx context.Context, patterns []string) context.Context {
+	return context.WithValue(ctx, excludeKey{}, patterns)
+} // func WithExclude(ctx context.Context, patterns []string) context.Context
+
+// excludePatterns returns the patterns of the packages to leave alone for
+// the given operation. Removing packages is never restricted.
+func excludePatterns(ctx context.Context, op event.ID) []string {
+	if op != event.Add && op != event.Update {
+		return nil
+	}
+
+	var patterns, _ = ctx.Value(excludeKey{}).([]string)
+
+	return patterns
+} // func excludePatterns(ctx context.Context, op event.ID) []string
+
+// isExcluded returns true if the name matches one of the patterns.
+func isExcluded(patterns []string, name string) bool {
+	for _, pat := range patterns {
+		if ok, _ := path.Match(pat, name); ok {
+			return true
+		}
+	}
+
+	return false
+} // func isExcluded(patterns []string, name string) bool
+
+// matchExcluded returns the names of the packages in pkList that match one of
+// the patterns.
+func matchExcluded(pkList []Package, patterns []string) []string {
+	var names []string
+
+	for _, p := range pkList {
+		if isExcluded(patterns, p.Name) {
+			names = append(names, p.Name)
+		}
+	}
+
+	return names
+} // func matchExcluded(pkList []Package, patterns []string) []string
+
+// notExcludedNames returns the names that are not excluded for op.
+func notExcludedNames(ctx context.Context, op event.ID, names []string) []string {
+	var (
+		patterns = excludePatterns(ctx, op)
+		result   = make([]string, 0, len(names))
+	)
+
+	for _, n := range names {
+		if !isExcluded(patterns, n) {
+			result = append(result, n)
+		}
+	}
+
+	return result
+} // func notExcludedNames(ctx context.Context, op event.ID, names []string) []string
+
+// holdFunc holds or releases packages, using whatever mechanism the package
+// manager has to keep packages at their installed version.
+type holdFunc func(ctx context.Context, names []string) error
+
+// holder is for package managers that cannot be told to skip packages for a
+// single transaction, only to hold them until further notice.
+// installed lists the installed packages, held the names of the packages
+// that are held already.
+type holder struct {
+	installed func(context.Context) ([]Package, error)
+	held      func(context.Context) ([]string, error)
+	hold      holdFunc
+	release   holdFunc
+}
+
+// holdExcluded holds the installed packages that are excluded for op and not
+// held already, and returns a function that releases them again. The caller
+// must call it once the transaction is done, whether it succeeded or not.
+// Packages that were held before stay held.
+func (h *holder) holdExcluded(ctx context.Context, op event.ID) (func(), error) {
+	var (
+		err       error
+		names     []string
+		held      []string
+		installed []Package
+		patterns  = excludePatterns(ctx, op)
+	)
+
+	if len(patterns) == 0 {
+		return func() {}, nil
+	} else if installed, err = h.installed(ctx); err != nil {
+		return nil, err
+	} else if held, err = h.held(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, n := range matchExcluded(installed, patterns) {
+		if !common.Contains(held, n) {
+			names = append(names, n)
+		}
+	}
+
+	if len(names) == 0 {
+		return func() {}, nil
+	} else if err = h.hold(ctx, names); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		// Releasing the packages must not fail just because the
+		// transaction was cancelled.
+		h.release(context.Background(), names) // nolint: errcheck
+	}, nil
+} // func (h *holder) holdExcluded(ctx context.Context, op event.ID) (func(), error)
@@ -0,0 +1,361 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/history.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blicero/pkman/common"
+	"github.com/blicero/pkman/database"
+	"github.com/blicero/pkman/database/action"
+	"github.com/blicero/pkman/database/event"
+	"github.com/blicero/pkman/logdomain"
+)
+
+// historyKeeper is implemented by the PkgManagers that keep a connection to
+// the history database.
+type historyKeeper interface {
+	historyDB() *database.Database
+}
+
+// historyRecorder wraps a PkgManager and records the operations that change
+// the system in the event history.
+type historyRecorder struct {
+	PkgManager
+	db  *database.Database
+	log *log.Logger
+}
+
+// recordHistory wraps pk in a historyRecorder, if it keeps a connection to
+// the history database.
+func recordHistory(pk PkgManager) (PkgManager, error) {
+	var (
+		err error
+		hk  historyKeeper
+		ok  bool
+		h   = &historyRecorder{PkgManager: pk}
+	)
+
+	if hk, ok = pk.(historyKeeper); !ok || hk.historyDB() == nil {
+		return pk, nil
+	} else if h.log, err = common.GetLogger(logdomain.PkgManager); err != nil {
+		return nil, err
+	}
+
+	h.db = hk.historyDB()
+
+	return h, nil
+} // func recordHistory(pk PkgManager) (PkgManager, error)
+
+func (h *historyRecorder) Install(ctx context.Context, args ...string) error {
+	return h.record(ctx, event.Add, args, func(ctx context.Context) error {
+		return h.PkgManager.Install(ctx, args...)
+	})
+} // func (h *historyRecorder) Install(ctx context.Context, args ...string) error
+
+func (h *historyRecorder) Remove(ctx context.Context, args ...string) error {
+	return h.record(ctx, event.Delete, args, func(ctx context.Context) error {
+		return h.PkgManager.Remove(ctx, args...)
+	})
+} // func (h *historyRecorder) Remove(ctx context.Context, args ...string) error
+
+func (h *historyRecorder) Update(ctx context.Context) error {
+	return h.record(ctx, event.Refresh, nil, h.PkgManager.Update)
+} // func (h *historyRecorder) Update(ctx context.Context) error
+
+func (h *historyRecorder) Upgrade(ctx context.Context, securityOnly bool) error {
+	return h.record(ctx, event.Update, nil, func(ctx context.Context) error {
+		return h.PkgManager.Upgrade(ctx, securityOnly)
+	})
+} // func (h *historyRecorder) Upgrade(ctx context.Context, securityOnly bool) error
+
+func (h *historyRecorder) Clean(ctx context.Context) error {
+	return h.record(ctx, event.Clean, nil, h.PkgManager.Clean)
+} // func (h *historyRecorder) Clean(ctx context.Context) error
+
+// LastUpdate returns when the package metadata was last refreshed or the
+// system last upgraded, whichever happened later, going by the newest
+// successful Refresh and Update events in the history. If there are none,
+// it asks the package manager.
+func (h *historyRecorder) LastUpdate(ctx context.Context) (time.Time, error) {
+	var last time.Time
+
+	for _, t := range []event.ID{event.Refresh, event.Update} {
+		var (
+			err    error
+			evList []event.Event
+		)
+
+		if evList, err = h.db.EventGetRecentByType(ctx, -1, t); err != nil {
+			h.log.Printf("[ERROR] Cannot load %s events: %s\n",
+				t,
+				err.Error())
+			return time.Time{}, err
+		}
+
+		for _, ev := range evList {
+			if ev.Status != 0 {
+				continue
+			}
+
+			// Events recorded before we kept track of the end
+			// only have a start.
+			var stamp = ev.Timestamp.Add(ev.Duration())
+
+			if stamp.After(last) {
+				last = stamp
+			}
+			break
+		}
+	}
+
+	if last.IsZero() {
+		return h.PkgManager.LastUpdate(ctx)
+	}
+
+	return last, nil
+} // func (h *historyRecorder) LastUpdate(ctx context.Context) (time.Time, error)
+
+// record performs an operation via fn and adds it to the event history:
+// when it started and ended, how the package manager exited, the commands
+// it ran, who asked for it, and the packages it was given.
+// For the operations that install, remove or upgrade packages, it compares
+// the installed packages before and after, and records what changed for
+// each of them, too. Even a failed operation may have changed some.
+// Failing to record the operation is logged, but does not fail it.
+func (h *historyRecorder) record(ctx context.Context, op event.ID, pkgs []string, fn func(context.Context) error) error {
+	var (
+		err, lerr     error
+		before, after []Package
+		changes       []event.Change
+		cl            = new(commandLog)
+		ev            = &event.Event{Type: op, Timestamp: time.Now(), Packages: pkgs}
+		diff          = op == event.Add || op == event.Delete || op == event.Update
+	)
+
+	if diff {
+		if before, lerr = h.PkgManager.ListInstalled(ctx); lerr != nil {
+			h.log.Printf("[ERROR] Cannot list installed packages before %s: %s\n",
+				op,
+				lerr.Error())
+			diff = false
+		}
+	}
+
+	err = fn(context.WithValue(ctx, commandLogKey{}, cl))
+
+	ev.End = time.Now()
+	ev.User = common.InvokingUser()
+	ev.Command = cl.String()
+	ev.Output = cl.Output()
+
+	if err != nil {
+		ev.Status = int64(exitCode(err))
+	}
+
+	if diff {
+		if after, lerr = h.PkgManager.ListInstalled(ctx); lerr != nil {
+			h.log.Printf("[ERROR] Cannot list installed packages after %s: %s\n",
+				op,
+				lerr.Error())
+		} else {
+			changes = diffInstalled(before, after)
+		}
+	}
+
+	if rerr := h.save(ev, changes); rerr != nil {
+		h.log.Printf("[ERROR] Cannot record %s in history: %s\n",
+			op,
+			rerr.Error())
+	}
+
+	return err
+} // func (h *historyRecorder) record(ctx context.Context, op event.ID, pkgs []string, fn func(context.Context) error) error
+
+// saveTimeout limits how long we wait for the history database to save an
+// Event.
+const saveTimeout = 10 * time.Second
+
+// save adds the Event and the changes it made to the database, in a single
+// transaction.
+// It does not use the Context of the operation, so an operation that was
+// cancelled is still recorded.
+func (h *historyRecorder) save(ev *event.Event, changes []event.Change) error {
+	var (
+		err         error
+		tx          *database.Tx
+		status      bool
+		ctx, cancel = context.WithTimeout(context.Background(), saveTimeout)
+	)
+
+	defer cancel()
+
+	if tx, err = h.db.Begin(ctx); err != nil {
+		return err
+	}
+
+	defer func() {
+		if !status {
+			tx.Rollback() // nolint: errcheck
+		}
+	}()
+
+	if err = tx.EventAdd(ctx, ev); err != nil {
+		return err
+	}
+
+	for i := range changes {
+		changes[i].EventID = ev.ID
+		if err = tx.ChangeAdd(ctx, &changes[i]); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	status = true
+	return nil
+} // func (h *historyRecorder) save(ev *event.Event, changes []event.Change) error
+
+// diffInstalled compares two lists of installed packages, and returns what
+// happened to each package that was added, removed, or changed its version
+// in between, ordered by name.
+func diffInstalled(before, after []Package) []event.Change {
+	var (
+		changes []event.Change
+		old     = make(map[string]string, len(before))
+	)
+
+	for _, p := range before {
+		old[p.Name] = p.Version
+	}
+
+	for _, p := range after {
+		var v, ok = old[p.Name]
+
+		delete(old, p.Name)
+
+		if !ok || v != p.Version {
+			changes = append(changes, event.Change{
+				Package:    p.Name,
+				Action:     versionChange(v, p.Version),
+				OldVersion: v,
+				NewVersion: p.Version,
+			})
+		}
+	}
+
+	for name, v := range old {
+		changes = append(changes, event.Change{
+			Package:    name,
+			Action:     action.Remove,
+			OldVersion: v,
+		})
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Package < changes[j].Package })
+
+	return changes
+} // func diffInstalled(before, after []Package) []event.Change
+
+// maxOutput is how much of the package manager's output we keep for an
+// operation. If it prints more, we keep the end, which is where the errors
+// usually are.
+const maxOutput = 1 << 20
+
+// commandLog collects the command lines of the transactions run on behalf of
+// an operation, and what they printed.
+type commandLog struct {
+	lock      sync.Mutex
+	cmds      []string
+	output    []byte
+	truncated bool
+}
+
+type commandLogKey struct{}
+
+func (cl *commandLog) String() string {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	return strings.Join(cl.cmds, "\n")
+} // func (cl *commandLog) String() string
+
+// Output returns the output of the commands.
+func (cl *commandLog) Output() string {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	cl.truncate()
+
+	if cl.truncated {
+		return "[...]\n" + string(cl.output)
+	}
+
+	return string(cl.output)
+} // func (cl *commandLog) Output() string
+
+// truncate cuts the output down to the last maxOutput bytes, starting at the
+// beginning of a line.
+func (cl *commandLog) truncate() {
+	if len(cl.output) <= maxOutput {
+		return
+	}
+
+	var tail = cl.output[len(cl.output)-maxOutput:]
+
+	if idx := bytes.IndexByte(tail, '\n'); idx != -1 {
+		tail = tail[idx+1:]
+	}
+
+	cl.output = append(cl.output[:0], tail...)
+	cl.truncated = true
+} // func (cl *commandLog) truncate()
+
+// logCommand adds the command to the commandLog attached to the Context, if
+// there is one.
+func logCommand(ctx context.Context, c *Command) {
+	var cl, _ = ctx.Value(commandLogKey{}).(*commandLog)
+
+	if cl == nil {
+		return
+	}
+
+	cl.lock.Lock()
+	cl.cmds = append(cl.cmds, c.String())
+	cl.lock.Unlock()
+} // func logCommand(ctx context.Context, c *Command)
+
+// logOutput adds the output of a command to the commandLog attached to the
+// Context, if there is one.
+func logOutput(ctx context.Context, out ...string) {
+	var cl, _ = ctx.Value(commandLogKey{}).(*commandLog)
+
+	if cl == nil {
+		return
+	}
+
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	for _, s := range out {
+		cl.output = append(cl.output, s...)
+	}
+
+	// Cutting the output down every time would copy it over and over.
+	if len(cl.output) > 2*maxOutput {
+		cl.truncate()
+	}
+} // func logOutput(ctx context.Context, out ...string)
@@ -7,50 +7,111 @@
 package backend
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/blicero/pkman/backend/platform"
+	"github.com/blicero/pkman/database/event"
 )
 
+// ErrUnsupported is returned by PkgManager implementations when asked to do
+// something the underlying package manager has no support for.
+var ErrUnsupported = errors.New("Operation is not supported by this package manager")
+
 // PkgManager is a generalized interface to package managers.
+// Info returns detailed information on a single package, installed or not.
+// Preview returns what the given operation (event.Add, event.Delete, or
+// event.Update) would do with the given packages, without changing anything.
+// Depends returns the names of the packages the given package depends on,
+// RequiredBy returns the names of the installed packages that depend on the
+// given package.
+// ListUpgrades returns the updates that are available for installed packages,
+// without installing them.
+// If the securityOnly flag is passed to Upgrade or ListUpgrades, only updates
+// that fix security issues are considered. Package managers that do not know
+// which updates are security fixes return ErrUnsupported in that case.
+// LockStatus returns the lock another process holds on the package database,
+// or nil if there is none, or none we can see.
 type PkgManager interface {
-	Search(string) ([]Package, error)
-	Install(...string) error
-	Remove(...string) error
-	Update() error
-	Upgrade() error
-	ListInstalled() ([]Package, error)
-	Clean() error
-	LastUpdate() (time.Time, error)
+	Search(context.Context, string) ([]Package, error)
+	Info(context.Context, string) (*PackageInfo, error)
+	Install(context.Context, ...string) error
+	Remove(context.Context, ...string) error
+	Update(context.Context) error
+	Upgrade(context.Context, bool) error
+	ListUpgrades(context.Context, bool) ([]PendingUpgrade, error)
+	Preview(context.Context, event.ID, ...string) (*Plan, error)
+	ListInstalled(context.Context) ([]Package, error)
+	Clean(context.Context) error
+	LastUpdate(context.Context) (time.Time, error)
+	Depends(context.Context, string) ([]string, error)
+	RequiredBy(context.Context, string) ([]string, error)
+	LockStatus(context.Context) (*LockInfo, error)
 }
 
 // GetPkgManager returns the PkgManager implementation for the given OS.
-func GetPkgManager(system string) (PkgManager, error) {
+// The PkgManager runs the native package manager's commands via r, or via an
+// ExecRunner if r is nil.
+// If system is FakeBackend, optionally followed by a colon and the path of a
+// catalog, it returns a PkgFake, which does not run any commands. If system
+// is ReplayBackend, followed by a colon and the directory of a recording, it
+// returns the PkgManager of the system the recording was made on, running its
+// commands via a ReplayRunner.
+// The operations that change the system are recorded in the event history,
+// except when replaying a recording, which only pretends to change it.
+func GetPkgManager(system string, r Runner) (PkgManager, error) {
+	var (
+		err error
+		pk  PkgManager
+	)
+
+	if name, path, _ := strings.Cut(system, ":"); name == ReplayBackend {
+		var rr *ReplayRunner
+
+		if rr, err = NewReplayRunner(path); err != nil {
+			return nil, err
+		}
+
+		return createPkgManager(rr.Manifest.System, rr)
+	} else if pk, err = createPkgManager(system, r); err != nil {
+		return nil, err
+	}
+
+	return recordHistory(pk)
+} // func GetPkgManager(system string, r Runner) (PkgManager, error)
+
+// createPkgManager returns the PkgManager implementation for the given OS,
+// or the fake backend.
+func createPkgManager(system string, r Runner) (PkgManager, error) {
 	var (
 		err error
 		p   platform.System
 	)
 
-	if p, err = platform.ParseSystem(system); err != nil {
+	if name, path, _ := strings.Cut(system, ":"); name == FakeBackend {
+		return CreatePkgFake(path)
+	} else if p, err = platform.ParseSystem(system); err != nil {
 		return nil, err
 	}
 
 	switch p {
 	case platform.OpenSuse:
-		return CreatePkgZypp()
+		return CreatePkgZypp(r)
 	case platform.Debian:
-		return CreatePkgApt()
+		return CreatePkgApt(r)
 	case platform.RedHat:
-		return CreatePkgDnf()
+		return CreatePkgDnf(r)
 	case platform.Arch:
-		return CreatePkgPacman()
+		return CreatePkgPacman(r)
 	case platform.FreeBSD:
-		return CreatePkgPkg()
+		return CreatePkgPkg(r)
 	case platform.OpenBSD:
-		return CreatePkgOpenBSD()
+		return CreatePkgOpenBSD(r)
 	default:
 
 		return nil, fmt.Errorf("Support for %s is not implemented", p)
 	}
-} // func GetPkgManager(system string) (PkgManager, error)
+} // func createPkgManager(system string, r Runner) (PkgManager, error)
@@ -0,0 +1,211 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/lock.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// LockPollInterval is how often WaitForLock checks if a lock has been
+// released.
+const LockPollInterval = time.Second
+
+// LockInfo describes a lock held on the package database.
+// PID is the ID of the process holding the lock, or 0 if we cannot tell.
+// Holder is the name of that process, if we can find out.
+type LockInfo struct {
+	Path   string
+	PID    int
+	Holder string
+}
+
+func (l *LockInfo) String() string {
+	switch {
+	case l.PID == 0:
+		return l.Path
+	case l.Holder == "":
+		return fmt.Sprintf("%s held by process %d", l.Path, l.PID)
+	default:
+		return fmt.Sprintf("%s held by process %d (%s)", l.Path, l.PID, l.Holder)
+	}
+} // func (l *LockInfo) String() string
+
+// LockError is returned when we give up waiting for a lock.
+type LockError struct {
+	Info    *LockInfo
+	Waited  time.Duration
+	Timeout bool
+}
+
+func (e *LockError) Error() string {
+	if e.Timeout {
+		return fmt.Sprintf("%s: %s, gave up after %s",
+			ErrLocked.Error(),
+			e.Info,
+			e.Waited.Round(time.Second))
+	}
+
+	return fmt.Sprintf("%s: %s", ErrLocked.Error(), e.Info)
+} // func (e *LockError) Error() string
+
+// Is makes errors.Is match a LockError against ErrLocked.
+func (e *LockError) Is(target error) bool {
+	return target == ErrLocked
+} // func (e *LockError) Is(target error) bool
+
+// WaitForLock waits until the package database is no longer locked, or
+// until the timeout expires. While it waits, it calls progress, if it is not
+// nil, with the current lock and the time spent waiting so far.
+// It returns a *LockError if the lock is still held after timeout, or the
+// context's error if it is cancelled while we wait.
+func WaitForLock(ctx context.Context, pk PkgManager, timeout time.Duration, progress func(*LockInfo, time.Duration)) error {
+	var (
+		err   error
+		info  *LockInfo
+		start = time.Now()
+	)
+
+	for {
+		if info, err = pk.LockStatus(ctx); err != nil {
+			return err
+		} else if info == nil {
+			return nil
+		}
+
+		var waited = time.Since(start)
+
+		if waited >= timeout {
+			return &LockError{Info: info, Waited: waited, Timeout: timeout > 0}
+		} else if progress != nil {
+			progress(info, waited)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(LockPollInterval):
+		}
+	}
+} // func WaitForLock(ctx context.Context, pk PkgManager, timeout time.Duration, progress func(*LockInfo, time.Duration)) error
+
+// firstLock returns the first of the given lock checks that finds a lock.
+func firstLock(checks ...func() *LockInfo) *LockInfo {
+	for _, check := range checks {
+		if info := check(); info != nil {
+			return info
+		}
+	}
+
+	return nil
+} // func firstLock(checks ...func() *LockInfo) *LockInfo
+
+// fcntlLock checks if another process holds a POSIX record lock on the file,
+// which is how dpkg, apt and rpm lock their databases.
+// If we cannot open the file, e.g. because only root may read it, we cannot
+// tell, and report it as unlocked.
+func fcntlLock(path string) func() *LockInfo {
+	return func() *LockInfo {
+		var (
+			err error
+			fh  *os.File
+			lk  = syscall.Flock_t{
+				Type:   syscall.F_WRLCK,
+				Whence: io.SeekStart,
+			}
+		)
+
+		if fh, err = os.Open(path); err != nil {
+			return nil
+		}
+
+		defer fh.Close() // nolint: errcheck
+
+		if err = syscall.FcntlFlock(fh.Fd(), syscall.F_GETLK, &lk); err != nil || lk.Type == syscall.F_UNLCK {
+			return nil
+		}
+
+		return &LockInfo{
+			Path:   path,
+			PID:    int(lk.Pid),
+			Holder: processName(int(lk.Pid)),
+		}
+	}
+} // func fcntlLock(path string) func() *LockInfo
+
+// pidFileLock checks for a lock file that holds the PID of the process
+// holding the lock, like zypper and dnf use. A stale file, whose process is
+// gone, does not count.
+func pidFileLock(path string) func() *LockInfo {
+	return func() *LockInfo {
+		var (
+			err error
+			pid int
+			raw []byte
+		)
+
+		if raw, err = os.ReadFile(path); err != nil {
+			return nil
+		} else if pid, err = strconv.Atoi(strings.TrimSpace(string(raw))); err != nil || pid <= 0 {
+			return nil
+		} else if !processAlive(pid) {
+			return nil
+		}
+
+		return &LockInfo{
+			Path:   path,
+			PID:    pid,
+			Holder: processName(pid),
+		}
+	}
+} // func pidFileLock(path string) func() *LockInfo
+
+// existsLock checks for a lock file whose mere existence means the database
+// is locked, like pacman's db.lck. It does not tell us who holds it.
+func existsLock(path string) func() *LockInfo {
+	return func() *LockInfo {
+		if _, err := os.Stat(path); err != nil {
+			return nil
+		}
+
+		return &LockInfo{Path: path}
+	}
+} // func existsLock(path string) func() *LockInfo
+
+// processAlive returns true if a process with the given PID exists.
+func processAlive(pid int) bool {
+	var err = syscall.Kill(pid, 0)
+
+	return err == nil || errors.Is(err, syscall.EPERM)
+} // func processAlive(pid int) bool
+
+// processName returns the name of the process with the given PID, or an
+// empty string if it cannot be found out.
+func processName(pid int) string {
+	if pid <= 0 {
+		return ""
+	} else if raw, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid)); err == nil {
+		return strings.TrimSpace(string(raw))
+	}
+
+	// The BSDs do not mount /proc by default.
+	var out, err = exec.Command("ps", "-o", "comm=", "-p", strconv.Itoa(pid)).Output()
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(out))
+} // func processName(pid int) string
@@ -8,7 +8,29 @@ package backend
 
 // Package represents a ... package.
 type Package struct {
-	Name        string
-	Version     string
-	Description string
+	Name        string `json:"name" yaml:"name"`
+	Version     string `json:"version" yaml:"version"`
+	Description string `json:"description" yaml:"description"`
+}
+
+// PendingUpgrade describes an update that is available for an installed
+// package. For security updates, Advisories holds the IDs of the advisories
+// (or CVEs) the update resolves, as far as the package manager tells us.
+type PendingUpgrade struct {
+	Name       string   `json:"name" yaml:"name"`
+	Installed  string   `json:"installed" yaml:"installed"`
+	Candidate  string   `json:"candidate" yaml:"candidate"`
+	Advisories []string `json:"advisories" yaml:"advisories"`
+}
+
+// PackageInfo holds detailed information on a single package.
+// Size is the installed size in bytes, or nil if the package manager does
+// not tell us. Fields the package manager does not provide are left empty.
+type PackageInfo struct {
+	Package    `yaml:",inline"`
+	Installed  bool   `json:"installed" yaml:"installed"`
+	Repository string `json:"repository" yaml:"repository"`
+	URL        string `json:"url" yaml:"url"`
+	License    string `json:"license" yaml:"license"`
+	Size       *int64 `json:"size,omitempty" yaml:"size,omitempty"`
 }
@@ -7,32 +7,42 @@
 package backend
 
 import (
-	"bytes"
-	"io"
+	"context"
 	"log"
-	"os/exec"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/blicero/krylib"
+	"github.com/blicero/pkman/backend/stage"
 	"github.com/blicero/pkman/common"
 	"github.com/blicero/pkman/database"
+	"github.com/blicero/pkman/database/action"
+	"github.com/blicero/pkman/database/event"
 	"github.com/blicero/pkman/logdomain"
 )
 
 const (
-	cmdApt = "/usr/bin/apt" // nolint: unused
+	cmdAptGet    = "apt-get"
+	cmdAptMark   = "apt-mark"
+	cmdDpkgQuery = "dpkg-query"
 )
 
+// aptEnv keeps dpkg and debconf from asking questions when apt-get runs
+// without a terminal.
+var aptEnv = []string{"DEBIAN_FRONTEND=noninteractive"}
+
 // PkgApt implements the PkgManager interface for Debian's apt.
 type PkgApt struct {
 	log *log.Logger
 	db  *database.Database
+	run Runner
 }
 
 // CreatePkgApt creates a PkgApt instance to interface with the apt
 // package manager used by Debian, Ubuntu, and related distros.
-func CreatePkgApt() (*PkgApt, error) {
+func CreatePkgApt(r Runner) (*PkgApt, error) {
 	var (
 		err error
 		pk  = new(PkgApt)
@@ -47,8 +57,17 @@ func CreatePkgApt() (*PkgApt, error) {
 		return nil, err
 	}
 
+	if pk.run = r; pk.run == nil {
+		pk.run = NewExecRunner(pk.log)
+	}
+
 	return pk, nil
-} // func CreatePkgApt() (*PkgApt, error)
+} // func CreatePkgApt(r Runner) (*PkgApt, error)
+
+// historyDB returns the connection to the history database.
+func (pk *PkgApt) historyDB() *database.Database {
+	return pk.db
+} // func (pk *PkgApt) historyDB() *database.Database
 
 /*
 Output of apt-cache search emacs (excerpt)
@@ -78,87 +97,540 @@ org-mode-doc - keep notes, maintain ToDo lists, and do project planning in emacs
 
 var patSearchApt = regexp.MustCompile(`(?mi)^(\S+)\s+-\s+([^\n]+)$`)
 
-func (pk *PkgApt) Search(query string) ([]Package, error) {
-	const cmdSearch = "/usr/bin/apt-cache"
+func (pk *PkgApt) Search(ctx context.Context, query string) ([]Package, error) {
+	const cmdSearch = "apt-cache"
 	var (
-		err              error
-		cmd              *exec.Cmd
-		pipeOut, pipeErr io.ReadCloser
-		bufOut, bufErr   bytes.Buffer
+		err    error
+		output string
 	)
 
-	cmd = exec.Command(cmdSearch, "search", query)
-
-	if pipeOut, err = cmd.StdoutPipe(); err != nil {
-		pk.log.Printf("[ERROR] Cannot get stdout pipe from Cmd: %s\n",
+	if output, _, err = runCommand(ctx, pk.run, cmdSearch, "search", query); searchFailed(err) {
+		pk.log.Printf("[ERROR] Failed to search for %q: %s\n",
+			query,
 			err.Error())
 		return nil, err
-	} else if pipeErr, err = cmd.StderrPipe(); err != nil {
-		pk.log.Printf("[ERROR] Cannot get stderr pipe from Cmd: %s\n",
+	}
+
+	var matches = patSearchApt.FindAllStringSubmatch(output, -1)
+
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	var pkList = make([]Package, len(matches))
+
+	for i, m := range matches {
+		pkList[i] = Package{
+			Name:        m[1],
+			Description: m[2],
+		}
+	}
+
+	return pkList, nil
+} // func (pk *PkgApt) Search(ctx context.Context, query string) ([]Package, error)
+
+// For installed packages, dpkg-query tells us everything we want to know in
+// a format of our choosing.
+const fmtInfoDpkgQuery = "${db:Status-Abbrev}\t${Package}\t${Version}\t${binary:Summary}\t${Homepage}\t${Section}\t${Installed-Size}\n"
+
+/*
+Output of apt-cache show --no-all-versions emacs-nox (excerpt)
+Package: emacs-nox
+Version: 1:28.2+1-15
+Installed-Size: 18446
+Maintainer: Rob Browning <rlb@defaultvalue.org>
+Architecture: amd64
+Homepage: https://www.gnu.org/software/emacs/
+Description-en: GNU Emacs editor (without GUI support)
+ GNU Emacs is the extensible self-documenting text editor.
+Section: editors
+*/
+
+func (pk *PkgApt) Info(ctx context.Context, name string) (*PackageInfo, error) {
+	const cmdShow = "apt-cache"
+	var (
+		err    error
+		output string
+		values map[string]string
+		info   = new(PackageInfo)
+	)
+
+	// dpkg-query exits with a non-zero status if it has never heard of
+	// the package. Packages that were removed, but not purged, are still
+	// known to it.
+	if output, _, err = runCommand(ctx, pk.run, cmdDpkgQuery, "-W", "-f", fmtInfoDpkgQuery, name); err == nil {
+		var fields = strings.Split(firstLine(output), "\t")
+
+		if len(fields) == 7 && strings.HasPrefix(fields[0], "ii") {
+			info.Name = fields[1]
+			info.Version = fields[2]
+			info.Description = fields[3]
+			info.URL = fields[4]
+			info.Repository = fields[5]
+			info.Installed = true
+
+			if kb, perr := strconv.ParseInt(fields[6], 10, 64); perr == nil {
+				info.Size = newSize(kb * 1024)
+			}
+
+			return info, nil
+		}
+	}
+
+	if output, _, err = runCommand(ctx, pk.run, cmdShow, "show", "--no-all-versions", name); err != nil {
+		pk.log.Printf("[ERROR] Cannot get info on %s: %s\n",
+			name,
 			err.Error())
 		return nil, err
 	}
 
-	if err = cmd.Start(); err != nil {
-		pk.log.Printf("[ERROR] Error starting command: %s\n",
+	values = parseKeyValues(output)
+
+	info.Name = values["Package"]
+	info.Version = values["Version"]
+	info.URL = values["Homepage"]
+	info.Repository = values["Section"]
+
+	if info.Description = values["Description-en"]; info.Description == "" {
+		info.Description = values["Description"]
+	}
+	info.Description = firstLine(info.Description)
+
+	if kb, perr := strconv.ParseInt(values["Installed-Size"], 10, 64); perr == nil {
+		info.Size = newSize(kb * 1024)
+	}
+
+	return info, nil
+} // func (pk *PkgApt) Info(ctx context.Context, name string) (*PackageInfo, error)
+
+func (pk *PkgApt) Install(ctx context.Context, args ...string) error {
+	return pk.transaction(ctx, event.Add, args)
+} // func (pk *PkgApt) Install(ctx context.Context, args ...string) error
+
+func (pk *PkgApt) Remove(ctx context.Context, args ...string) error {
+	return pk.transaction(ctx, event.Delete, args)
+} // func (pk *PkgApt) Remove(ctx context.Context, args ...string) error
+
+func (pk *PkgApt) Update(ctx context.Context) error {
+	return runTransaction(ctx, pk.run, aptEnv, cmdAptGet, "update")
+} // func (pk *PkgApt) Update(ctx context.Context) error
+
+func (pk *PkgApt) Upgrade(ctx context.Context, securityOnly bool) error {
+	if !securityOnly {
+		return pk.transaction(ctx, event.Update, nil)
+	}
+
+	var (
+		err    error
+		upList []PendingUpgrade
+	)
+
+	// apt-get cannot restrict an upgrade to security fixes, so we upgrade
+	// the packages that have updates from the -security pockets.
+	if upList, err = pk.ListUpgrades(ctx, true); err != nil {
+		return err
+	}
+
+	// Held packages cannot be upgraded by name, so we leave the
+	// excluded ones out.
+	var names = notExcludedNames(ctx, event.Update, upgradeNames(upList))
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	return pk.transaction(ctx, event.Update, names)
+} // func (pk *PkgApt) Upgrade(ctx context.Context, securityOnly bool) error
+
+/*
+Output of apt-get -s upgrade --with-new-pkgs (excerpt)
+apt's own "apt list --upgradable" warns that its output is not meant for
+scripts, so we ask apt-get to simulate the upgrade instead, the same one
+Upgrade performs, so we do not list upgrades it would hold back. Between the
+parentheses are the new version and the archives it comes from. Security
+updates come from the -security pockets, e.g. bookworm-security or
+jammy-security. Packages without a version in brackets are new dependencies.
+
+Inst bash [5.2.15-2+b1] (5.2.15-2+b2 Debian:12.1/stable [amd64])
+Inst libc6 [2.36-9] (2.36-9+deb12u1 Debian-Security:12/stable-security [amd64])
+Inst libssl3 [3.0.8-1] (3.0.9-1 Debian:12.1/stable, Debian-Security:12/stable-security [amd64])
+Conf bash (5.2.15-2+b2 Debian:12.1/stable [amd64])
+*/
+
+var patUpgradeApt = regexp.MustCompile(`(?m)^Inst (\S+) \[(\S+)\] \((\S+) ([^\[]*?) \[[^\]]*\]\)`)
+
+func (pk *PkgApt) ListUpgrades(ctx context.Context, securityOnly bool) ([]PendingUpgrade, error) {
+	var (
+		err     error
+		output  string
+		args, _ = aptArgs(event.Update, nil)
+	)
+
+	if output, _, err = runCommand(ctx, pk.run, cmdAptGet, append([]string{"-s"}, args...)...); err != nil {
+		pk.log.Printf("[ERROR] Cannot list available upgrades: %s\n",
 			err.Error())
 		return nil, err
 	}
 
-	io.Copy(&bufOut, pipeOut) // nolint: errcheck
-	io.Copy(&bufErr, pipeErr) // nolint: errcheck
+	var (
+		matches = patUpgradeApt.FindAllStringSubmatch(output, -1)
+		upList  = make([]PendingUpgrade, 0, len(matches))
+	)
 
-	if err = cmd.Wait(); err != nil {
-		if _, ok := err.(*exec.ExitError); !ok {
-			// FIXME Do something with stderr output!
-			pk.log.Printf("[ERROR] Failed to wait for command: %s\n",
-				err.Error())
-			return nil, err
+	// apt does not know about advisories, so all we can do in security
+	// mode is to look at the archive an update comes from.
+	for _, m := range matches {
+		if securityOnly && !isSecurityPocket(m[4]) {
+			continue
 		}
+
+		upList = append(upList, PendingUpgrade{
+			Name:      m[1],
+			Installed: m[2],
+			Candidate: m[3],
+		})
 	}
 
-	var matches = patSearchApt.FindAllStringSubmatch(bufOut.String(), -1)
+	return upList, nil
+} // func (pk *PkgApt) ListUpgrades(ctx context.Context, securityOnly bool) ([]PendingUpgrade, error)
 
-	if len(matches) == 0 {
-		return nil, nil
+// isSecurityPocket returns true if one of the given archives (as printed by
+// apt-get -s) is one of the -security pockets.
+func isSecurityPocket(archives string) bool {
+	for _, a := range strings.Split(archives, ",") {
+		if strings.HasSuffix(strings.TrimSpace(a), "-security") {
+			return true
+		}
 	}
 
-	var pkList = make([]Package, len(matches))
+	return false
+} // func isSecurityPocket(archives string) bool
 
-	for i, m := range matches {
-		pkList[i] = Package{
-			Name:        m[1],
-			Description: m[2],
+const fmtDpkgQuery = "${db:Status-Abbrev}\t${Package}\t${Version}\t${binary:Summary}\n"
+
+func (pk *PkgApt) ListInstalled(ctx context.Context) ([]Package, error) {
+	var (
+		err    error
+		output string
+		pkList []Package
+	)
+
+	if output, _, err = runCommand(ctx, pk.run, cmdDpkgQuery, "-W", "-f", fmtDpkgQuery); err != nil {
+		pk.log.Printf("[ERROR] Cannot list installed packages: %s\n",
+			err.Error())
+		return nil, err
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		var fields = strings.Split(line, "\t")
+
+		if len(fields) != 4 || !strings.HasPrefix(fields[0], "ii") {
+			continue
 		}
+
+		pkList = append(pkList, Package{
+			Name:        fields[1],
+			Version:     fields[2],
+			Description: fields[3],
+		})
 	}
 
 	return pkList, nil
-} // func (pk *PkgApt) Search(string) ([]Package, error)
+} // func (pkg *PkgApt) ListInstalled(ctx context.Context) ([]Package, error)
 
-func (pk *PkgApt) Install(args ...string) error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgApt) Install(args ...string) error
+func (pk *PkgApt) Clean(ctx context.Context) error {
+	return runTransaction(ctx, pk.run, aptEnv, cmdAptGet, "clean")
+} // func (pk *PkgApt) Clean(ctx context.Context) error
 
-func (pk *PkgApt) Remove(args ...string) error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgApt) Remove(args ...string) error
+func (pkg *PkgApt) LastUpdate(ctx context.Context) (time.Time, error) {
+	return time.Unix(0, 0), krylib.ErrNotImplemented
+} // func (pkg *PkgApt) LastUpdate(ctx context.Context) (time.Time, error)
 
-func (pk *PkgApt) Update() error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgApt) Update() error
+// LockStatus checks the locks dpkg and apt take on their databases and
+// caches.
+func (pk *PkgApt) LockStatus(ctx context.Context) (*LockInfo, error) {
+	return firstLock(
+		fcntlLock("/var/lib/dpkg/lock-frontend"),
+		fcntlLock("/var/lib/dpkg/lock"),
+		fcntlLock("/var/lib/apt/lists/lock"),
+		fcntlLock("/var/cache/apt/archives/lock"),
+	), nil
+} // func (pk *PkgApt) LockStatus(ctx context.Context) (*LockInfo, error)
 
-func (pk *PkgApt) Upgrade() error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgApt) Upgrade() error
+/*
+Output of apt-cache depends --installed --no-recommends ... emacs-gtk (excerpt)
+emacs-gtk
+  Depends: emacs-bin-common
+  Depends: emacs-common
+  Depends: libasound2
+  Depends: libc6
+ |Depends: libgnutls30
+  Depends: <awk>
+    mawk
 
-func (pk *PkgApt) ListInstalled() ([]Package, error) {
-	return nil, krylib.ErrNotImplemented
-} // func (pkg *PkgApt) ListInstalled() ([]Package, error)
+Output of apt-cache rdepends --installed libgnutls30 (excerpt)
+libgnutls30
+Reverse Depends:
+  emacs-gtk
+  libcurl3-gnutls
+ |wget
+*/
 
-func (pk *PkgApt) Clean() error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgApt) Clean() error
+var (
+	patDependsApt    = regexp.MustCompile(`(?m)^[ \t]+\|?(?:Pre)?Depends:[ \t]+([^\s<>]+)[ \t]*$`)
+	patRequiredByApt = regexp.MustCompile(`(?m)^[ \t]+\|?(\S+)[ \t]*$`)
+)
 
-func (pkg *PkgApt) LastUpdate() (time.Time, error) {
-	return time.Unix(0, 0), krylib.ErrNotImplemented
-} // func (pkg *PkgApt) LastUpdate() (time.Time, error)
+func (pk *PkgApt) Depends(ctx context.Context, name string) ([]string, error) {
+	const cmdDepends = "apt-cache"
+	var (
+		err    error
+		output string
+	)
+
+	if output, _, err = runCommand(ctx, pk.run, cmdDepends,
+		"depends",
+		"--installed",
+		"--no-recommends",
+		"--no-suggests",
+		"--no-conflicts",
+		"--no-breaks",
+		"--no-replaces",
+		"--no-enhances",
+		name); err != nil {
+		pk.log.Printf("[ERROR] Cannot get dependencies of %s: %s\n",
+			name,
+			err.Error())
+		return nil, err
+	}
+
+	return submatches(patDependsApt, output), nil
+} // func (pk *PkgApt) Depends(ctx context.Context, name string) ([]string, error)
+
+func (pk *PkgApt) RequiredBy(ctx context.Context, name string) ([]string, error) {
+	const cmdDepends = "apt-cache"
+	var (
+		err    error
+		output string
+	)
+
+	if output, _, err = runCommand(ctx, pk.run, cmdDepends, "rdepends", "--installed", name); err != nil {
+		pk.log.Printf("[ERROR] Cannot get reverse dependencies of %s: %s\n",
+			name,
+			err.Error())
+		return nil, err
+	}
+
+	return submatches(patRequiredByApt, output), nil
+} // func (pk *PkgApt) RequiredBy(ctx context.Context, name string) ([]string, error)
+
+// aptArgs returns the arguments to apt-get for the given operation on the
+// given packages.
+func aptArgs(op event.ID, pkgs []string) ([]string, error) {
+	switch op {
+	case event.Add:
+		return append([]string{"install"}, pkgs...), nil
+	case event.Delete:
+		return append([]string{"remove"}, pkgs...), nil
+	case event.Update:
+		if len(pkgs) == 0 {
+			return []string{"upgrade", "--with-new-pkgs"}, nil
+		}
+		return append([]string{"install", "--only-upgrade"}, pkgs...), nil
+	default:
+		return nil, errUnsupportedOp(op)
+	}
+} // func aptArgs(op event.ID, pkgs []string) ([]string, error)
+
+// transaction performs the given operation on the given packages.
+func (pk *PkgApt) transaction(ctx context.Context, op event.ID, pkgs []string) error {
+	var (
+		err     error
+		args    []string
+		release func()
+	)
+
+	if args, err = aptArgs(op, pkgs); err != nil {
+		return err
+	} else if release, err = pk.holder().holdExcluded(ctx, op); err != nil {
+		return err
+	}
+
+	defer release()
+
+	return runTransaction(ctx, pk.run, aptEnv, cmdAptGet, append([]string{"-y"}, args...)...)
+} // func (pk *PkgApt) transaction(ctx context.Context, op event.ID, pkgs []string) error
+
+// holder keeps excluded packages out of a transaction by holding them with
+// apt-mark, as apt-get has no way to skip packages otherwise.
+func (pk *PkgApt) holder() *holder {
+	var mark = func(verb string) holdFunc {
+		return func(ctx context.Context, names []string) error {
+			var _, _, err = runPrivileged(ctx, pk.run, nil, cmdAptMark, append([]string{verb}, names...)...)
+
+			if err != nil {
+				pk.log.Printf("[ERROR] Cannot %s %s: %s\n",
+					verb,
+					strings.Join(names, ", "),
+					err.Error())
+			}
+
+			return err
+		}
+	}
+
+	return &holder{
+		installed: pk.ListInstalled,
+		held: func(ctx context.Context) ([]string, error) {
+			var output, _, err = runCommand(ctx, pk.run, cmdAptMark, "showhold")
+
+			return strings.Fields(output), err
+		},
+		hold:    mark("hold"),
+		release: mark("unhold"),
+	}
+} // func (pk *PkgApt) holder() *holder
+
+/*
+Output of apt-get -y install emacs (excerpt)
+0 upgraded, 3 newly installed, 0 to remove and 0 not upgraded.
+Need to get 37.4 MB of archives.
+Get:1 http://deb.debian.org/debian bookworm/main amd64 emacs-common all 1:28.2+1-15 [13.6 MB]
+Preparing to unpack .../emacs-common_1%3a28.2+1-15_all.deb ...
+Unpacking emacs-common (1:28.2+1-15) ...
+Unpacking libc6:amd64 (2.36-9+deb12u3) over (2.36-9+deb12u1) ...
+Removing yasr (0.6.9-10) ...
+Setting up emacs-common (1:28.2+1-15) ...
+*/
+
+var (
+	patProgressTotalApt  = regexp.MustCompile(`^(\d+) upgraded, (\d+) newly installed, (?:(\d+) downgraded, )?(\d+) to remove`)
+	patProgressGetApt    = regexp.MustCompile(`^Get:\d+ \S+ \S+ \S+ (\S+) \S+ \S+ \[`)
+	patProgressUnpackApt = regexp.MustCompile(`^Unpacking (\S+) \([^)]+\)( over)?`)
+	patProgressRemoveApt = regexp.MustCompile(`^Removing (\S+) \(`)
+)
+
+// parseProgressApt makes sense of a line of apt-get's output. A package
+// counts as done once it is unpacked.
+func parseProgressApt(line string) *Progress {
+	var m []string
+
+	if m = patProgressTotalApt.FindStringSubmatch(line); m != nil {
+		var total int
+
+		for _, n := range m[1:] {
+			if i, err := strconv.Atoi(n); err == nil {
+				total += i
+			}
+		}
+
+		return &Progress{Stage: stage.Output, Total: total}
+	} else if m = patProgressGetApt.FindStringSubmatch(line); m != nil {
+		return &Progress{Stage: stage.Download, Package: m[1]}
+	} else if m = patProgressRemoveApt.FindStringSubmatch(line); m != nil {
+		return &Progress{Stage: stage.Remove, Package: aptName(m[1])}
+	} else if m = patProgressUnpackApt.FindStringSubmatch(line); m == nil {
+		return nil
+	} else if m[2] != "" {
+		return &Progress{Stage: stage.Upgrade, Package: aptName(m[1])}
+	}
+
+	return &Progress{Stage: stage.Install, Package: aptName(m[1])}
+} // func parseProgressApt(line string) *Progress
+
+// aptName strips the architecture dpkg appends to the names of some
+// packages.
+func aptName(s string) string {
+	var name, _, _ = strings.Cut(s, ":")
+
+	return name
+} // func aptName(s string) string
+
+/*
+Output of apt-get -s install emacs (excerpt)
+NOTE: This is only a simulation!
+      apt-get needs root privileges for real execution.
+      Keep also in mind that locking is deactivated,
+      so don't depend on the relevance to the real current situation!
+Inst emacs-common (1:28.2+1-15 Debian:12.0/stable [all])
+Inst bash [5.2.15-2+b1] (5.2.15-2+b2 Debian:12.1/stable [amd64])
+Remv foo [1.0-1]
+Conf emacs-common (1:28.2+1-15 Debian:12.0/stable [all])
+
+apt-get -s does not print any sizes, so we also run apt-get --assume-no:
+Need to get 31.2 MB/33.0 MB of archives.
+After this operation, 124 MB of additional disk space will be used.
+Abort.
+*/
+
+var (
+	patPlanInstApt     = regexp.MustCompile(`(?m)^Inst (\S+) (?:\[(\S+)\] )?\((\S+)`)
+	patPlanRemvApt     = regexp.MustCompile(`(?m)^Remv (\S+)(?: \[(\S+)\])?`)
+	patPlanDownloadApt = regexp.MustCompile(`(?m)^Need to get ([\d.,]+ [kMG]?B)`)
+	patPlanDiskApt     = regexp.MustCompile(`(?m)^After this operation, ([\d.,]+ [kMG]?B) (of additional disk space will be used|disk space will be freed)`)
+)
+
+func (pk *PkgApt) Preview(ctx context.Context, op event.ID, pkgs ...string) (*Plan, error) {
+	var (
+		err     error
+		args    []string
+		output  string
+		release func()
+		plan    = newPlan(op)
+	)
+
+	// Holding the excluded packages takes root privileges, even though
+	// the simulation itself does not.
+	if args, err = aptArgs(op, pkgs); err != nil {
+		return nil, err
+	} else if release, err = pk.holder().holdExcluded(ctx, op); err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	if output, _, err = runCommand(ctx, pk.run, cmdAptGet, append([]string{"-s"}, args...)...); err != nil {
+		pk.log.Printf("[ERROR] Failed to simulate %s: %s\n",
+			op,
+			err.Error())
+		return nil, err
+	}
+
+	for _, m := range patPlanInstApt.FindAllStringSubmatch(output, -1) {
+		plan.Changes = append(plan.Changes, Change{
+			Name:       m[1],
+			Action:     versionChange(m[2], m[3]),
+			OldVersion: m[2],
+			NewVersion: m[3],
+		})
+	}
+
+	for _, m := range patPlanRemvApt.FindAllStringSubmatch(output, -1) {
+		plan.Changes = append(plan.Changes, Change{
+			Name:       m[1],
+			Action:     action.Remove,
+			OldVersion: m[2],
+		})
+	}
+
+	if plan.Empty() {
+		return plan, nil
+	}
+
+	// apt-get exits with a non-zero status when we decline, and failing
+	// to get the sizes is not fatal, so we ignore the error.
+	output, _, _ = runCommand(ctx, pk.run, cmdAptGet,
+		append([]string{"--assume-no", "-o", "Debug::NoLocking=true"}, args...)...)
+
+	if m := patPlanDownloadApt.FindStringSubmatch(output); m != nil {
+		plan.DownloadSize = parseSize(m[1])
+	}
+
+	if m := patPlanDiskApt.FindStringSubmatch(output); m != nil {
+		if plan.DiskDelta = parseSize(m[1]); strings.Contains(m[2], "freed") {
+			negateSize(plan.DiskDelta)
+		}
+	}
+
+	return plan, nil
+} // func (pk *PkgApt) Preview(ctx context.Context, op event.ID, pkgs ...string) (*Plan, error)
@@ -7,29 +7,34 @@
 package backend
 
 import (
-	"bytes"
-	"io"
+	"context"
+	"fmt"
 	"log"
-	"os/exec"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/blicero/krylib"
+	"github.com/blicero/pkman/backend/stage"
 	"github.com/blicero/pkman/common"
 	"github.com/blicero/pkman/database"
+	"github.com/blicero/pkman/database/action"
+	"github.com/blicero/pkman/database/event"
 	"github.com/blicero/pkman/logdomain"
 )
 
-const cmdDnf = "/usr/bin/dnf"
+const cmdDnf = "dnf"
 
 type PkgDnf struct {
 	log *log.Logger
 	db  *database.Database
+	run Runner
 }
 
 // CreatePkgDnf creates a PkgDnf instance to interface with the dnf
 // package manager used by RHEL, Fedora, and related systems.
-func CreatePkgDnf() (*PkgDnf, error) {
+func CreatePkgDnf(r Runner) (*PkgDnf, error) {
 	var (
 		err error
 		pk  = new(PkgDnf)
@@ -44,8 +49,17 @@ func CreatePkgDnf() (*PkgDnf, error) {
 		return nil, err
 	}
 
+	if pk.run = r; pk.run == nil {
+		pk.run = NewExecRunner(pk.log)
+	}
+
 	return pk, nil
-} // func CreatePkgDnf() (*PkgDnf, error)
+} // func CreatePkgDnf(r Runner) (*PkgDnf, error)
+
+// historyDB returns the connection to the history database.
+func (pk *PkgDnf) historyDB() *database.Database {
+	return pk.db
+} // func (pk *PkgDnf) historyDB() *database.Database
 
 /*
 	Sample output of dnf search:
@@ -64,88 +78,512 @@ poke-emacs.noarch : Emacs support for poke
 mg.x86_64 : Tiny Emacs-like editor
 */
 
-var patSearchDnf = regexp.MustCompile(`(?im)^(\S+)\s+:\s+([^\n]+)$`)
+// The names carry the architecture, e.g. emacs.x86_64, which we strip.
+var patSearchDnf = regexp.MustCompile(`(?m)^(\S+)\.[^.\s]+\s+:\s+([^\n]+)$`)
 
-func (pk *PkgDnf) Search(query string) ([]Package, error) {
+func (pk *PkgDnf) Search(ctx context.Context, query string) ([]Package, error) {
 	var (
-		err              error
-		cmd              *exec.Cmd
-		pipeOut, pipeErr io.ReadCloser
-		bufOut, bufErr   bytes.Buffer
+		err    error
+		output string
 	)
 
-	cmd = exec.Command(cmdDnf, "search", query)
-
-	if pipeOut, err = cmd.StdoutPipe(); err != nil {
-		pk.log.Printf("[ERROR] Cannot get stdout pipe from Cmd: %s\n",
+	if output, _, err = runCommand(ctx, pk.run, cmdDnf, "search", query); searchFailed(err) {
+		pk.log.Printf("[ERROR] Failed to search for %q: %s\n",
+			query,
 			err.Error())
 		return nil, err
-	} else if pipeErr, err = cmd.StderrPipe(); err != nil {
-		pk.log.Printf("[ERROR] Cannot get stderr pipe from Cmd: %s\n",
+	}
+
+	var matches = patSearchDnf.FindAllStringSubmatch(output, -1)
+
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	var pkList = make([]Package, len(matches))
+
+	for i, m := range matches {
+		pkList[i] = Package{
+			Name:        m[1],
+			Description: m[2],
+		}
+	}
+
+	return pkList, nil
+} // func (pk *PkgDnf) Search(ctx context.Context, query string) ([]Package, error)
+
+/*
+dnf repoquery lets us specify the output format, so we do not have to parse
+dnf info. For installed packages, reponame is @System, and from_repo is the
+repository the package was installed from.
+
+Output of dnf repoquery --quiet --installed --qf <fmtInfoDnf> emacs
+emacs	28.2-3.fc38	GNU Emacs text editor	https://www.gnu.org/software/emacs/	GPL-3.0-or-later AND CC0-1.0	49283072	@System	fedora
+*/
+
+const fmtInfoDnf = `%{name}\t%{version}-%{release}\t%{summary}\t%{url}\t%{license}\t%{installsize}\t%{reponame}\t%{from_repo}\n`
+
+func (pk *PkgDnf) Info(ctx context.Context, name string) (*PackageInfo, error) {
+	var (
+		err    error
+		output string
+		fields []string
+	)
+
+	// We prefer the installed version, if there is one.
+	if output, _, err = runCommand(ctx, pk.run, cmdDnf, "repoquery", "--quiet", "--installed", "--qf", fmtInfoDnf, name); err == nil && output == "" {
+		output, _, err = runCommand(ctx, pk.run, cmdDnf, "repoquery", "--quiet", "--latest-limit", "1", "--qf", fmtInfoDnf, name)
+	}
+
+	if err != nil {
+		pk.log.Printf("[ERROR] Cannot get info on %s: %s\n",
+			name,
 			err.Error())
 		return nil, err
+	} else if output == "" {
+		return nil, fmt.Errorf("%s: %w", name, ErrNotFound)
+	} else if fields = strings.Split(firstLine(output), "\t"); len(fields) != 8 {
+		return nil, fmt.Errorf("Cannot parse output of dnf repoquery: %q", output)
 	}
 
-	if err = cmd.Start(); err != nil {
-		pk.log.Printf("[ERROR] Error starting command: %s\n",
+	var info = &PackageInfo{
+		Package: Package{
+			Name:        fields[0],
+			Version:     fields[1],
+			Description: fields[2],
+		},
+		Installed:  fields[6] == "@System",
+		Repository: fields[6],
+		URL:        fields[3],
+		License:    fields[4],
+	}
+
+	if info.Installed && fields[7] != "" {
+		info.Repository = fields[7]
+	}
+
+	if size, perr := strconv.ParseInt(fields[5], 10, 64); perr == nil {
+		info.Size = newSize(size)
+	}
+
+	return info, nil
+} // func (pk *PkgDnf) Info(ctx context.Context, name string) (*PackageInfo, error)
+
+func (pk *PkgDnf) Install(ctx context.Context, args ...string) error {
+	return pk.transaction(ctx, event.Add, args)
+} // func (pk *PkgDnf) Install(ctx context.Context, args ...string) error
+
+func (pk *PkgDnf) Remove(ctx context.Context, args ...string) error {
+	return pk.transaction(ctx, event.Delete, args)
+} // func (pk *PkgDnf) Remove(ctx context.Context, args ...string) error
+
+func (pk *PkgDnf) Update(ctx context.Context) error {
+	return runTransaction(ctx, pk.run, nil, cmdDnf, "-y", "makecache")
+} // func (pk *PkgDnf) Update(ctx context.Context) error
+
+func (pk *PkgDnf) Upgrade(ctx context.Context, securityOnly bool) error {
+	if securityOnly {
+		return runTransaction(ctx, pk.run, nil, cmdDnf,
+			append(dnfExclude(ctx, event.Update), "-y", "upgrade", "--security")...)
+	}
+
+	return pk.transaction(ctx, event.Update, nil)
+} // func (pk *PkgDnf) Upgrade(ctx context.Context, securityOnly bool) error
+
+/*
+Output of dnf repoquery --quiet --upgrades --latest-limit 1 --qf '%{name}\t%{evr}\n'
+Packages that are installed for more than one architecture appear once for
+each of them.
+
+bash	5.2.15-5.fc38
+glibc	2.37-5.fc38
+glibc	2.37-5.fc38
+grub2-tools	1:2.06-95.fc38
+*/
+
+const fmtUpgradeDnf = `%{name}\t%{evr}\n`
+
+func (pk *PkgDnf) ListUpgrades(ctx context.Context, securityOnly bool) ([]PendingUpgrade, error) {
+	var (
+		err       error
+		output    string
+		installed []Package
+		versions  = make(map[string]string)
+		upList    []PendingUpgrade
+	)
+
+	// dnf does not tell us the version that is currently installed, so we
+	// have to look it up.
+	if installed, err = pk.ListInstalled(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, p := range installed {
+		versions[p.Name] = p.Version
+	}
+
+	if securityOnly {
+		return pk.listSecurityUpgrades(ctx, versions)
+	}
+
+	if output, _, err = runCommand(ctx, pk.run, cmdDnf,
+		"repoquery",
+		"--quiet",
+		"--upgrades",
+		"--latest-limit", "1",
+		"--qf", fmtUpgradeDnf); err != nil {
+		pk.log.Printf("[ERROR] Cannot list available upgrades: %s\n",
 			err.Error())
 		return nil, err
 	}
 
-	io.Copy(&bufOut, pipeOut) // nolint: errcheck
-	io.Copy(&bufErr, pipeErr) // nolint: errcheck
+	for _, line := range uniqueLines(output) {
+		var fields = strings.Split(line, "\t")
 
-	if err = cmd.Wait(); err != nil {
-		if _, ok := err.(*exec.ExitError); !ok {
-			// FIXME Do something with stderr output!
-			pk.log.Printf("[ERROR] Failed to wait for command: %s\n",
-				err.Error())
-			return nil, err
+		if len(fields) != 2 {
+			continue
 		}
+
+		upList = append(upList, PendingUpgrade{
+			Name:      fields[0],
+			Installed: versions[fields[0]],
+			Candidate: fields[1],
+		})
 	}
 
-	var matches = patSearchDnf.FindAllStringSubmatch(bufOut.String(), -1)
+	return upList, nil
+} // func (pk *PkgDnf) ListUpgrades(ctx context.Context, securityOnly bool) ([]PendingUpgrade, error)
 
-	if len(matches) == 0 {
-		return nil, nil
+/*
+Output of dnf updateinfo list --security (excerpt)
+FEDORA-2023-1c5e3c4e3f Moderate/Sec.  curl-8.0.1-4.fc38.x86_64
+FEDORA-2023-1c5e3c4e3f Moderate/Sec.  libcurl-8.0.1-4.fc38.x86_64
+FEDORA-2023-6d4fe1b34a Important/Sec. glibc-2.37-5.fc38.x86_64
+*/
+
+var patSecurityDnf = regexp.MustCompile(`(?m)^(\S+)\s+\S+/Sec\.\s+(\S+)\s*$`)
+
+// listSecurityUpgrades lists the pending security updates. One package may
+// be affected by several advisories, in which case we merge them into a
+// single PendingUpgrade.
+func (pk *PkgDnf) listSecurityUpgrades(ctx context.Context, versions map[string]string) ([]PendingUpgrade, error) {
+	var (
+		err    error
+		output string
+		upList []PendingUpgrade
+		idx    = make(map[string]int)
+	)
+
+	if output, _, err = runCommand(ctx, pk.run, cmdDnf, "--quiet", "updateinfo", "list", "--security"); err != nil {
+		pk.log.Printf("[ERROR] Cannot list available security updates: %s\n",
+			err.Error())
+		return nil, err
 	}
 
-	var pkList = make([]Package, len(matches))
+	for _, m := range patSecurityDnf.FindAllStringSubmatch(output, -1) {
+		var name, version = splitNEVRA(m[2])
 
-	for i, m := range matches {
-		pkList[i] = Package{
-			Name:        m[1],
-			Description: m[2],
+		if i, ok := idx[name]; ok {
+			upList[i].Advisories = append(upList[i].Advisories, m[1])
+			continue
 		}
+
+		idx[name] = len(upList)
+		upList = append(upList, PendingUpgrade{
+			Name:       name,
+			Installed:  versions[name],
+			Candidate:  version,
+			Advisories: []string{m[1]},
+		})
 	}
 
-	return pkList, nil
-} // func (pk *PkgDnf) Search(string) ([]Package, error)
+	return upList, nil
+} // func (pk *PkgDnf) listSecurityUpgrades(ctx context.Context, versions map[string]string) ([]PendingUpgrade, error)
 
-func (pk *PkgDnf) Install(args ...string) error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgDnf) Install(args ...string) error
+func (pk *PkgDnf) ListInstalled(ctx context.Context) ([]Package, error) {
+	return rpmListInstalled(ctx, pk.run, pk.log)
+} // func (pkg *PkgDnf) ListInstalled(ctx context.Context) ([]Package, error)
 
-func (pk *PkgDnf) Remove(args ...string) error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgDnf) Remove(args ...string) error
+func (pk *PkgDnf) Clean(ctx context.Context) error {
+	return runTransaction(ctx, pk.run, nil, cmdDnf, "clean", "all")
+} // func (pk *PkgDnf) Clean(ctx context.Context) error
 
-func (pk *PkgDnf) Update() error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgDnf) Update() error
+func (pkg *PkgDnf) LastUpdate(ctx context.Context) (time.Time, error) {
+	return time.Unix(0, 0), krylib.ErrNotImplemented
+} // func (pkg *PkgDnf) LastUpdate(ctx context.Context) (time.Time, error)
 
-func (pk *PkgDnf) Upgrade() error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgDnf) Upgrade() error
+// LockStatus checks dnf's PID files and the lock on the rpm database.
+func (pk *PkgDnf) LockStatus(ctx context.Context) (*LockInfo, error) {
+	return firstLock(
+		pidFileLock("/var/lib/dnf/rpmdb_lock.pid"),
+		pidFileLock("/var/cache/dnf/metadata_lock.pid"),
+		fcntlLock("/var/lib/rpm/.rpm.lock"),
+	), nil
+} // func (pk *PkgDnf) LockStatus(ctx context.Context) (*LockInfo, error)
 
-func (pk *PkgDnf) ListInstalled() ([]Package, error) {
-	return nil, krylib.ErrNotImplemented
-} // func (pkg *PkgDnf) ListInstalled() ([]Package, error)
+/*
+Output of dnf repoquery --installed --requires --resolve --qf '%{name}\n' emacs
 
-func (pk *PkgDnf) Clean() error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgDnf) Clean() error
+emacs-common
+glibc
+gnutls
+gtk3
+*/
 
-func (pkg *PkgDnf) LastUpdate() (time.Time, error) {
-	return time.Unix(0, 0), krylib.ErrNotImplemented
-} // func (pkg *PkgDnf) LastUpdate() (time.Time, error)
+func (pk *PkgDnf) Depends(ctx context.Context, name string) ([]string, error) {
+	var (
+		err    error
+		output string
+	)
+
+	if output, _, err = runCommand(ctx, pk.run, cmdDnf,
+		"repoquery",
+		"--quiet",
+		"--installed",
+		"--requires",
+		"--resolve",
+		"--qf", `%{name}\n`,
+		name); err != nil {
+		pk.log.Printf("[ERROR] Cannot get dependencies of %s: %s\n",
+			name,
+			err.Error())
+		return nil, err
+	}
+
+	return submatches(patRpmName, output), nil
+} // func (pk *PkgDnf) Depends(ctx context.Context, name string) ([]string, error)
+
+func (pk *PkgDnf) RequiredBy(ctx context.Context, name string) ([]string, error) {
+	var (
+		err    error
+		output string
+	)
+
+	if output, _, err = runCommand(ctx, pk.run, cmdDnf,
+		"repoquery",
+		"--quiet",
+		"--installed",
+		"--whatrequires", name,
+		"--qf", `%{name}\n`); err != nil {
+		pk.log.Printf("[ERROR] Cannot get reverse dependencies of %s: %s\n",
+			name,
+			err.Error())
+		return nil, err
+	}
+
+	return submatches(patRpmName, output), nil
+} // func (pk *PkgDnf) RequiredBy(ctx context.Context, name string) ([]string, error)
+
+// dnfArgs returns the arguments to dnf for the given operation on the given
+// packages.
+func dnfArgs(op event.ID, pkgs []string) ([]string, error) {
+	switch op {
+	case event.Add:
+		return append([]string{"install"}, pkgs...), nil
+	case event.Delete:
+		return append([]string{"remove"}, pkgs...), nil
+	case event.Update:
+		return append([]string{"upgrade"}, pkgs...), nil
+	default:
+		return nil, errUnsupportedOp(op)
+	}
+} // func dnfArgs(op event.ID, pkgs []string) ([]string, error)
+
+// dnfExclude returns the options that make dnf skip the packages that are
+// excluded for op.
+func dnfExclude(ctx context.Context, op event.ID) []string {
+	var opts []string
+
+	for _, pat := range excludePatterns(ctx, op) {
+		opts = append(opts, "--exclude="+pat)
+	}
+
+	return opts
+} // func dnfExclude(ctx context.Context, op event.ID) []string
+
+// transaction performs the given operation on the given packages.
+func (pk *PkgDnf) transaction(ctx context.Context, op event.ID, pkgs []string) error {
+	var (
+		err  error
+		args []string
+	)
+
+	if args, err = dnfArgs(op, pkgs); err != nil {
+		return err
+	}
+
+	args = append(append(dnfExclude(ctx, op), "-y"), args...)
+
+	return runTransaction(ctx, pk.run, nil, cmdDnf, args...)
+} // func (pk *PkgDnf) transaction(ctx context.Context, op event.ID, pkgs []string) error
+
+/*
+Output of dnf -y install emacs (excerpt)
+Downloading Packages:
+(1/3): emacs-common-28.2-3.fc38.x86_64.rpm      6.1 MB/s |  38 MB     00:06
+  Installing       : emacs-common-1:28.2-3.fc38.x86_64                     1/3
+  Upgrading        : glibc-2.37-4.fc38.x86_64                              2/3
+  Erasing          : yasr-0.6.9-10.fc38.x86_64                             3/3
+*/
+
+var (
+	patProgressDownloadDnf = regexp.MustCompile(`^\((\d+)/(\d+)\): (\S+)\.rpm\s`)
+	patProgressStepDnf     = regexp.MustCompile(`^\s+(Installing|Upgrading|Downgrading|Reinstalling|Erasing|Removing)\s*: (\S+)\s+(\d+)/(\d+)$`)
+)
+
+var progressStagesDnf = map[string]stage.ID{
+	"Installing":   stage.Install,
+	"Upgrading":    stage.Upgrade,
+	"Downgrading":  stage.Downgrade,
+	"Reinstalling": stage.Reinstall,
+	"Erasing":      stage.Remove,
+	"Removing":     stage.Remove,
+}
+
+// parseProgressDnf makes sense of a line of dnf's output.
+// dnf counts the steps of the transaction, which include cleaning up after
+// the old versions of upgraded packages, so Total may be larger than the
+// number of packages.
+func parseProgressDnf(line string) *Progress {
+	var (
+		m    []string
+		name string
+	)
+
+	if m = patProgressDownloadDnf.FindStringSubmatch(line); m != nil {
+		var p = &Progress{Stage: stage.Download}
+
+		p.Package, _ = splitNEVRA(m[3])
+		p.Done, _ = strconv.Atoi(m[1])
+		p.Total, _ = strconv.Atoi(m[2])
+
+		return p
+	} else if m = patProgressStepDnf.FindStringSubmatch(line); m == nil {
+		return nil
+	}
+
+	name, _ = splitNEVRA(m[2])
+
+	var p = &Progress{Stage: progressStagesDnf[m[1]], Package: name}
+
+	p.Done, _ = strconv.Atoi(m[3])
+	p.Total, _ = strconv.Atoi(m[4])
+
+	return p
+} // func parseProgressDnf(line string) *Progress
+
+/*
+Output of dnf --assumeno install emacs (excerpt)
+Dependencies resolved.
+================================================================================
+ Package              Architecture  Version                Repository      Size
+================================================================================
+Installing:
+ emacs                x86_64        1:28.2-3.fc38          fedora         3.4 M
+Installing dependencies:
+ emacs-common         x86_64        1:28.2-3.fc38          fedora          38 M
+Upgrading:
+ bash                 x86_64        5.2.15-5.fc38          updates        1.8 M
+
+Transaction Summary
+================================================================================
+Install  2 Packages
+Upgrade  1 Package
+
+Total download size: 43 M
+Installed size: 120 M
+Operation aborted.
+*/
+
+var (
+	patPlanSectionDnf  = regexp.MustCompile(`^(Installing|Upgrading|Downgrading|Removing|Reinstalling)[^:]*:$`)
+	patPlanPackageDnf  = regexp.MustCompile(`^ (\S+)\s+\S+\s+(\S+)\s+\S+\s+[\d.]+ [kMG]?$`)
+	patPlanDownloadDnf = regexp.MustCompile(`(?m)^Total download size: ([\d.]+ [kMG]?)$`)
+	patPlanDiskDnf     = regexp.MustCompile(`(?m)^(Installed size|Freed space): ([\d.]+ [kMG]?)$`)
+)
+
+var dnfSections = map[string]action.ID{
+	"Installing":   action.Install,
+	"Upgrading":    action.Upgrade,
+	"Downgrading":  action.Downgrade,
+	"Removing":     action.Remove,
+	"Reinstalling": action.Reinstall,
+}
+
+func (pk *PkgDnf) Preview(ctx context.Context, op event.ID, pkgs ...string) (*Plan, error) {
+	var (
+		err       error
+		args      []string
+		output    string
+		installed []Package
+		act       action.ID
+		inSection bool
+		versions  = make(map[string]string)
+		plan      = newPlan(op)
+	)
+
+	if args, err = dnfArgs(op, pkgs); err != nil {
+		return nil, err
+	} else if installed, err = pk.ListInstalled(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, p := range installed {
+		versions[p.Name] = p.Version
+	}
+
+	// dnf refuses to resolve a transaction for anyone but root, even if
+	// we decline it right away. It exits with a non-zero status when we
+	// do, so an error only counts if we got nothing useful out of it.
+	args = append(append(dnfExclude(ctx, op), "--assumeno"), args...)
+	output, _, err = runPrivileged(ctx, pk.run, nil, cmdDnf, args...)
+
+	for _, line := range strings.Split(output, "\n") {
+		var m []string
+
+		if m = patPlanSectionDnf.FindStringSubmatch(line); m != nil {
+			act, inSection = dnfSections[m[1]], true
+			continue
+		} else if !strings.HasPrefix(line, " ") {
+			inSection = false
+			continue
+		} else if !inSection {
+			continue
+		} else if m = patPlanPackageDnf.FindStringSubmatch(line); m == nil {
+			continue
+		}
+
+		var c = Change{Name: m[1], Action: act}
+
+		switch act {
+		case action.Install:
+			c.NewVersion = m[2]
+		case action.Remove:
+			c.OldVersion = m[2]
+		default:
+			c.OldVersion = versions[m[1]]
+			c.NewVersion = m[2]
+		}
+
+		plan.Changes = append(plan.Changes, c)
+	}
+
+	if err != nil && plan.Empty() {
+		pk.log.Printf("[ERROR] Failed to simulate %s: %s\n",
+			op,
+			err.Error())
+		return nil, err
+	}
+
+	if m := patPlanDownloadDnf.FindStringSubmatch(output); m != nil {
+		plan.DownloadSize = parseSize(m[1])
+	}
+
+	if m := patPlanDiskDnf.FindStringSubmatch(output); m != nil {
+		if plan.DiskDelta = parseSize(m[2]); m[1] == "Freed space" {
+			negateSize(plan.DiskDelta)
+		}
+	}
+
+	return plan, nil
+} // func (pk *PkgDnf) Preview(ctx context.Context, op event.ID, pkgs ...string) (*Plan, error)
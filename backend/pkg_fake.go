@@ -0,0 +1,735 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/pkg_fake.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blicero/pkman/backend/stage"
+	"github.com/blicero/pkman/common"
+	"github.com/blicero/pkman/database"
+	"github.com/blicero/pkman/database/action"
+	"github.com/blicero/pkman/database/event"
+	"github.com/blicero/pkman/logdomain"
+	"gopkg.in/yaml.v3"
+)
+
+// FakeBackend is the name GetPkgManager knows the fake backend by. To load a
+// catalog, append its path, separated by a colon, e.g. fake:/tmp/demo.yaml.
+const FakeBackend = "fake"
+
+// cmdFake is what errors of the fake backend claim the failed command was,
+// and what the history records as the command the fake backend ran.
+const cmdFake = "fake"
+
+// FakeCatalog describes the system a PkgFake pretends to manage.
+// Lock, if it is not nil, is reported by LockStatus. Failures are the errors
+// the PkgFake returns instead of doing what it is asked.
+type FakeCatalog struct {
+	Packages   []*FakePackage `json:"packages" yaml:"packages"`
+	Failures   []*FakeFailure `json:"failures" yaml:"failures"`
+	Lock       *LockInfo      `json:"lock" yaml:"lock"`
+	LastUpdate time.Time      `json:"last_update" yaml:"last_update"`
+}
+
+// FakePackage is a package in a FakeCatalog.
+// Version is the version the repositories offer, Installed the version that
+// is installed, or empty if the package is not installed. If the installed
+// version is older, the package has an upgrade pending, which is a security
+// update if Advisories is not empty. Depends holds the names of the packages
+// it depends on.
+type FakePackage struct {
+	Name        string   `json:"name" yaml:"name"`
+	Version     string   `json:"version" yaml:"version"`
+	Installed   string   `json:"installed" yaml:"installed"`
+	Description string   `json:"description" yaml:"description"`
+	Repository  string   `json:"repository" yaml:"repository"`
+	URL         string   `json:"url" yaml:"url"`
+	License     string   `json:"license" yaml:"license"`
+	Size        int64    `json:"size" yaml:"size"`
+	Depends     []string `json:"depends" yaml:"depends"`
+	Advisories  []string `json:"advisories" yaml:"advisories"`
+}
+
+// FakeFailure makes a PkgFake fail an operation.
+// Op is the operation to fail, see fakeOps, or empty for all of them. If
+// Package is not empty, only operations involving that package fail. Error is
+// the kind of error, see fakeErrors, Message what the "command" writes to
+// stderr. Count is how many times the operation fails before it succeeds,
+// zero means it always fails.
+type FakeFailure struct {
+	Op      string `json:"op" yaml:"op"`
+	Package string `json:"package" yaml:"package"`
+	Error   string `json:"error" yaml:"error"`
+	Message string `json:"message" yaml:"message"`
+	Count   int    `json:"count" yaml:"count"`
+}
+
+// fakeOps are the operations a FakeFailure can refer to.
+var fakeOps = map[string]bool{
+	"search":         true,
+	"info":           true,
+	"install":        true,
+	"remove":         true,
+	"update":         true,
+	"upgrade":        true,
+	"list-upgrades":  true,
+	"preview":        true,
+	"list-installed": true,
+	"clean":          true,
+	"last-update":    true,
+	"depends":        true,
+	"required-by":    true,
+	"lock-status":    true,
+}
+
+// fakeErrors maps the names of errors in a FakeFailure to the kind of
+// CmdError they produce. "failed" produces one without a Kind.
+var fakeErrors = map[string]error{
+	"failed":     nil,
+	"not-found":  ErrNotFound,
+	"locked":     ErrLocked,
+	"permission": ErrPermission,
+	"network":    ErrNetwork,
+	"conflict":   ErrConflict,
+	"disk-full":  ErrDiskFull,
+}
+
+// LoadFakeCatalog reads a FakeCatalog from a JSON or YAML file, depending on
+// the file's extension.
+func LoadFakeCatalog(path string) (*FakeCatalog, error) {
+	var (
+		err error
+		raw []byte
+		cat = new(FakeCatalog)
+	)
+
+	if raw, err = os.ReadFile(path); err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(raw, cat)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, cat)
+	default:
+		return nil, fmt.Errorf("Cannot tell the format of %s, it should end in .json, .yaml or .yml", path)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse %s: %w", path, err)
+	} else if err = cat.validate(); err != nil {
+		return nil, fmt.Errorf("Invalid catalog %s: %w", path, err)
+	}
+
+	return cat, nil
+} // func LoadFakeCatalog(path string) (*FakeCatalog, error)
+
+func (cat *FakeCatalog) validate() error {
+	var names = make(map[string]bool, len(cat.Packages))
+
+	for _, p := range cat.Packages {
+		if p.Name == "" {
+			return fmt.Errorf("Package without a name")
+		} else if names[p.Name] {
+			return fmt.Errorf("Package %s is listed twice", p.Name)
+		} else if p.Version == "" && p.Installed == "" {
+			return fmt.Errorf("Package %s has no version", p.Name)
+		}
+		names[p.Name] = true
+	}
+
+	for _, p := range cat.Packages {
+		for _, d := range p.Depends {
+			if !names[d] {
+				return fmt.Errorf("Package %s depends on %s, which is not in the catalog",
+					p.Name,
+					d)
+			}
+		}
+	}
+
+	for _, f := range cat.Failures {
+		if _, ok := fakeErrors[f.Error]; !ok {
+			return fmt.Errorf("Unknown error %q", f.Error)
+		} else if f.Op != "" && !fakeOps[f.Op] {
+			return fmt.Errorf("Unknown operation %q", f.Op)
+		}
+	}
+
+	return nil
+} // func (cat *FakeCatalog) validate() error
+
+// PkgFake is a PkgManager that works on an in-memory catalog rather than a
+// real package database. It never runs any commands, so it works on any
+// system, without root privileges, which makes it useful for demos and tests.
+// Changes only last as long as the PkgFake.
+type PkgFake struct {
+	log  *log.Logger
+	db   *database.Database
+	lock sync.Mutex
+	cat  *FakeCatalog
+	pkgs map[string]*FakePackage
+}
+
+// CreatePkgFake creates a PkgFake that works on the catalog in the given
+// file, or on an empty one if path is empty.
+func CreatePkgFake(path string) (*PkgFake, error) {
+	var (
+		err error
+		cat = new(FakeCatalog)
+		pk  *PkgFake
+		lg  *log.Logger
+	)
+
+	if lg, err = common.GetLogger(logdomain.PkgManager); err != nil {
+		return nil, err
+	} else if path != "" {
+		if cat, err = LoadFakeCatalog(path); err != nil {
+			lg.Printf("[ERROR] Cannot load catalog: %s\n", err.Error())
+			return nil, err
+		}
+	}
+
+	pk = NewPkgFake(lg, cat)
+
+	if pk.db, err = database.OpenDB(common.DbPath); err != nil {
+		pk.log.Printf("[ERROR] Cannot open database at %s: %s\n",
+			common.DbPath,
+			err.Error())
+		return nil, err
+	}
+
+	return pk, nil
+} // func CreatePkgFake(path string) (*PkgFake, error)
+
+// NewPkgFake creates a PkgFake that works on the given catalog, without a
+// database. The PkgFake takes ownership of the catalog.
+func NewPkgFake(lg *log.Logger, cat *FakeCatalog) *PkgFake {
+	var pk = &PkgFake{
+		log:  lg,
+		cat:  cat,
+		pkgs: make(map[string]*FakePackage, len(cat.Packages)),
+	}
+
+	for _, p := range cat.Packages {
+		pk.pkgs[p.Name] = p
+	}
+
+	return pk
+} // func NewPkgFake(lg *log.Logger, cat *FakeCatalog) *PkgFake
+
+// historyDB returns the connection to the history database.
+func (pk *PkgFake) historyDB() *database.Database {
+	return pk.db
+} // func (pk *PkgFake) historyDB() *database.Database
+
+// fail returns the error the catalog's failures call for when performing the
+// given operation on the given packages, if any. The caller must hold the
+// lock.
+func (pk *PkgFake) fail(op string, pkgs ...string) error {
+	for _, f := range pk.cat.Failures {
+		if f.Op != "" && f.Op != op {
+			continue
+		} else if f.Package != "" && !common.Contains(pkgs, f.Package) {
+			continue
+		} else if f.Count < 0 {
+			// This one is used up.
+			continue
+		}
+
+		if f.Count > 0 {
+			if f.Count--; f.Count == 0 {
+				f.Count = -1
+			}
+		}
+
+		var msg = f.Message
+
+		if msg == "" {
+			msg = fmt.Sprintf("%s: %s", op, f.Error)
+		}
+
+		pk.log.Printf("[DEBUG] Failing %s %s: %s\n",
+			op,
+			strings.Join(pkgs, " "),
+			msg)
+
+		return &CmdError{
+			Kind:     fakeErrors[f.Error],
+			Cmd:      cmdFake,
+			Args:     append([]string{op}, pkgs...),
+			ExitCode: 1,
+			Stderr:   msg,
+		}
+	}
+
+	return nil
+} // func (pk *PkgFake) fail(op string, pkgs ...string) error
+
+// notFound returns the error for a package that is not in the catalog.
+func notFound(op, name string) error {
+	return &CmdError{
+		Kind:     ErrNotFound,
+		Cmd:      cmdFake,
+		Args:     []string{op, name},
+		ExitCode: 1,
+		Stderr:   fmt.Sprintf("Unable to locate package %s", name),
+	}
+} // func notFound(op, name string) error
+
+// sortedPackages returns the packages in the catalog for which the filter
+// returns true, sorted by name. The caller must hold the lock.
+func (pk *PkgFake) sortedPackages(filter func(*FakePackage) bool) []*FakePackage {
+	var list = make([]*FakePackage, 0, len(pk.pkgs))
+
+	for _, p := range pk.pkgs {
+		if filter(p) {
+			list = append(list, p)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list
+} // func (pk *PkgFake) sortedPackages(filter func(*FakePackage) bool) []*FakePackage
+
+// hasUpgrade returns true if a newer version of an installed package is
+// available.
+func (p *FakePackage) hasUpgrade() bool {
+	return p.Installed != "" && p.Version != "" && compareVersions(p.Installed, p.Version) < 0
+} // func (p *FakePackage) hasUpgrade() bool
+
+func (pk *PkgFake) Search(ctx context.Context, query string) ([]Package, error) {
+	pk.lock.Lock()
+	defer pk.lock.Unlock()
+
+	if err := pk.fail("search", query); err != nil {
+		return nil, err
+	}
+
+	var (
+		pkList []Package
+		q      = strings.ToLower(query)
+	)
+
+	for _, p := range pk.sortedPackages(func(p *FakePackage) bool {
+		return p.Version != "" &&
+			(strings.Contains(strings.ToLower(p.Name), q) ||
+				strings.Contains(strings.ToLower(p.Description), q))
+	}) {
+		pkList = append(pkList, Package{
+			Name:        p.Name,
+			Version:     p.Version,
+			Description: p.Description,
+		})
+	}
+
+	return pkList, nil
+} // func (pk *PkgFake) Search(ctx context.Context, query string) ([]Package, error)
+
+func (pk *PkgFake) Info(ctx context.Context, name string) (*PackageInfo, error) {
+	pk.lock.Lock()
+	defer pk.lock.Unlock()
+
+	if err := pk.fail("info", name); err != nil {
+		return nil, err
+	}
+
+	var p, ok = pk.pkgs[name]
+
+	if !ok {
+		return nil, notFound("info", name)
+	}
+
+	var info = &PackageInfo{
+		Package: Package{
+			Name:        p.Name,
+			Version:     p.Version,
+			Description: p.Description,
+		},
+		Installed:  p.Installed != "",
+		Repository: p.Repository,
+		URL:        p.URL,
+		License:    p.License,
+	}
+
+	if p.Installed != "" {
+		info.Version = p.Installed
+	}
+
+	if p.Size != 0 {
+		info.Size = newSize(p.Size)
+	}
+
+	return info, nil
+} // func (pk *PkgFake) Info(ctx context.Context, name string) (*PackageInfo, error)
+
+func (pk *PkgFake) Install(ctx context.Context, args ...string) error {
+	return pk.transaction(ctx, "install", event.Add, args)
+} // func (pk *PkgFake) Install(ctx context.Context, args ...string) error
+
+func (pk *PkgFake) Remove(ctx context.Context, args ...string) error {
+	return pk.transaction(ctx, "remove", event.Delete, args)
+} // func (pk *PkgFake) Remove(ctx context.Context, args ...string) error
+
+func (pk *PkgFake) Update(ctx context.Context) error {
+	pk.lock.Lock()
+	defer pk.lock.Unlock()
+
+	logCommand(ctx, &Command{Path: cmdFake, Args: []string{"update"}})
+
+	if err := pk.fail("update"); err != nil {
+		return err
+	}
+
+	pk.cat.LastUpdate = time.Now()
+
+	return nil
+} // func (pk *PkgFake) Update(ctx context.Context) error
+
+func (pk *PkgFake) Upgrade(ctx context.Context, securityOnly bool) error {
+	pk.lock.Lock()
+	defer pk.lock.Unlock()
+
+	logCommand(ctx, &Command{Path: cmdFake, Args: []string{"upgrade"}})
+
+	if err := pk.fail("upgrade"); err != nil {
+		return err
+	} else if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	var (
+		exclude = excludePatterns(ctx, event.Update)
+		upList  = pk.sortedPackages(func(p *FakePackage) bool {
+			return p.hasUpgrade() &&
+				(!securityOnly || len(p.Advisories) > 0) &&
+				!isExcluded(exclude, p.Name)
+		})
+	)
+
+	for i, p := range upList {
+		p.Installed = p.Version
+		reportFake(ctx, action.Upgrade, p.Name, i+1, len(upList))
+	}
+
+	return nil
+} // func (pk *PkgFake) Upgrade(ctx context.Context, securityOnly bool) error
+
+func (pk *PkgFake) ListUpgrades(ctx context.Context, securityOnly bool) ([]PendingUpgrade, error) {
+	pk.lock.Lock()
+	defer pk.lock.Unlock()
+
+	if err := pk.fail("list-upgrades"); err != nil {
+		return nil, err
+	}
+
+	var upList []PendingUpgrade
+
+	for _, p := range pk.sortedPackages(func(p *FakePackage) bool {
+		return p.hasUpgrade() && (!securityOnly || len(p.Advisories) > 0)
+	}) {
+		upList = append(upList, PendingUpgrade{
+			Name:       p.Name,
+			Installed:  p.Installed,
+			Candidate:  p.Version,
+			Advisories: p.Advisories,
+		})
+	}
+
+	return upList, nil
+} // func (pk *PkgFake) ListUpgrades(ctx context.Context, securityOnly bool) ([]PendingUpgrade, error)
+
+func (pk *PkgFake) Preview(ctx context.Context, op event.ID, pkgs ...string) (*Plan, error) {
+	pk.lock.Lock()
+	defer pk.lock.Unlock()
+
+	if err := pk.fail("preview", pkgs...); err != nil {
+		return nil, err
+	}
+
+	return pk.plan(op, pkgs, excludePatterns(ctx, op))
+} // func (pk *PkgFake) Preview(ctx context.Context, op event.ID, pkgs ...string) (*Plan, error)
+
+// plan works out what the given operation does to the catalog. Upgrades skip
+// the packages that match one of the exclude patterns. The caller must hold
+// the lock.
+func (pk *PkgFake) plan(op event.ID, pkgs, exclude []string) (*Plan, error) {
+	var (
+		plan = newPlan(op)
+		seen = make(map[string]bool)
+	)
+
+	for _, name := range pkgs {
+		if _, ok := pk.pkgs[name]; !ok {
+			return nil, notFound(op.String(), name)
+		}
+	}
+
+	switch op {
+	case event.Add:
+		var install func(name string)
+
+		// Dependencies come first, as they would be installed first.
+		install = func(name string) {
+			var p = pk.pkgs[name]
+
+			if seen[name] {
+				return
+			}
+			seen[name] = true
+
+			for _, d := range p.Depends {
+				install(d)
+			}
+
+			if p.Installed == "" && p.Version != "" {
+				plan.Changes = append(plan.Changes, Change{
+					Name:       p.Name,
+					Action:     action.Install,
+					NewVersion: p.Version,
+				})
+			}
+		}
+
+		for _, name := range pkgs {
+			if pk.pkgs[name].Version == "" {
+				return nil, notFound(op.String(), name)
+			}
+			install(name)
+		}
+	case event.Delete:
+		var remove func(name string)
+
+		// Packages that depend on a removed package go, too.
+		remove = func(name string) {
+			if seen[name] {
+				return
+			}
+			seen[name] = true
+
+			for _, p := range pk.sortedPackages(func(p *FakePackage) bool {
+				return p.Installed != "" && common.Contains(p.Depends, name)
+			}) {
+				remove(p.Name)
+			}
+
+			if p := pk.pkgs[name]; p.Installed != "" {
+				plan.Changes = append(plan.Changes, Change{
+					Name:       p.Name,
+					Action:     action.Remove,
+					OldVersion: p.Installed,
+				})
+			}
+		}
+
+		for _, name := range pkgs {
+			remove(name)
+		}
+	case event.Update:
+		for _, p := range pk.sortedPackages(func(p *FakePackage) bool {
+			return p.hasUpgrade() &&
+				(len(pkgs) == 0 || common.Contains(pkgs, p.Name)) &&
+				!isExcluded(exclude, p.Name)
+		}) {
+			plan.Changes = append(plan.Changes, Change{
+				Name:       p.Name,
+				Action:     action.Upgrade,
+				OldVersion: p.Installed,
+				NewVersion: p.Version,
+			})
+		}
+	default:
+		return nil, errUnsupportedOp(op)
+	}
+
+	var download, disk int64
+
+	for _, c := range plan.Changes {
+		var size = pk.pkgs[c.Name].Size
+
+		switch c.Action {
+		case action.Install:
+			download += size
+			disk += size
+		case action.Upgrade:
+			download += size
+		case action.Remove:
+			disk -= size
+		}
+	}
+
+	plan.DownloadSize, plan.DiskDelta = &download, &disk
+
+	return plan, nil
+} // func (pk *PkgFake) plan(op event.ID, pkgs []string) (*Plan, error)
+
+// transaction performs the given operation on the given packages.
+func (pk *PkgFake) transaction(ctx context.Context, name string, op event.ID, pkgs []string) error {
+	var (
+		err  error
+		plan *Plan
+	)
+
+	pk.lock.Lock()
+	defer pk.lock.Unlock()
+
+	logCommand(ctx, &Command{Path: cmdFake, Args: append([]string{name}, pkgs...)})
+
+	if err = pk.fail(name, pkgs...); err != nil {
+		return err
+	} else if err = ctx.Err(); err != nil {
+		return err
+	} else if plan, err = pk.plan(op, pkgs, excludePatterns(ctx, op)); err != nil {
+		pk.log.Printf("[ERROR] Cannot %s %s: %s\n",
+			name,
+			strings.Join(pkgs, " "),
+			err.Error())
+		return err
+	}
+
+	for i, c := range plan.Changes {
+		pk.pkgs[c.Name].Installed = c.NewVersion
+		reportFake(ctx, c.Action, c.Name, i+1, len(plan.Changes))
+	}
+
+	return nil
+} // func (pk *PkgFake) transaction(ctx context.Context, name string, op event.ID, pkgs []string) error
+
+var fakeStages = map[action.ID]stage.ID{
+	action.Install:   stage.Install,
+	action.Upgrade:   stage.Upgrade,
+	action.Downgrade: stage.Downgrade,
+	action.Remove:    stage.Remove,
+	action.Reinstall: stage.Reinstall,
+}
+
+// reportFake reports the progress of a transaction to the ProgressFunc
+// attached to the Context, if there is one, and adds it to the output of
+// the operation, as if a package manager had printed it.
+func reportFake(ctx context.Context, a action.ID, name string, done, total int) {
+	var (
+		fn   = progressFunc(ctx)
+		line = fmt.Sprintf("(%d/%d) %s %s", done, total, a, name)
+	)
+
+	logOutput(ctx, line, "\n")
+
+	if fn == nil {
+		return
+	}
+
+	fn(&Progress{
+		Time:    time.Now(),
+		Stage:   fakeStages[a],
+		Package: name,
+		Done:    done,
+		Total:   total,
+		Line:    line,
+	})
+} // func reportFake(ctx context.Context, a action.ID, name string, done, total int)
+
+func (pk *PkgFake) ListInstalled(ctx context.Context) ([]Package, error) {
+	pk.lock.Lock()
+	defer pk.lock.Unlock()
+
+	if err := pk.fail("list-installed"); err != nil {
+		return nil, err
+	}
+
+	var pkList []Package
+
+	for _, p := range pk.sortedPackages(func(p *FakePackage) bool { return p.Installed != "" }) {
+		pkList = append(pkList, Package{
+			Name:        p.Name,
+			Version:     p.Installed,
+			Description: p.Description,
+		})
+	}
+
+	return pkList, nil
+} // func (pk *PkgFake) ListInstalled(ctx context.Context) ([]Package, error)
+
+func (pk *PkgFake) Clean(ctx context.Context) error {
+	pk.lock.Lock()
+	defer pk.lock.Unlock()
+
+	logCommand(ctx, &Command{Path: cmdFake, Args: []string{"clean"}})
+
+	return pk.fail("clean")
+} // func (pk *PkgFake) Clean(ctx context.Context) error
+
+func (pk *PkgFake) LastUpdate(ctx context.Context) (time.Time, error) {
+	pk.lock.Lock()
+	defer pk.lock.Unlock()
+
+	if err := pk.fail("last-update"); err != nil {
+		return time.Time{}, err
+	}
+
+	return pk.cat.LastUpdate, nil
+} // func (pk *PkgFake) LastUpdate(ctx context.Context) (time.Time, error)
+
+func (pk *PkgFake) Depends(ctx context.Context, name string) ([]string, error) {
+	pk.lock.Lock()
+	defer pk.lock.Unlock()
+
+	if err := pk.fail("depends", name); err != nil {
+		return nil, err
+	}
+
+	var p, ok = pk.pkgs[name]
+
+	if !ok {
+		return nil, notFound("depends", name)
+	}
+
+	return append([]string{}, p.Depends...), nil
+} // func (pk *PkgFake) Depends(ctx context.Context, name string) ([]string, error)
+
+func (pk *PkgFake) RequiredBy(ctx context.Context, name string) ([]string, error) {
+	pk.lock.Lock()
+	defer pk.lock.Unlock()
+
+	if err := pk.fail("required-by", name); err != nil {
+		return nil, err
+	} else if _, ok := pk.pkgs[name]; !ok {
+		return nil, notFound("required-by", name)
+	}
+
+	var names []string
+
+	for _, p := range pk.sortedPackages(func(p *FakePackage) bool {
+		return p.Installed != "" && common.Contains(p.Depends, name)
+	}) {
+		names = append(names, p.Name)
+	}
+
+	return names, nil
+} // func (pk *PkgFake) RequiredBy(ctx context.Context, name string) ([]string, error)
+
+func (pk *PkgFake) LockStatus(ctx context.Context) (*LockInfo, error) {
+	pk.lock.Lock()
+	defer pk.lock.Unlock()
+
+	if err := pk.fail("lock-status"); err != nil {
+		return nil, err
+	}
+
+	return pk.cat.Lock, nil
+} // func (pk *PkgFake) LockStatus(ctx context.Context) (*LockInfo, error)
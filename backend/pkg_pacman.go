@@ -7,29 +7,33 @@
 package backend
 
 import (
-	"bytes"
-	"io"
+	"context"
 	"log"
-	"os/exec"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/blicero/krylib"
+	"github.com/blicero/pkman/backend/stage"
 	"github.com/blicero/pkman/common"
 	"github.com/blicero/pkman/database"
+	"github.com/blicero/pkman/database/action"
+	"github.com/blicero/pkman/database/event"
 	"github.com/blicero/pkman/logdomain"
 )
 
-const cmdPacman = "/usr/bin/pacman"
+const cmdPacman = "pacman"
 
 type PkgPacman struct {
 	log *log.Logger
 	db  *database.Database
+	run Runner
 }
 
 // CreatePkgPacman creates a PkgPacman instance to interface with the pacman
 // package manager used by RHEL, Fedora, and related systems.
-func CreatePkgPacman() (*PkgPacman, error) {
+func CreatePkgPacman(r Runner) (*PkgPacman, error) {
 	var (
 		err error
 		pk  = new(PkgPacman)
@@ -44,8 +48,17 @@ func CreatePkgPacman() (*PkgPacman, error) {
 		return nil, err
 	}
 
+	if pk.run = r; pk.run == nil {
+		pk.run = NewExecRunner(pk.log)
+	}
+
 	return pk, nil
-} // func CreatePkgPacman() (*PkgPacman, error)
+} // func CreatePkgPacman(r Runner) (*PkgPacman, error)
+
+// historyDB returns the connection to the history database.
+func (pk *PkgPacman) historyDB() *database.Database {
+	return pk.db
+} // func (pk *PkgPacman) historyDB() *database.Database
 
 /*
 Output of pacman -Syu emacs
@@ -82,89 +95,415 @@ community/wanderlust 20221010-1
     Mail/News reader supporting IMAP4rev1 for emacs.
 */
 
-var patSearchPacman = regexp.MustCompile(`(?im)^[^/]+/(\S+) ([^\n]+)\s*\n\s+([^\n]+)$`)
+// The version may be followed by the groups the package belongs to and
+// whether it is installed, the description is on the following line.
+var patSearchPacman = regexp.MustCompile(`(?m)^[^/\s]+/(\S+) (\S+)[^\n]*\n[ \t]+([^\n]*?)\s*$`)
 
-func (pk *PkgPacman) Search(query string) ([]Package, error) {
+func (pk *PkgPacman) Search(ctx context.Context, query string) ([]Package, error) {
 	var (
-		err              error
-		cmd              *exec.Cmd
-		pipeOut, pipeErr io.ReadCloser
-		bufOut, bufErr   bytes.Buffer
+		err    error
+		output string
 	)
 
-	cmd = exec.Command(cmdPacman, "-Ss", query)
-
-	if pipeOut, err = cmd.StdoutPipe(); err != nil {
-		pk.log.Printf("[ERROR] Cannot get stdout pipe from Cmd: %s\n",
-			err.Error())
-		return nil, err
-	} else if pipeErr, err = cmd.StderrPipe(); err != nil {
-		pk.log.Printf("[ERROR] Cannot get stderr pipe from Cmd: %s\n",
+	if output, _, err = runCommand(ctx, pk.run, cmdPacman, "-Ss", query); searchFailed(err) {
+		pk.log.Printf("[ERROR] Failed to search for %q: %s\n",
+			query,
 			err.Error())
 		return nil, err
 	}
 
-	if err = cmd.Start(); err != nil {
-		pk.log.Printf("[ERROR] Error starting command: %s\n",
-			err.Error())
-		return nil, err
+	var matches = patSearchPacman.FindAllStringSubmatch(output, -1)
+
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	var pkList = make([]Package, len(matches))
+
+	for i, m := range matches {
+		pkList[i] = Package{
+			Name:        m[1],
+			Version:     m[2],
+			Description: m[3],
+		}
 	}
 
-	io.Copy(&bufOut, pipeOut) // nolint: errcheck
-	io.Copy(&bufErr, pipeErr) // nolint: errcheck
+	return pkList, nil
+} // func (pk *PkgPacman) Search(ctx context.Context, query string) ([]Package, error)
+
+/*
+Output of pacman -Qi emacs (excerpt)
+Name            : emacs
+Version         : 28.2-2
+Description     : The extensible, customizable, self-documenting real-time display editor
+Architecture    : x86_64
+URL             : https://www.gnu.org/software/emacs/emacs.html
+Licenses        : GPL3
+Installed Size  : 137.81 MiB
+
+pacman -Si, for packages that are not installed, additionally prints the
+Repository.
+*/
+
+func (pk *PkgPacman) Info(ctx context.Context, name string) (*PackageInfo, error) {
+	var (
+		err       error
+		output    string
+		values    map[string]string
+		installed = true
+	)
 
-	if err = cmd.Wait(); err != nil {
-		if _, ok := err.(*exec.ExitError); !ok {
-			// FIXME Do something with stderr output!
-			pk.log.Printf("[ERROR] Failed to wait for command: %s\n",
+	// pacman -Qi fails if the package is not installed, in which case we
+	// ask the sync database.
+	if output, _, err = runCommand(ctx, pk.run, cmdPacman, "-Qi", name); err != nil {
+		installed = false
+		if output, _, err = runCommand(ctx, pk.run, cmdPacman, "-Si", name); err != nil {
+			pk.log.Printf("[ERROR] Cannot get info on %s: %s\n",
+				name,
 				err.Error())
 			return nil, err
 		}
 	}
 
-	var matches = patSearchPacman.FindAllStringSubmatch(bufOut.String(), -1)
+	values = parseKeyValues(output)
 
-	if len(matches) == 0 {
-		return nil, nil
+	var info = &PackageInfo{
+		Package: Package{
+			Name:        values["Name"],
+			Version:     values["Version"],
+			Description: values["Description"],
+		},
+		Installed:  installed,
+		Repository: values["Repository"],
+		URL:        values["URL"],
+		License:    values["Licenses"],
+		Size:       parseSize(values["Installed Size"]),
 	}
 
-	var pkList = make([]Package, len(matches))
+	return info, nil
+} // func (pk *PkgPacman) Info(ctx context.Context, name string) (*PackageInfo, error)
+
+func (pk *PkgPacman) Install(ctx context.Context, args ...string) error {
+	return pk.transaction(ctx, event.Add, args)
+} // func (pk *PkgPacman) Install(ctx context.Context, args ...string) error
+
+func (pk *PkgPacman) Remove(ctx context.Context, args ...string) error {
+	return pk.transaction(ctx, event.Delete, args)
+} // func (pk *PkgPacman) Remove(ctx context.Context, args ...string) error
+
+func (pk *PkgPacman) Update(ctx context.Context) error {
+	return runTransaction(ctx, pk.run, nil, cmdPacman, "-Sy")
+} // func (pk *PkgPacman) Update(ctx context.Context) error
+
+func (pk *PkgPacman) Upgrade(ctx context.Context, securityOnly bool) error {
+	if securityOnly {
+		return ErrUnsupported
+	}
+
+	return pk.transaction(ctx, event.Update, nil)
+} // func (pk *PkgPacman) Upgrade(ctx context.Context, securityOnly bool) error
+
+/*
+Output of pacman -Qu
+
+bash 5.1.016-1 -> 5.1.016-3
+glibc 2.37-2 -> 2.37-3
+*/
+
+var patUpgradePacman = regexp.MustCompile(`(?m)^(\S+) (\S+) -> (\S+)`)
+
+func (pk *PkgPacman) ListUpgrades(ctx context.Context, securityOnly bool) ([]PendingUpgrade, error) {
+	var (
+		err    error
+		output string
+	)
+
+	// pacman has no notion of security updates.
+	if securityOnly {
+		return nil, ErrUnsupported
+	}
+
+	// pacman -Qu exits with a non-zero status if there is nothing to
+	// upgrade.
+	if output, _, err = runCommand(ctx, pk.run, cmdPacman, "-Qu"); err != nil {
+		if exitCode(err) == -1 || output != "" {
+			pk.log.Printf("[ERROR] Cannot list available upgrades: %s\n",
+				err.Error())
+			return nil, err
+		}
+	}
+
+	var (
+		matches = patUpgradePacman.FindAllStringSubmatch(output, -1)
+		upList  = make([]PendingUpgrade, len(matches))
+	)
+
+	for i, m := range matches {
+		upList[i] = PendingUpgrade{
+			Name:      m[1],
+			Installed: m[2],
+			Candidate: m[3],
+		}
+	}
+
+	return upList, nil
+} // func (pk *PkgPacman) ListUpgrades(ctx context.Context, securityOnly bool) ([]PendingUpgrade, error)
+
+/*
+Output of pacman -Q (excerpt)
+acl 2.3.1-3
+archlinux-keyring 20230504-1
+attr 2.5.1-3
+audit 3.1.1-1
+*/
+
+var patListPacman = regexp.MustCompile(`(?m)^(\S+) (\S+)$`)
+
+func (pk *PkgPacman) ListInstalled(ctx context.Context) ([]Package, error) {
+	var (
+		err    error
+		output string
+	)
+
+	if output, _, err = runCommand(ctx, pk.run, cmdPacman, "-Q"); err != nil {
+		pk.log.Printf("[ERROR] Cannot list installed packages: %s\n",
+			err.Error())
+		return nil, err
+	}
+
+	var (
+		matches = patListPacman.FindAllStringSubmatch(output, -1)
+		pkList  = make([]Package, len(matches))
+	)
 
 	for i, m := range matches {
 		pkList[i] = Package{
-			Name:        m[1],
-			Version:     m[2],
-			Description: m[3],
+			Name:    m[1],
+			Version: m[2],
 		}
 	}
 
 	return pkList, nil
-} // func (pk *PkgPacman) Search(string) ([]Package, error)
+} // func (pkg *PkgPacman) ListInstalled(ctx context.Context) ([]Package, error)
+
+func (pk *PkgPacman) Clean(ctx context.Context) error {
+	return runTransaction(ctx, pk.run, nil, cmdPacman, "-Sc", "--noconfirm")
+} // func (pk *PkgPacman) Clean(ctx context.Context) error
+
+func (pkg *PkgPacman) LastUpdate(ctx context.Context) (time.Time, error) {
+	return time.Unix(0, 0), krylib.ErrNotImplemented
+} // func (pkg *PkgPacman) LastUpdate(ctx context.Context) (time.Time, error)
 
-func (pk *PkgPacman) Install(args ...string) error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgPacman) Install(args ...string) error
+// LockStatus checks for pacman's lock file. pacman does not record who
+// holds the lock, and a crashed pacman leaves the file behind, so this may
+// report a lock no one holds.
+func (pk *PkgPacman) LockStatus(ctx context.Context) (*LockInfo, error) {
+	return firstLock(existsLock("/var/lib/pacman/db.lck")), nil
+} // func (pk *PkgPacman) LockStatus(ctx context.Context) (*LockInfo, error)
 
-func (pk *PkgPacman) Remove(args ...string) error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgPacman) Remove(args ...string) error
+/*
+Output of pactree -u -d1 emacs
 
-func (pk *PkgPacman) Update() error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgPacman) Update() error
+emacs
+gnutls
+jansson
+libjpeg-turbo
+libgccjit
+*/
 
-func (pk *PkgPacman) Upgrade() error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgPacman) Upgrade() error
+const cmdPactree = "pactree"
 
-func (pk *PkgPacman) ListInstalled() ([]Package, error) {
-	return nil, krylib.ErrNotImplemented
-} // func (pkg *PkgPacman) ListInstalled() ([]Package, error)
+// pactree prints the package we asked about first, so we drop it from the
+// results.
+func (pk *PkgPacman) pactree(ctx context.Context, name string, args ...string) ([]string, error) {
+	var (
+		err    error
+		output string
+		names  []string
+	)
 
-func (pk *PkgPacman) Clean() error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgPacman) Clean() error
+	args = append(args, "-u", "-d1", name)
 
-func (pkg *PkgPacman) LastUpdate() (time.Time, error) {
-	return time.Unix(0, 0), krylib.ErrNotImplemented
-} // func (pkg *PkgPacman) LastUpdate() (time.Time, error)
+	if output, _, err = runCommand(ctx, pk.run, cmdPactree, args...); err != nil {
+		pk.log.Printf("[ERROR] Failed to run pactree on %s: %s\n",
+			name,
+			err.Error())
+		return nil, err
+	}
+
+	for _, n := range uniqueLines(output) {
+		if n != name {
+			names = append(names, n)
+		}
+	}
+
+	return names, nil
+} // func (pk *PkgPacman) pactree(ctx context.Context, name string, args ...string) ([]string, error)
+
+func (pk *PkgPacman) Depends(ctx context.Context, name string) ([]string, error) {
+	return pk.pactree(ctx, name)
+} // func (pk *PkgPacman) Depends(ctx context.Context, name string) ([]string, error)
+
+func (pk *PkgPacman) RequiredBy(ctx context.Context, name string) ([]string, error) {
+	return pk.pactree(ctx, name, "-r")
+} // func (pk *PkgPacman) RequiredBy(ctx context.Context, name string) ([]string, error)
+
+// pacmanArgs returns the arguments to pacman for the given operation on the
+// given packages.
+func pacmanArgs(op event.ID, pkgs []string) ([]string, error) {
+	switch op {
+	case event.Add:
+		return append([]string{"-S"}, pkgs...), nil
+	case event.Delete:
+		return append([]string{"-R"}, pkgs...), nil
+	case event.Update:
+		if len(pkgs) == 0 {
+			return []string{"-Su"}, nil
+		}
+		return append([]string{"-S"}, pkgs...), nil
+	default:
+		return nil, errUnsupportedOp(op)
+	}
+} // func pacmanArgs(op event.ID, pkgs []string) ([]string, error)
+
+// pacmanExclude returns the options that make pacman skip the packages that
+// are excluded for op.
+func pacmanExclude(ctx context.Context, op event.ID) []string {
+	var patterns = excludePatterns(ctx, op)
+
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	return []string{"--ignore", strings.Join(patterns, ",")}
+} // func pacmanExclude(ctx context.Context, op event.ID) []string
+
+// transaction performs the given operation on the given packages.
+func (pk *PkgPacman) transaction(ctx context.Context, op event.ID, pkgs []string) error {
+	var (
+		err  error
+		args []string
+	)
+
+	if args, err = pacmanArgs(op, pkgs); err != nil {
+		return err
+	}
+
+	args = append(append(args, pacmanExclude(ctx, op)...), "--noconfirm")
+
+	return runTransaction(ctx, pk.run, nil, cmdPacman, args...)
+} // func (pk *PkgPacman) transaction(ctx context.Context, op event.ID, pkgs []string) error
+
+/*
+Output of pacman -S emacs --noconfirm (excerpt)
+ emacs-29.1-3-x86_64 downloading...
+(1/2) installing libotf                              [######################] 100%
+(2/2) installing emacs                               [######################] 100%
+
+Without a terminal, pacman leaves out the counts and the progress bars:
+installing emacs...
+*/
+
+var (
+	patProgressDownloadPacman = regexp.MustCompile(`^\s*(\S+)-[^-\s]+-[^-\s]+-[^-\s]+ downloading\.\.\.`)
+	patProgressStepPacman     = regexp.MustCompile(`^(?:\((\d+)/(\d+)\) )?(installing|upgrading|downgrading|reinstalling|removing) (\S+?)(?:\.\.\.)?(?:\s|$)`)
+)
+
+var progressStagesPacman = map[string]stage.ID{
+	"installing":   stage.Install,
+	"upgrading":    stage.Upgrade,
+	"downgrading":  stage.Downgrade,
+	"reinstalling": stage.Reinstall,
+	"removing":     stage.Remove,
+}
+
+// parseProgressPacman makes sense of a line of pacman's output.
+func parseProgressPacman(line string) *Progress {
+	var m []string
+
+	if m = patProgressDownloadPacman.FindStringSubmatch(line); m != nil {
+		return &Progress{Stage: stage.Download, Package: m[1]}
+	} else if m = patProgressStepPacman.FindStringSubmatch(line); m == nil {
+		return nil
+	}
+
+	var p = &Progress{Stage: progressStagesPacman[m[3]], Package: m[4]}
+
+	if m[1] != "" {
+		p.Done, _ = strconv.Atoi(m[1])
+		p.Total, _ = strconv.Atoi(m[2])
+	}
+
+	return p
+} // func parseProgressPacman(line string) *Progress
+
+/*
+Output of pacman -S -p --print-format '%n %v %s' emacs
+(The size is the size of the package file in bytes)
+
+gnutls 3.8.0-1 2710847
+emacs 28.2-2 29745610
+*/
+
+var patPlanPacman = regexp.MustCompile(`(?m)^(\S+) (\S+)(?: (\d+))?$`)
+
+func (pk *PkgPacman) Preview(ctx context.Context, op event.ID, pkgs ...string) (*Plan, error) {
+	var (
+		err       error
+		args      []string
+		output    string
+		installed []Package
+		versions  = make(map[string]string)
+		plan      = newPlan(op)
+	)
+
+	if args, err = pacmanArgs(op, pkgs); err != nil {
+		return nil, err
+	} else if installed, err = pk.ListInstalled(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, p := range installed {
+		versions[p.Name] = p.Version
+	}
+
+	args = append(append(args, pacmanExclude(ctx, op)...), "-p", "--print-format", "%n %v %s")
+
+	if op == event.Delete {
+		args[len(args)-1] = "%n %v"
+	}
+
+	if output, _, err = runCommand(ctx, pk.run, cmdPacman, args...); err != nil {
+		pk.log.Printf("[ERROR] Failed to simulate %s: %s\n",
+			op,
+			err.Error())
+		return nil, err
+	}
+
+	for _, m := range patPlanPacman.FindAllStringSubmatch(output, -1) {
+		if op == event.Delete {
+			plan.Changes = append(plan.Changes, Change{
+				Name:       m[1],
+				Action:     action.Remove,
+				OldVersion: m[2],
+			})
+			continue
+		}
+
+		plan.Changes = append(plan.Changes, Change{
+			Name:       m[1],
+			Action:     versionChange(versions[m[1]], m[2]),
+			OldVersion: versions[m[1]],
+			NewVersion: m[2],
+		})
+
+		if size, perr := strconv.ParseInt(m[3], 10, 64); perr == nil {
+			if plan.DownloadSize == nil {
+				plan.DownloadSize = newSize(0)
+			}
+			*plan.DownloadSize += size
+		}
+	}
+
+	return plan, nil
+} // func (pk *PkgPacman) Preview(ctx context.Context, op event.ID, pkgs ...string) (*Plan, error)
@@ -7,29 +7,34 @@
 package backend
 
 import (
-	"bytes"
-	"io"
+	"context"
+	"fmt"
 	"log"
-	"os/exec"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/blicero/krylib"
+	"github.com/blicero/pkman/backend/stage"
 	"github.com/blicero/pkman/common"
 	"github.com/blicero/pkman/database"
+	"github.com/blicero/pkman/database/action"
+	"github.com/blicero/pkman/database/event"
 	"github.com/blicero/pkman/logdomain"
 )
 
-const cmdPkg = "/usr/sbin/pkg"
+const cmdPkg = "pkg"
 
 // PkgPkg implements the PkgManager interface for openSuse's pkger.
 type PkgPkg struct {
 	log *log.Logger
 	db  *database.Database
+	run Runner
 }
 
 // CreatePkgPkg creates a new instance of PkgPkg.
-func CreatePkgPkg() (*PkgPkg, error) {
+func CreatePkgPkg(r Runner) (*PkgPkg, error) {
 	var (
 		err error
 		pk  = new(PkgPkg)
@@ -44,108 +49,586 @@ func CreatePkgPkg() (*PkgPkg, error) {
 		return nil, err
 	}
 
+	if pk.run = r; pk.run == nil {
+		pk.run = NewExecRunner(pk.log)
+	}
+
 	return pk, nil
-} // func CreatePkgPkg() (*PkgPkg, error)
-
-/* Output of pkg search emacs:
-emacs-28.2_4,3                 GNU editing macros
-emacs-canna-28.2_4,3           GNU editing macros (Canna Japanese input flavor)
-emacs-devel-30.0.50.20230316,3 GNU editing macros
-emacs-devel-nox-30.0.50.20230316,3 GNU editing macros (No X flavor)
-emacs-koi8u-1.0_1              KOI8-U coding system for [X]Emacs
-emacs-lisp-intro-2.04_1        Introduction to Emacs Lisp programming
-emacs-nox-28.2_4,3             GNU editing macros (No X flavor)
-emacs-w3m-1.4.632.b.20221130   Simple front-end to w3m for emacs
-emacs-w3m-emacs_canna-1.4.632.b.20221130 Simple front-end to w3m for emacs
-emacs-w3m-emacs_devel-1.4.632.b.20221130 Simple front-end to w3m for emacs
-emacs-w3m-emacs_devel_nox-1.4.632.b.20221130 Simple front-end to w3m for emacs
-emacs-w3m-emacs_nox-1.4.632.b.20221130 Simple front-end to w3m for emacs
-emacsql-3.1.1_2                High-level Emacs Lisp RDBMS front-end
-emacsql-emacs_canna-3.1.1_2    High-level Emacs Lisp RDBMS front-end
-emacsql-emacs_devel-3.1.1_2    High-level Emacs Lisp RDBMS front-end
-emacsql-emacs_devel_nox-3.1.1_2 High-level Emacs Lisp RDBMS front-end
-emacsql-emacs_nox-3.1.1_2      High-level Emacs Lisp RDBMS front-end
+} // func CreatePkgPkg(r Runner) (*PkgPkg, error)
+
+// historyDB returns the connection to the history database.
+func (pk *PkgPkg) historyDB() *database.Database {
+	return pk.db
+} // func (pk *PkgPkg) historyDB() *database.Database
+
+/* Output of pkg rquery -x '%n\t%v\t%c' emacs (excerpt):
+pkg search prints name-version, which is ambiguous, so we ask for the fields
+one by one. A package that is available from several repositories is listed
+once for each.
+
+emacs	28.2_4,3	GNU editing macros
+emacs-canna	28.2_4,3	GNU editing macros (Canna Japanese input flavor)
+emacs-w3m-emacs_canna	1.4.632.b.20221130	Simple front-end to w3m for emacs
+*/
+
+const fmtPackagePkg = `%n\t%v\t%c`
+
+func (pk *PkgPkg) Search(ctx context.Context, query string) ([]Package, error) {
+	var (
+		err    error
+		output string
+		pkList []Package
+	)
+
+	// pkg rquery exits with status 1 if nothing matches.
+	if output, _, err = runCommand(ctx, pk.run, cmdPkg, "rquery", "-x", fmtPackagePkg, query); searchFailed(err) {
+		pk.log.Printf("[ERROR] Failed to search for %q: %s\n",
+			query,
+			err.Error())
+		return nil, err
+	}
+
+	for _, line := range uniqueLines(output) {
+		var fields = strings.SplitN(line, "\t", 3)
+
+		if len(fields) != 3 {
+			continue
+		}
+
+		pkList = append(pkList, Package{
+			Name:        fields[0],
+			Version:     fields[1],
+			Description: fields[2],
+		})
+	}
+
+	return pkList, nil
+} // func (pk *PkgPkg) Search(ctx context.Context, query string) ([]Package, error)
+
+// pkg query and pkg rquery let us specify the output format, so we do not
+// have to parse pkg info.
+const fmtInfoPkg = `%n\t%v\t%c\t%w\t%sb\t%R`
+
+func (pk *PkgPkg) Info(ctx context.Context, name string) (*PackageInfo, error) {
+	var (
+		err       error
+		output    string
+		fields    []string
+		installed = true
+	)
+
+	// pkg query fails if the package is not installed, in which case we
+	// ask the remote catalogue.
+	if output, _, err = runCommand(ctx, pk.run, cmdPkg, "query", fmtInfoPkg, name); err != nil {
+		installed = false
+		// Like pkg query, pkg rquery exits with status 1 and says nothing
+		// if the package does not exist.
+		if output, _, err = runCommand(ctx, pk.run, cmdPkg, "rquery", fmtInfoPkg, name); exitCode(err) == 1 && output == "" {
+			return nil, fmt.Errorf("%s: %w", name, ErrNotFound)
+		} else if err != nil {
+			pk.log.Printf("[ERROR] Cannot get info on %s: %s\n",
+				name,
+				err.Error())
+			return nil, err
+		}
+	}
+
+	if fields = strings.Split(firstLine(output), "\t"); len(fields) != 6 {
+		return nil, fmt.Errorf("Cannot parse output of pkg query: %q", output)
+	}
+
+	var info = &PackageInfo{
+		Package: Package{
+			Name:        fields[0],
+			Version:     fields[1],
+			Description: fields[2],
+		},
+		Installed:  installed,
+		URL:        fields[3],
+		Repository: fields[5],
+	}
+
+	if size, perr := strconv.ParseInt(fields[4], 10, 64); perr == nil {
+		info.Size = newSize(size)
+	}
+
+	return info, nil
+} // func (pk *PkgPkg) Info(ctx context.Context, name string) (*PackageInfo, error)
+
+func (pk *PkgPkg) Install(ctx context.Context, args ...string) error {
+	return pk.transaction(ctx, event.Add, args)
+} // func (pk *PkgPkg) Install(ctx context.Context, args ...string) error
+
+func (pk *PkgPkg) Remove(ctx context.Context, args ...string) error {
+	return pk.transaction(ctx, event.Delete, args)
+} // func (pk *PkgPkg) Remove(ctx context.Context, args ...string) error
+
+func (pk *PkgPkg) Update(ctx context.Context) error {
+	return runTransaction(ctx, pk.run, nil, cmdPkg, "update")
+} // func (pk *PkgPkg) Update(ctx context.Context) error
+
+func (pk *PkgPkg) Upgrade(ctx context.Context, securityOnly bool) error {
+	if !securityOnly {
+		return pk.transaction(ctx, event.Update, nil)
+	}
+
+	var (
+		err    error
+		upList []PendingUpgrade
+	)
+
+	if upList, err = pk.ListUpgrades(ctx, true); err != nil {
+		return err
+	}
+
+	// Held packages cannot be upgraded by name, so we leave the
+	// excluded ones out.
+	var names = notExcludedNames(ctx, event.Update, upgradeNames(upList))
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	return pk.transaction(ctx, event.Update, names)
+} // func (pk *PkgPkg) Upgrade(ctx context.Context, securityOnly bool) error
+
+/* Output of pkg version -vl'<':
+bash-5.2.15                        <   needs updating (remote has 5.2.15_1)
+curl-8.0.1                         <   needs updating (remote has 8.1.1)
 */
 
-var patSearchPkg = regexp.MustCompile(`(?mi)^(\S+)-(\d\S+)\s+([^\n]+)$`)
+var patUpgradePkg = regexp.MustCompile(`(?m)^(\S+)\s+<\s+needs updating \(\S+ has ([^)]+)\)`)
 
-func (pk *PkgPkg) Search(query string) ([]Package, error) {
+func (pk *PkgPkg) ListUpgrades(ctx context.Context, securityOnly bool) ([]PendingUpgrade, error) {
 	var (
-		err            error
-		cmd            *exec.Cmd
-		stdout, stderr io.ReadCloser
-		bufOut, bufErr bytes.Buffer
+		err    error
+		output string
 	)
 
-	cmd = exec.Command(cmdPkg, "search", query)
+	if securityOnly {
+		return pk.listSecurityUpgrades(ctx)
+	}
 
-	if stdout, err = cmd.StdoutPipe(); err != nil {
-		pk.log.Printf("[ERROR] Cannot get stdout pipe from Cmd: %s\n",
+	if output, _, err = runCommand(ctx, pk.run, cmdPkg, "version", "-vl<"); err != nil {
+		pk.log.Printf("[ERROR] Cannot list available upgrades: %s\n",
 			err.Error())
 		return nil, err
-	} else if stderr, err = cmd.StderrPipe(); err != nil {
-		pk.log.Printf("[ERROR] Cannot get stderr pipe from Cmd: %s\n",
+	}
+
+	var (
+		matches = patUpgradePkg.FindAllStringSubmatch(output, -1)
+		upList  = make([]PendingUpgrade, len(matches))
+	)
+
+	for i, m := range matches {
+		var name, version = splitNameVersion(m[1])
+
+		upList[i] = PendingUpgrade{
+			Name:      name,
+			Installed: version,
+			Candidate: m[2],
+		}
+	}
+
+	return upList, nil
+} // func (pk *PkgPkg) ListUpgrades(ctx context.Context, securityOnly bool) ([]PendingUpgrade, error)
+
+func (pk *PkgPkg) ListInstalled(ctx context.Context) ([]Package, error) {
+	var (
+		err    error
+		output string
+		pkList []Package
+	)
+
+	if output, _, err = runCommand(ctx, pk.run, cmdPkg, "query", fmtPackagePkg); err != nil {
+		pk.log.Printf("[ERROR] Cannot list installed packages: %s\n",
 			err.Error())
 		return nil, err
 	}
 
-	if err = cmd.Start(); err != nil {
-		pk.log.Printf("[ERROR] Error starting command: %s\n",
+	for _, line := range strings.Split(output, "\n") {
+		var fields = strings.SplitN(line, "\t", 3)
+
+		if len(fields) != 3 {
+			continue
+		}
+
+		pkList = append(pkList, Package{
+			Name:        fields[0],
+			Version:     fields[1],
+			Description: fields[2],
+		})
+	}
+
+	return pkList, nil
+} // func (pkg *PkgPkg) ListInstalled(ctx context.Context) ([]Package, error)
+
+func (pk *PkgPkg) Clean(ctx context.Context) error {
+	return runTransaction(ctx, pk.run, nil, cmdPkg, "clean", "-y")
+} // func (pk *PkgPkg) Clean(ctx context.Context) error
+
+func (pkg *PkgPkg) LastUpdate(ctx context.Context) (time.Time, error) {
+	return time.Unix(0, 0), krylib.ErrNotImplemented
+} // func (pkg *PkgPkg) LastUpdate(ctx context.Context) (time.Time, error)
+
+// LockStatus always reports the database as unlocked. pkg keeps its lock
+// inside its SQLite database, where we cannot see it without taking a lock
+// ourselves. If pkg fails because the database is locked, the error says so.
+func (pk *PkgPkg) LockStatus(ctx context.Context) (*LockInfo, error) {
+	return nil, nil
+} // func (pk *PkgPkg) LockStatus(ctx context.Context) (*LockInfo, error)
+
+/*
+Output of pkg info -dq emacs
+
+gnutls-3.7.8_1
+jansson-2.14
+libgccjit-12.2.0
+*/
+
+// pkgInfoNames runs pkg info with the given flag and returns the names of the
+// packages it lists, without their versions.
+func (pk *PkgPkg) pkgInfoNames(ctx context.Context, flag, name string) ([]string, error) {
+	var (
+		err    error
+		output string
+		lines  []string
+	)
+
+	if output, _, err = runCommand(ctx, pk.run, cmdPkg, "info", flag, name); err != nil {
+		pk.log.Printf("[ERROR] Failed to run pkg info %s %s: %s\n",
+			flag,
+			name,
 			err.Error())
 		return nil, err
 	}
 
-	io.Copy(&bufOut, stdout) // nolint: errcheck
-	io.Copy(&bufErr, stderr) // nolint: errcheck
+	lines = uniqueLines(output)
 
-	if err = cmd.Wait(); err != nil {
-		if _, ok := err.(*exec.ExitError); !ok {
-			pk.log.Printf("[ERROR] Failed to wait for command: %s\n",
+	for i, l := range lines {
+		lines[i], _ = splitNameVersion(l)
+	}
+
+	return lines, nil
+} // func (pk *PkgPkg) pkgInfoNames(ctx context.Context, flag, name string) ([]string, error)
+
+func (pk *PkgPkg) Depends(ctx context.Context, name string) ([]string, error) {
+	return pk.pkgInfoNames(ctx, "-dq", name)
+} // func (pk *PkgPkg) Depends(ctx context.Context, name string) ([]string, error)
+
+func (pk *PkgPkg) RequiredBy(ctx context.Context, name string) ([]string, error) {
+	return pk.pkgInfoNames(ctx, "-rq", name)
+} // func (pk *PkgPkg) RequiredBy(ctx context.Context, name string) ([]string, error)
+
+/* Output of pkg audit:
+curl-8.0.1 is vulnerable:
+  curl -- multiple vulnerabilities
+  CVE: CVE-2023-28322
+  CVE: CVE-2023-28321
+  WWW: https://vuxml.FreeBSD.org/freebsd/5e2e7f5c-fbd6-11ed-8c4a-8c164567ca3c.html
+
+1 problem(s) in 1 installed package(s) found.
+*/
+
+var (
+	patAuditPkgHead = regexp.MustCompile(`^(\S+) is vulnerable:$`)
+	patAuditPkgCVE  = regexp.MustCompile(`^\s+CVE: (\S+)$`)
+	patAuditPkgWWW  = regexp.MustCompile(`^\s+WWW: \S+/([^/]+)\.html$`)
+)
+
+// listSecurityUpgrades lists the pending upgrades for packages that pkg audit
+// reports as vulnerable.
+// Vulnerable packages for which no fixed version is available are skipped.
+func (pk *PkgPkg) listSecurityUpgrades(ctx context.Context) ([]PendingUpgrade, error) {
+	var (
+		err        error
+		output     string
+		upList     []PendingUpgrade
+		allUp      []PendingUpgrade
+		advisories = make(map[string][]string)
+		cur        string
+	)
+
+	// pkg audit exits with a non-zero status if it finds any vulnerable
+	// packages.
+	if output, _, err = runCommand(ctx, pk.run, cmdPkg, "audit"); err != nil {
+		if exitCode(err) == -1 {
+			pk.log.Printf("[ERROR] Cannot run pkg audit: %s\n",
 				err.Error())
 			return nil, err
 		}
 	}
 
+	for _, line := range strings.Split(output, "\n") {
+		var m []string
+
+		if m = patAuditPkgHead.FindStringSubmatch(line); m != nil {
+			cur, _ = splitNameVersion(m[1])
+			if _, ok := advisories[cur]; !ok {
+				advisories[cur] = nil
+			}
+		} else if cur == "" {
+			continue
+		} else if m = patAuditPkgCVE.FindStringSubmatch(line); m != nil {
+			advisories[cur] = append(advisories[cur], m[1])
+		} else if m = patAuditPkgWWW.FindStringSubmatch(line); m != nil {
+			advisories[cur] = append(advisories[cur], m[1])
+		}
+	}
+
+	if len(advisories) == 0 {
+		return nil, nil
+	} else if allUp, err = pk.ListUpgrades(ctx, false); err != nil {
+		return nil, err
+	}
+
+	for _, u := range allUp {
+		if adv, ok := advisories[u.Name]; ok {
+			u.Advisories = adv
+			upList = append(upList, u)
+		}
+	}
+
+	return upList, nil
+} // func (pk *PkgPkg) listSecurityUpgrades(ctx context.Context) ([]PendingUpgrade, error)
+
+// pkgArgs returns the arguments to pkg for the given operation on the given
+// packages.
+func pkgArgs(op event.ID, pkgs []string) ([]string, error) {
+	switch op {
+	case event.Add:
+		return append([]string{"install"}, pkgs...), nil
+	case event.Delete:
+		return append([]string{"delete"}, pkgs...), nil
+	case event.Update:
+		return append([]string{"upgrade"}, pkgs...), nil
+	default:
+		return nil, errUnsupportedOp(op)
+	}
+} // func pkgArgs(op event.ID, pkgs []string) ([]string, error)
+
+// transaction performs the given operation on the given packages.
+func (pk *PkgPkg) transaction(ctx context.Context, op event.ID, pkgs []string) error {
 	var (
-		matches = patSearchPkg.FindAllStringSubmatch(bufOut.String(), -1)
-		pkList  = make([]Package, len(matches))
+		err     error
+		args    []string
+		release func()
 	)
 
-	for i, m := range matches {
-		pkList[i] = Package{
-			Name:        m[1],
-			Version:     m[2],
-			Description: m[3],
+	if args, err = pkgArgs(op, pkgs); err != nil {
+		return err
+	}
+
+	// pkg wants the -y after the subcommand.
+	args = append([]string{args[0], "-y"}, args[1:]...)
+
+	if release, err = pk.holder().holdExcluded(ctx, op); err != nil {
+		return err
+	}
+
+	defer release()
+
+	return runTransaction(ctx, pk.run, nil, cmdPkg, args...)
+} // func (pk *PkgPkg) transaction(ctx context.Context, op event.ID, pkgs []string) error
+
+// holder keeps excluded packages out of a transaction by locking them, as
+// pkg has no way to skip packages otherwise.
+func (pk *PkgPkg) holder() *holder {
+	var lock = func(verb string) holdFunc {
+		return func(ctx context.Context, names []string) error {
+			var _, _, err = runPrivileged(ctx, pk.run, nil, cmdPkg,
+				append([]string{verb, "-y"}, names...)...)
+
+			if err != nil {
+				pk.log.Printf("[ERROR] Cannot %s %s: %s\n",
+					verb,
+					strings.Join(names, ", "),
+					err.Error())
+			}
+
+			return err
 		}
 	}
 
-	return pkList, nil
-} // func (pk *PkgPkg) Search(query string) ([]Package, error)
+	return &holder{
+		installed: pk.ListInstalled,
+		held: func(ctx context.Context) ([]string, error) {
+			var output, _, err = runCommand(ctx, pk.run, cmdPkg, "query", "-e", "%k = 1", "%n")
 
-func (pk *PkgPkg) Install(args ...string) error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgPkg) Install(args ...string) error
+			return strings.Fields(output), err
+		},
+		hold:    lock("lock"),
+		release: lock("unlock"),
+	}
+} // func (pk *PkgPkg) holder() *holder
 
-func (pk *PkgPkg) Remove(args ...string) error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgPkg) Remove(args ...string) error
+/*
+Output of pkg install -y emacs (excerpt)
+[1/2] Fetching emacs-28.2_4,3.pkg: .......... done
+[1/2] Installing emacs-28.2_4,3...
+[2/2] Upgrading bash from 5.2.15 to 5.2.15_1...
+[1/1] Deinstalling yasr-0.6.9_1...
+*/
 
-func (pk *PkgPkg) Update() error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgPkg) Update() error
+var patProgressPkg = regexp.MustCompile(`^\[(\d+)/(\d+)\] (Fetching|Installing|Upgrading|Downgrading|Reinstalling|Deinstalling) (\S+?)(?:\.pkg)?(:| from |\.\.\.)`)
 
-func (pk *PkgPkg) Upgrade() error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgPkg) Upgrade() error
+var progressStagesPkg = map[string]stage.ID{
+	"Fetching":     stage.Download,
+	"Installing":   stage.Install,
+	"Upgrading":    stage.Upgrade,
+	"Downgrading":  stage.Downgrade,
+	"Reinstalling": stage.Reinstall,
+	"Deinstalling": stage.Remove,
+}
 
-func (pk *PkgPkg) ListInstalled() ([]Package, error) {
-	return nil, krylib.ErrNotImplemented
-} // func (pkg *PkgPkg) ListInstalled() ([]Package, error)
+// parseProgressPkg makes sense of a line of pkg's output.
+func parseProgressPkg(line string) *Progress {
+	var m = patProgressPkg.FindStringSubmatch(line)
 
-func (pk *PkgPkg) Clean() error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgPkg) Clean() error
+	if m == nil {
+		return nil
+	}
 
-func (pkg *PkgPkg) LastUpdate() (time.Time, error) {
-	return time.Unix(0, 0), krylib.ErrNotImplemented
-} // func (pkg *PkgPkg) LastUpdate() (time.Time, error)
+	var p = &Progress{Stage: progressStagesPkg[m[3]], Package: m[4]}
+
+	// Upgrades and downgrades name the package and the versions
+	// separately.
+	if m[5] != " from " {
+		p.Package, _ = splitNameVersion(m[4])
+	}
+
+	p.Done, _ = strconv.Atoi(m[1])
+	p.Total, _ = strconv.Atoi(m[2])
+
+	return p
+} // func parseProgressPkg(line string) *Progress
+
+/* Output of pkg install -n emacs (excerpt):
+Updating FreeBSD repository catalogue...
+FreeBSD repository is up to date.
+All repositories are up to date.
+The following 3 package(s) will be affected (of 0 checked):
+
+New packages to be INSTALLED:
+	emacs: 28.2_4,3
+	gnutls: 3.7.8_1
+
+Installed packages to be UPGRADED:
+	curl: 8.0.1 -> 8.1.1
+
+Installed packages to be REINSTALLED:
+	jansson-2.14 (options changed)
+
+Number of packages to be installed: 2
+Number of packages to be upgraded: 1
+
+The process will require 120 MiB more space.
+45 MiB to be downloaded.
+*/
+
+var (
+	patPlanSectionPkg   = regexp.MustCompile(`packages to be (INSTALLED|UPGRADED|DOWNGRADED|REMOVED|REINSTALLED):$`)
+	patPlanPackagePkg   = regexp.MustCompile(`^\t(\S+): (\S+)(?: -> (\S+))?`)
+	patPlanReinstallPkg = regexp.MustCompile(`^\t(\S+)`)
+	patPlanDownloadPkg  = regexp.MustCompile(`(?m)^([\d.]+ \w+) to be downloaded\.$`)
+	patPlanDiskPkg      = regexp.MustCompile(`(?m)^The process will (?:require ([\d.]+ \w+) more space|free ([\d.]+ \w+))\.$`)
+)
+
+var pkgSections = map[string]action.ID{
+	"INSTALLED":   action.Install,
+	"UPGRADED":    action.Upgrade,
+	"DOWNGRADED":  action.Downgrade,
+	"REMOVED":     action.Remove,
+	"REINSTALLED": action.Reinstall,
+}
+
+func (pk *PkgPkg) Preview(ctx context.Context, op event.ID, pkgs ...string) (*Plan, error) {
+	var (
+		err       error
+		args      []string
+		output    string
+		act       action.ID
+		inSection bool
+		release   func()
+		plan      = newPlan(op)
+	)
+
+	// Locking the excluded packages takes root privileges, even though
+	// the dry run itself does not.
+	if args, err = pkgArgs(op, pkgs); err != nil {
+		return nil, err
+	} else if release, err = pk.holder().holdExcluded(ctx, op); err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	// Like the -y in transaction, the -n has to go before the package
+	// names. pkg exits with a non-zero status in dry-run mode if there is
+	// anything to do, so an error only counts if we got nothing useful
+	// out of it.
+	args = append([]string{args[0], "-n"}, args[1:]...)
+	output, _, err = runCommand(ctx, pk.run, cmdPkg, args...)
+
+	for _, line := range strings.Split(output, "\n") {
+		var m []string
+
+		if m = patPlanSectionPkg.FindStringSubmatch(line); m != nil {
+			act, inSection = pkgSections[m[1]], true
+			continue
+		} else if !strings.HasPrefix(line, "\t") {
+			inSection = false
+			continue
+		} else if !inSection {
+			continue
+		} else if act == action.Reinstall {
+			if m = patPlanReinstallPkg.FindStringSubmatch(line); m != nil {
+				var name, version = splitNameVersion(m[1])
+				plan.Changes = append(plan.Changes, Change{
+					Name:       name,
+					Action:     act,
+					OldVersion: version,
+					NewVersion: version,
+				})
+			}
+			continue
+		} else if m = patPlanPackagePkg.FindStringSubmatch(line); m == nil {
+			continue
+		}
+
+		var c = Change{Name: m[1], Action: act}
+
+		switch act {
+		case action.Install:
+			c.NewVersion = m[2]
+		case action.Remove:
+			c.OldVersion = m[2]
+		default:
+			c.OldVersion = m[2]
+			c.NewVersion = m[3]
+		}
+
+		plan.Changes = append(plan.Changes, c)
+	}
+
+	if err != nil && plan.Empty() {
+		pk.log.Printf("[ERROR] Failed to simulate %s: %s\n",
+			op,
+			err.Error())
+		return nil, err
+	}
+
+	if m := patPlanDownloadPkg.FindStringSubmatch(output); m != nil {
+		plan.DownloadSize = parseSize(m[1])
+	}
+
+	if m := patPlanDiskPkg.FindStringSubmatch(output); m != nil {
+		if m[1] != "" {
+			plan.DiskDelta = parseSize(m[1])
+		} else {
+			plan.DiskDelta = negateSize(parseSize(m[2]))
+		}
+	}
+
+	return plan, nil
+} // func (pk *PkgPkg) Preview(ctx context.Context, op event.ID, pkgs ...string) (*Plan, error)
@@ -7,28 +7,35 @@
 package backend
 
 import (
-	"bytes"
-	"io"
+	"context"
+	"fmt"
 	"log"
-	"os/exec"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/blicero/krylib"
+	"github.com/blicero/pkman/backend/stage"
 	"github.com/blicero/pkman/common"
 	"github.com/blicero/pkman/database"
+	"github.com/blicero/pkman/database/action"
+	"github.com/blicero/pkman/database/event"
 	"github.com/blicero/pkman/logdomain"
 )
 
+const cmdPkgInfo = "pkg_info"
+
 // PkgOpenBSD implements the PkgManager interface for OpenBSD's binary package
 // manager pkg_*
 type PkgOpenBSD struct {
 	log *log.Logger
 	db  *database.Database
+	run Runner
 }
 
 // CreatePkgOpenBSD creates a new instance of PkgOpenBSD.
-func CreatePkgOpenBSD() (*PkgOpenBSD, error) {
+func CreatePkgOpenBSD(r Runner) (*PkgOpenBSD, error) {
 	var (
 		err error
 		pk  = new(PkgOpenBSD)
@@ -43,8 +50,17 @@ func CreatePkgOpenBSD() (*PkgOpenBSD, error) {
 		return nil, err
 	}
 
+	if pk.run = r; pk.run == nil {
+		pk.run = NewExecRunner(pk.log)
+	}
+
 	return pk, nil
-} // func CreatePkgOpenBSD() (*PkgOpenBSD, error)
+} // func CreatePkgOpenBSD(r Runner) (*PkgOpenBSD, error)
+
+// historyDB returns the connection to the history database.
+func (pk *PkgOpenBSD) historyDB() *database.Database {
+	return pk.db
+} // func (pk *PkgOpenBSD) historyDB() *database.Database
 
 /* Output of pkg_info -Q emacs:
 debug-emacs-28.2p2-gtk2
@@ -55,85 +71,422 @@ emacs-28.2p2-gtk3
 emacs-28.2p2-no_x11 (installed)
 */
 
-var patSearchPkgOpenBSD = regexp.MustCompile(`(?mi)^(\D+)-(\S+)(?:\s+\(installed\)\s*)?$`)
+// The version includes the flavor, if any, e.g. 28.2p2-no_x11.
+var patSearchPkgOpenBSD = regexp.MustCompile(`(?m)^(\S+?)-(\d\S*?)(?:\s+\(installed\))?\s*$`)
 
-func (pk *PkgOpenBSD) Search(query string) ([]Package, error) {
-	const cmdPkgSearch = "/usr/sbin/pkg_info"
+func (pk *PkgOpenBSD) Search(ctx context.Context, query string) ([]Package, error) {
 	var (
-		err            error
-		cmd            *exec.Cmd
-		stdout, stderr io.ReadCloser
-		bufOut, bufErr bytes.Buffer
+		err    error
+		output string
 	)
 
-	cmd = exec.Command(cmdPkgSearch, "-Q", query)
-
-	if stdout, err = cmd.StdoutPipe(); err != nil {
-		pk.log.Printf("[ERROR] Cannot get stdout pipe from Cmd: %s\n",
+	if output, _, err = runCommand(ctx, pk.run, cmdPkgInfo, "-Q", query); searchFailed(err) {
+		pk.log.Printf("[ERROR] Failed to search for %q: %s\n",
+			query,
 			err.Error())
 		return nil, err
-	} else if stderr, err = cmd.StderrPipe(); err != nil {
-		pk.log.Printf("[ERROR] Cannot get stderr pipe from Cmd: %s\n",
+	}
+
+	var (
+		matches = patSearchPkgOpenBSD.FindAllStringSubmatch(output, -1)
+		pkList  = make([]Package, len(matches))
+	)
+
+	for i, m := range matches {
+		pkList[i] = Package{
+			Name:    m[1],
+			Version: m[2],
+		}
+	}
+
+	return pkList, nil
+} // func (pk *PkgOpenBSD) Search(ctx context.Context, query string) ([]Package, error)
+
+/* Output of pkg_info emacs (excerpt):
+Information for inst:emacs-28.2p2-no_x11
+
+Comment:
+GNU editor: extensible, customizable, self-documenting
+
+Description:
+GNU Emacs is a self-documenting, customizable, extensible real-time
+display editor.
+
+WWW: https://www.gnu.org/software/emacs/
+
+For packages that are not installed, the first line names the package file
+on the mirror instead of inst:...
+*/
+
+var (
+	patInfoHeadOpenBSD = regexp.MustCompile(`(?m)^Information for (\S+)\s*$`)
+	patInfoWWWOpenBSD  = regexp.MustCompile(`(?m)^WWW: (\S+)\s*$`)
+	patInfoCommOpenBSD = regexp.MustCompile(`(?m)^Comment:\n([^\n]+)$`)
+)
+
+func (pk *PkgOpenBSD) Info(ctx context.Context, name string) (*PackageInfo, error) {
+	var (
+		err    error
+		output string
+		m      []string
+		info   = new(PackageInfo)
+	)
+
+	if output, _, err = runCommand(ctx, pk.run, cmdPkgInfo, name); err != nil {
+		pk.log.Printf("[ERROR] Cannot get info on %s: %s\n",
+			name,
 			err.Error())
 		return nil, err
+	} else if m = patInfoHeadOpenBSD.FindStringSubmatch(output); m == nil {
+		return nil, fmt.Errorf("Cannot parse output of pkg_info: %q", output)
 	}
 
-	if err = cmd.Start(); err != nil {
-		pk.log.Printf("[ERROR] Error starting command: %s\n",
+	var pkgName = m[1]
+
+	if strings.HasPrefix(pkgName, "inst:") {
+		info.Installed = true
+		pkgName = pkgName[len("inst:"):]
+	} else {
+		pkgName = strings.TrimSuffix(filepath.Base(pkgName), ".tgz")
+	}
+
+	info.Name, info.Version = splitNameVersion(pkgName)
+
+	if m = patInfoCommOpenBSD.FindStringSubmatch(output); m != nil {
+		info.Description = m[1]
+	}
+
+	if m = patInfoWWWOpenBSD.FindStringSubmatch(output); m != nil {
+		info.URL = m[1]
+	}
+
+	return info, nil
+} // func (pk *PkgOpenBSD) Info(ctx context.Context, name string) (*PackageInfo, error)
+
+func (pk *PkgOpenBSD) Install(ctx context.Context, args ...string) error {
+	return pk.transaction(ctx, event.Add, args)
+} // func (pk *PkgOpenBSD) Install(ctx context.Context, args ...string) error
+
+func (pk *PkgOpenBSD) Remove(ctx context.Context, args ...string) error {
+	return pk.transaction(ctx, event.Delete, args)
+} // func (pk *PkgOpenBSD) Remove(ctx context.Context, args ...string) error
+
+func (pk *PkgOpenBSD) Update(ctx context.Context) error {
+	// pkg_add fetches the package index from the mirror every time, so
+	// there is nothing to refresh.
+	return nil
+} // func (pk *PkgOpenBSD) Update(ctx context.Context) error
+
+func (pk *PkgOpenBSD) Upgrade(ctx context.Context, securityOnly bool) error {
+	if securityOnly {
+		return ErrUnsupported
+	}
+
+	return pk.transaction(ctx, event.Update, nil)
+} // func (pk *PkgOpenBSD) Upgrade(ctx context.Context, securityOnly bool) error
+
+/* Output of pkg_add -un (excerpt):
+quirks-6.121 signed on 2023-05-28T21:21:10Z
+curl-8.0.1->8.1.1: ok
+emacs-28.2p1-no_x11->28.2p2-no_x11: ok
+*/
+
+const cmdPkgAdd = "pkg_add"
+
+var patUpgradeOpenBSD = regexp.MustCompile(`(?m)^(\S+?)-(\d\S*)->(\d\S*): ok`)
+
+func (pk *PkgOpenBSD) ListUpgrades(ctx context.Context, securityOnly bool) ([]PendingUpgrade, error) {
+	var (
+		err    error
+		output string
+	)
+
+	// OpenBSD publishes security fixes as errata, which are not part of
+	// the package metadata.
+	if securityOnly {
+		return nil, ErrUnsupported
+	}
+
+	if output, _, err = runCommand(ctx, pk.run, cmdPkgAdd, "-u", "-n"); err != nil {
+		pk.log.Printf("[ERROR] Cannot list available upgrades: %s\n",
 			err.Error())
 		return nil, err
 	}
 
-	io.Copy(&bufOut, stdout) // nolint: errcheck
-	io.Copy(&bufErr, stderr) // nolint: errcheck
+	var (
+		matches = patUpgradeOpenBSD.FindAllStringSubmatch(output, -1)
+		upList  = make([]PendingUpgrade, len(matches))
+	)
 
-	if err = cmd.Wait(); err != nil {
-		if _, ok := err.(*exec.ExitError); !ok {
-			pk.log.Printf("[ERROR] Failed to wait for command: %s\n",
-				err.Error())
-			return nil, err
+	for i, m := range matches {
+		upList[i] = PendingUpgrade{
+			Name:      m[1],
+			Installed: m[2],
+			Candidate: m[3],
 		}
 	}
 
+	return upList, nil
+} // func (pk *PkgOpenBSD) ListUpgrades(ctx context.Context, securityOnly bool) ([]PendingUpgrade, error)
+
+/* Output of pkg_info (excerpt):
+bzip2-1.0.8p0       block-sorting file compressor, unencumbered
+emacs-28.2p2-no_x11 GNU editor: extensible, customizable, self-documenting
+gettext-runtime-0.21p1 GNU gettext runtime libraries and programs
+*/
+
+var patListPkgOpenBSD = regexp.MustCompile(`(?m)^(\S+?)-(\d\S*)\s+([^\n]+)$`)
+
+func (pk *PkgOpenBSD) ListInstalled(ctx context.Context) ([]Package, error) {
 	var (
-		matches = patSearchPkg.FindAllStringSubmatch(bufOut.String(), -1)
+		err    error
+		output string
+	)
+
+	if output, _, err = runCommand(ctx, pk.run, cmdPkgInfo); err != nil {
+		pk.log.Printf("[ERROR] Cannot list installed packages: %s\n",
+			err.Error())
+		return nil, err
+	}
+
+	var (
+		matches = patListPkgOpenBSD.FindAllStringSubmatch(output, -1)
 		pkList  = make([]Package, len(matches))
 	)
 
 	for i, m := range matches {
 		pkList[i] = Package{
-			Name:    m[1],
-			Version: m[2],
+			Name:        m[1],
+			Version:     m[2],
+			Description: m[3],
 		}
 	}
 
 	return pkList, nil
-} // func (pk *PkgOpenBSD) Search(query string) ([]Package, error)
+} // func (pkg *PkgOpenBSD) ListInstalled(ctx context.Context) ([]Package, error)
 
-func (pk *PkgOpenBSD) Install(args ...string) error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgOpenBSD) Install(args ...string) error
+func (pk *PkgOpenBSD) Clean(ctx context.Context) error {
+	// pkg_add does not keep a cache of downloaded packages unless
+	// PKG_CACHE is set, in which case the user manages it.
+	return nil
+} // func (pk *PkgOpenBSD) Clean(ctx context.Context) error
 
-func (pk *PkgOpenBSD) Remove(args ...string) error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgOpenBSD) Remove(args ...string) error
+func (pkg *PkgOpenBSD) LastUpdate(ctx context.Context) (time.Time, error) {
+	return time.Unix(0, 0), krylib.ErrNotImplemented
+} // func (pkg *PkgOpenBSD) LastUpdate(ctx context.Context) (time.Time, error)
 
-func (pk *PkgOpenBSD) Update() error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgOpenBSD) Update() error
+// LockStatus always reports the database as unlocked. pkg_add locks its
+// database with flock(2), which we cannot query without taking the lock
+// ourselves.
+func (pk *PkgOpenBSD) LockStatus(ctx context.Context) (*LockInfo, error) {
+	return nil, nil
+} // func (pk *PkgOpenBSD) LockStatus(ctx context.Context) (*LockInfo, error)
 
-func (pk *PkgOpenBSD) Upgrade() error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgOpenBSD) Upgrade() error
+/* Output of pkg_info -q -f emacs (excerpt):
+@name emacs-28.2p2-no_x11
+@depend devel/gettext,-runtime:gettext-runtime-*:gettext-runtime-0.21p1
+@depend security/gnutls:gnutls-*:gnutls-3.7.8
+@depend devel/jansson:jansson-*:jansson-2.14
+*/
 
-func (pk *PkgOpenBSD) ListInstalled() ([]Package, error) {
-	return nil, krylib.ErrNotImplemented
-} // func (pkg *PkgOpenBSD) ListInstalled() ([]Package, error)
+var patDependsOpenBSD = regexp.MustCompile(`(?m)^@depend \S+:(\S+)\s*$`)
 
-func (pk *PkgOpenBSD) Clean() error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgOpenBSD) Clean() error
+func (pk *PkgOpenBSD) Depends(ctx context.Context, name string) ([]string, error) {
+	var (
+		err    error
+		output string
+		names  []string
+	)
 
-func (pkg *PkgOpenBSD) LastUpdate() (time.Time, error) {
-	return time.Unix(0, 0), krylib.ErrNotImplemented
-} // func (pkg *PkgOpenBSD) LastUpdate() (time.Time, error)
+	if output, _, err = runCommand(ctx, pk.run, cmdPkgInfo, "-q", "-f", name); err != nil {
+		pk.log.Printf("[ERROR] Cannot get dependencies of %s: %s\n",
+			name,
+			err.Error())
+		return nil, err
+	}
+
+	names = submatches(patDependsOpenBSD, output)
+
+	for i, n := range names {
+		names[i], _ = splitNameVersion(n)
+	}
+
+	return names, nil
+} // func (pk *PkgOpenBSD) Depends(ctx context.Context, name string) ([]string, error)
+
+func (pk *PkgOpenBSD) RequiredBy(ctx context.Context, name string) ([]string, error) {
+	var (
+		err    error
+		output string
+		names  []string
+	)
+
+	if output, _, err = runCommand(ctx, pk.run, cmdPkgInfo, "-q", "-R", name); err != nil {
+		pk.log.Printf("[ERROR] Cannot get reverse dependencies of %s: %s\n",
+			name,
+			err.Error())
+		return nil, err
+	}
+
+	names = uniqueLines(output)
+
+	for i, n := range names {
+		names[i], _ = splitNameVersion(n)
+	}
+
+	return names, nil
+} // func (pk *PkgOpenBSD) RequiredBy(ctx context.Context, name string) ([]string, error)
+
+const cmdPkgDelete = "pkg_delete"
+
+// openBSDArgs returns the command and the arguments for the given operation
+// on the given packages.
+func openBSDArgs(op event.ID, pkgs []string) (string, []string, error) {
+	switch op {
+	case event.Add:
+		return cmdPkgAdd, pkgs, nil
+	case event.Delete:
+		return cmdPkgDelete, pkgs, nil
+	case event.Update:
+		return cmdPkgAdd, append([]string{"-u"}, pkgs...), nil
+	default:
+		return "", nil, errUnsupportedOp(op)
+	}
+} // func openBSDArgs(op event.ID, pkgs []string) (string, []string, error)
+
+// notExcluded returns the packages to upgrade instead of all of them, as
+// pkg_add has no way to skip packages: the installed packages that are not
+// excluded. If no packages are excluded, or specific packages are asked
+// for, it returns pkgs as they are. If everything is excluded, ok is false.
+// pkg_add cannot keep excluded packages from being installed along with
+// others.
+func (pk *PkgOpenBSD) notExcluded(ctx context.Context, op event.ID, pkgs []string) ([]string, bool, error) {
+	var (
+		err       error
+		excl      []string
+		installed []Package
+		patterns  = excludePatterns(ctx, op)
+	)
+
+	if op != event.Update || len(pkgs) > 0 || len(patterns) == 0 {
+		return pkgs, true, nil
+	} else if installed, err = pk.ListInstalled(ctx); err != nil {
+		return nil, false, err
+	}
+
+	excl = matchExcluded(installed, patterns)
+
+	for _, p := range installed {
+		if !common.Contains(excl, p.Name) {
+			pkgs = append(pkgs, p.Name)
+		}
+	}
+
+	return pkgs, len(pkgs) > 0, nil
+} // func (pk *PkgOpenBSD) notExcluded(ctx context.Context, op event.ID, pkgs []string) ([]string, bool, error)
+
+// transaction performs the given operation on the given packages.
+func (pk *PkgOpenBSD) transaction(ctx context.Context, op event.ID, pkgs []string) error {
+	var (
+		err  error
+		ok   bool
+		cmd  string
+		args []string
+	)
+
+	if pkgs, ok, err = pk.notExcluded(ctx, op, pkgs); err != nil || !ok {
+		return err
+	} else if cmd, args, err = openBSDArgs(op, pkgs); err != nil {
+		return err
+	}
+
+	return runTransaction(ctx, pk.run, nil, cmd, append([]string{"-I"}, args...)...)
+} // func (pk *PkgOpenBSD) transaction(ctx context.Context, op event.ID, pkgs []string) error
+
+// parseProgressPkgAdd makes sense of a line of pkg_add's output, which looks
+// like that of pkg_add -n, see Preview.
+func parseProgressPkgAdd(line string) *Progress {
+	var m = patPlanOpenBSD.FindStringSubmatch(line)
+
+	if m == nil {
+		return nil
+	} else if m[3] == "" {
+		return &Progress{Stage: stage.Install, Package: m[1]}
+	}
+
+	switch versionChange(m[2], m[3]) {
+	case action.Downgrade:
+		return &Progress{Stage: stage.Downgrade, Package: m[1]}
+	default:
+		return &Progress{Stage: stage.Upgrade, Package: m[1]}
+	}
+} // func parseProgressPkgAdd(line string) *Progress
+
+// parseProgressPkgDelete makes sense of a line of pkg_delete's output.
+func parseProgressPkgDelete(line string) *Progress {
+	var m = patPlanOpenBSD.FindStringSubmatch(line)
+
+	if m == nil {
+		return nil
+	}
+
+	return &Progress{Stage: stage.Remove, Package: m[1]}
+} // func parseProgressPkgDelete(line string) *Progress
+
+/* Output of pkg_add -n emacs--no_x11 (excerpt):
+gettext-runtime-0.21p1: ok
+emacs-28.2p2-no_x11: ok
+
+Output of pkg_add -u -n (excerpt):
+curl-8.0.1->8.1.1: ok
+
+pkg_delete -n prints the same as pkg_add -n for the packages it would remove.
+pkg_add and pkg_delete do not tell us about sizes.
+*/
+
+var patPlanOpenBSD = regexp.MustCompile(`(?m)^(\S+?)-(\d\S*?)(?:->(\d\S*))?: ok\s*$`)
+
+func (pk *PkgOpenBSD) Preview(ctx context.Context, op event.ID, pkgs ...string) (*Plan, error) {
+	var (
+		err    error
+		ok     bool
+		cmd    string
+		args   []string
+		output string
+		plan   = newPlan(op)
+	)
+
+	if pkgs, ok, err = pk.notExcluded(ctx, op, pkgs); err != nil {
+		return nil, err
+	} else if !ok {
+		return plan, nil
+	} else if cmd, args, err = openBSDArgs(op, pkgs); err != nil {
+		return nil, err
+	} else if output, _, err = runCommand(ctx, pk.run, cmd, append([]string{"-n"}, args...)...); err != nil {
+		pk.log.Printf("[ERROR] Failed to simulate %s: %s\n",
+			op,
+			err.Error())
+		return nil, err
+	}
+
+	for _, m := range patPlanOpenBSD.FindAllStringSubmatch(output, -1) {
+		var c = Change{Name: m[1]}
+
+		switch {
+		case op == event.Delete:
+			c.Action = action.Remove
+			c.OldVersion = m[2]
+		case m[3] != "":
+			c.Action = versionChange(m[2], m[3])
+			c.OldVersion = m[2]
+			c.NewVersion = m[3]
+		default:
+			c.Action = action.Install
+			c.NewVersion = m[2]
+		}
+
+		plan.Changes = append(plan.Changes, c)
+	}
+
+	return plan, nil
+} // func (pk *PkgOpenBSD) Preview(ctx context.Context, op event.ID, pkgs ...string) (*Plan, error)
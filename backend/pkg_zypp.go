@@ -7,29 +7,35 @@
 package backend
 
 import (
-	"bytes"
-	"io"
+	"context"
+	"encoding/xml"
+	"fmt"
 	"log"
-	"os/exec"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/blicero/krylib"
+	"github.com/blicero/pkman/backend/stage"
 	"github.com/blicero/pkman/common"
 	"github.com/blicero/pkman/database"
+	"github.com/blicero/pkman/database/action"
+	"github.com/blicero/pkman/database/event"
 	"github.com/blicero/pkman/logdomain"
 )
 
-const cmdZypper = "/usr/bin/zypper"
+const cmdZypper = "zypper"
 
 // PkgZypp implements the PkgManager interface for openSuse's zypper.
 type PkgZypp struct {
 	log *log.Logger
 	db  *database.Database
+	run Runner
 }
 
 // CreatePkgZypp creates a new instance of PkgZypp.
-func CreatePkgZypp() (*PkgZypp, error) {
+func CreatePkgZypp(r Runner) (*PkgZypp, error) {
 	var (
 		err error
 		pk  = new(PkgZypp)
@@ -44,126 +50,632 @@ func CreatePkgZypp() (*PkgZypp, error) {
 		return nil, err
 	}
 
+	if pk.run = r; pk.run == nil {
+		pk.run = NewExecRunner(pk.log)
+	}
+
 	return pk, nil
-} // func CreatePkgZypp() (*PkgZypp, error)
-
-/* Output of zypper search emacs:
-Repository 'X11:Utilities' ist veraltet. Sie können 'zypper refresh' als root ausführen, um es zu aktualisieren.
-Repository-Daten werden geladen...
-Installierte Pakete werden gelesen...
-
-S  | Name                   | Summary                                                        | Type
----+------------------------+----------------------------------------------------------------+------
-i+ | emacs                  | GNU Emacs Base Package                                         | Paket
-i+ | emacs-apel             | A Portable Emacs Library                                       | Paket
-i  | emacs-auctex           | AUC TeX: An Emacs Extension                                    | Paket
-   | emacs-color-theme      | Color themes for emacs                                         | Paket
-i  | emacs-el               | Several Lisp Files for GNU Emacs                               | Paket
-i  | emacs-eln              | GNU Emacs-nox: Emacs Lisp native compiled binary files         | Paket
-i+ | emacs-flim             | An Emacs Library for MIME                                      | Paket
-   | emacs-gnuplot-mode     | Gnuplot mode for EMACS                                         | Paket
-   | emacs-gnuplot-mode-doc | Documentation for EMACS Gnuplot mode                           | Paket
-i+ | emacs-info             | Info files for GNU Emacs                                       | Paket
-i+ | emacs-nox              | GNU Emacs-nox: An Emacs Binary without X Window System Support | Paket
-   | emacs-plugin-devhelp   | Devhelp plugin for Emacs                                       | Paket
-   | emacs-poke             | Emacs support for poke                                         | Paket
-   | emacs-scheme48         | CMUScheme48 emacs mode                                         | Paket
-   | emacs-semi             | Library to provide MIME feature for GNU Emacs                  | Paket
-   | emacs-vm               | VM - a mail reader for GNU Emacs                               | Paket
-i+ | emacs-w3m              | An interface program to use w3m with Emacs                     | Paket
-i+ | emacs-x11              | GNU Emacs: Emacs binary with X Window System Support           | Paket
-   | notmuch-emacs          | Emacs lisp email client based on notmuch                       | Paket
-   | pinentry-emacs         | Simple PIN or Passphrase Entry Dialog integrated into Emacs    | Paket
-   | qemacs                 | An editor similar to Emacs                                     | Paket
-   | supercollider-emacs    | SuperCollider support for Emacs                                | Paket
-   | vagrant-emacs          | Vagrantfile syntax files for the emacs editor                  | Paket
-   | xemacs                 | XEmacs                                                         | Paket
-   | xemacs-el              | Emacs-Lisp source files for XEmacs                             | Paket
-   | xemacs-info            | Info Files for XEmacs                                          | Paket
-   | xemacs-packages        | XEmacs Packages                                                | Paket
-   | xemacs-packages-el     | Emacs-Lisp source files for the XEmacs packages                | Paket
-   | xemacs-packages-info   | Info Files for the XEmacs Packages                             | Paket
+} // func CreatePkgZypp(r Runner) (*PkgZypp, error)
+
+// historyDB returns the connection to the history database.
+func (pk *PkgZypp) historyDB() *database.Database {
+	return pk.db
+} // func (pk *PkgZypp) historyDB() *database.Database
 
+/* Output of zypper --xmlout search -t package emacs (excerpt):
+<?xml version='1.0'?>
+<stream>
+<message type="info">Loading repository data...</message>
+<message type="info">Reading installed packages...</message>
+<search-result version="0.0">
+<solvable-list>
+<solvable status="installed" name="emacs" summary="GNU Emacs Base Package" kind="package"/>
+<solvable status="not-installed" name="emacs-vm" summary="VM - a mail reader for GNU Emacs" kind="package"/>
+</solvable-list>
+</search-result>
+</stream>
 */
 
-var patSearchZypp = regexp.MustCompile(`(?mi)^(?:i\+?)?\s+\| (\S+)\s+\| (.*?)\s+\| \w+\s*$`)
+// zyppStream is the document zypper prints with --xmlout. We only declare
+// the parts we are interested in.
+type zyppStream struct {
+	Solvables []struct {
+		Name    string `xml:"name,attr"`
+		Summary string `xml:"summary,attr"`
+		Status  string `xml:"status,attr"`
+	} `xml:"search-result>solvable-list>solvable"`
+	Updates []struct {
+		Kind       string `xml:"kind,attr"`
+		Name       string `xml:"name,attr"`
+		Edition    string `xml:"edition,attr"`
+		EditionOld string `xml:"edition-old,attr"`
+		Category   string `xml:"category,attr"`
+	} `xml:"update-status>update-list>update"`
+}
 
-func (pk *PkgZypp) Search(query string) ([]Package, error) {
+// zypperXML runs zypper with --xmlout and decodes its output.
+func (pk *PkgZypp) zypperXML(ctx context.Context, args ...string) (*zyppStream, error) {
 	var (
-		err            error
-		cmd            *exec.Cmd
-		stdout, stderr io.ReadCloser
-		bufOut, bufErr bytes.Buffer
+		err    error
+		output string
+		doc    = new(zyppStream)
 	)
 
-	cmd = exec.Command(cmdZypper, "se", query)
+	output, _, err = runCommand(ctx, pk.run, cmdZypper, append([]string{"--xmlout", "--non-interactive"}, args...)...)
 
-	if stdout, err = cmd.StdoutPipe(); err != nil {
-		pk.log.Printf("[ERROR] Cannot get stdout pipe from Cmd: %s\n",
+	// zypper search exits with status 104 if it does not find anything,
+	// which the XML tells us as well.
+	if err != nil && exitCode(err) != 104 {
+		return nil, err
+	} else if err = xml.Unmarshal([]byte(output), doc); err != nil {
+		return nil, fmt.Errorf("Cannot parse output of zypper %s: %w",
+			strings.Join(args, " "),
+			err)
+	}
+
+	return doc, nil
+} // func (pk *PkgZypp) zypperXML(ctx context.Context, args ...string) (*zyppStream, error)
+
+func (pk *PkgZypp) Search(ctx context.Context, query string) ([]Package, error) {
+	var (
+		err error
+		doc *zyppStream
+	)
+
+	if doc, err = pk.zypperXML(ctx, "search", "-t", "package", query); err != nil {
+		pk.log.Printf("[ERROR] Failed to search for %q: %s\n",
+			query,
 			err.Error())
 		return nil, err
-	} else if stderr, err = cmd.StderrPipe(); err != nil {
-		pk.log.Printf("[ERROR] Cannot get stderr pipe from Cmd: %s\n",
+	}
+
+	var pkList = make([]Package, len(doc.Solvables))
+
+	for i, s := range doc.Solvables {
+		pkList[i] = Package{
+			Name:        s.Name,
+			Description: s.Summary,
+		}
+	}
+
+	return pkList, nil
+} // func (pk *PkgZypp) Search(ctx context.Context, query string) ([]Package, error)
+
+/* Output of zypper info emacs (excerpt):
+Information for package emacs:
+------------------------------
+Repository     : openSUSE-Tumbleweed-Oss
+Name           : emacs
+Version        : 28.2-8.1
+Arch           : x86_64
+Vendor         : openSUSE
+Installed Size : 107.2 MiB
+Installed      : Yes
+Status         : up-to-date
+Source package : emacs-28.2-8.1.src
+Upstream URL   : https://www.gnu.org/software/emacs/
+Summary        : GNU Emacs Base Package
+Description    :
+    Basic package for the GNU Emacs editor.
+
+For packages it does not know, zypper info says so, but exits with status 0:
+package 'foo' not found.
+*/
+
+func (pk *PkgZypp) Info(ctx context.Context, name string) (*PackageInfo, error) {
+	var (
+		err    error
+		output string
+		values map[string]string
+	)
+
+	if output, _, err = runCommand(ctx, pk.run, cmdZypper, "--non-interactive", "info", name); err != nil {
+		pk.log.Printf("[ERROR] Cannot get info on %s: %s\n",
+			name,
 			err.Error())
 		return nil, err
 	}
 
-	if err = cmd.Start(); err != nil {
-		pk.log.Printf("[ERROR] Error starting command: %s\n",
+	if values = parseKeyValues(output); values["Name"] == "" {
+		return nil, fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+
+	var info = &PackageInfo{
+		Package: Package{
+			Name:        values["Name"],
+			Version:     values["Version"],
+			Description: values["Summary"],
+		},
+		Installed:  strings.HasPrefix(values["Installed"], "Yes"),
+		Repository: values["Repository"],
+		URL:        values["Upstream URL"],
+		License:    values["License"],
+		Size:       parseSize(values["Installed Size"]),
+	}
+
+	return info, nil
+} // func (pk *PkgZypp) Info(ctx context.Context, name string) (*PackageInfo, error)
+
+func (pk *PkgZypp) Install(ctx context.Context, args ...string) error {
+	return pk.transaction(ctx, event.Add, args)
+} // func (pk *PkgZypp) Install(ctx context.Context, args ...string) error
+
+func (pk *PkgZypp) Remove(ctx context.Context, args ...string) error {
+	return pk.transaction(ctx, event.Delete, args)
+} // func (pk *PkgZypp) Remove(ctx context.Context, args ...string) error
+
+func (pk *PkgZypp) Update(ctx context.Context) error {
+	return runTransaction(ctx, pk.run, nil, cmdZypper, "--non-interactive", "refresh")
+} // func (pk *PkgZypp) Update(ctx context.Context) error
+
+func (pk *PkgZypp) Upgrade(ctx context.Context, securityOnly bool) error {
+	if securityOnly {
+		var (
+			err     error
+			release func()
+		)
+
+		if release, err = pk.holder().holdExcluded(ctx, event.Update); err != nil {
+			return err
+		}
+
+		defer release()
+
+		return runTransaction(ctx, pk.run, nil, cmdZypper,
+			"--non-interactive",
+			"patch",
+			"--category", "security")
+	}
+
+	return pk.transaction(ctx, event.Update, nil)
+} // func (pk *PkgZypp) Upgrade(ctx context.Context, securityOnly bool) error
+
+/* Output of zypper --xmlout list-updates (excerpt):
+<stream>
+<update-status version="0.6">
+<update-list>
+<update kind="package" name="bash" edition="5.2.15-2.1" arch="x86_64" edition-old="5.2.15-1.2">
+<summary>The GNU Bourne-Again Shell</summary>
+<source url="https://download.opensuse.org/tumbleweed/repo/oss" alias="repo-oss"/>
+</update>
+</update-list>
+</update-status>
+</stream>
+
+Older versions of zypper do not tell the installed version (edition-old), in
+which case we look it up.
+*/
+
+func (pk *PkgZypp) ListUpgrades(ctx context.Context, securityOnly bool) ([]PendingUpgrade, error) {
+	var (
+		err    error
+		doc    *zyppStream
+		upList []PendingUpgrade
+	)
+
+	if securityOnly {
+		return pk.listSecurityUpgrades(ctx)
+	}
+
+	if doc, err = pk.zypperXML(ctx, "list-updates"); err != nil {
+		pk.log.Printf("[ERROR] Cannot list available upgrades: %s\n",
 			err.Error())
 		return nil, err
 	}
 
-	io.Copy(&bufOut, stdout) // nolint: errcheck
-	io.Copy(&bufErr, stderr) // nolint: errcheck
+	var lookup = false
+
+	for _, u := range doc.Updates {
+		if u.Kind != "package" {
+			continue
+		}
 
-	if err = cmd.Wait(); err != nil {
-		if _, ok := err.(*exec.ExitError); !ok {
-			pk.log.Printf("[ERROR] Failed to wait for command: %s\n",
+		upList = append(upList, PendingUpgrade{
+			Name:      u.Name,
+			Installed: u.EditionOld,
+			Candidate: u.Edition,
+		})
+		lookup = lookup || u.EditionOld == ""
+	}
+
+	if lookup {
+		var installed []Package
+
+		if installed, err = pk.ListInstalled(ctx); err != nil {
+			return nil, err
+		}
+
+		var versions = make(map[string]string, len(installed))
+
+		for _, p := range installed {
+			versions[p.Name] = p.Version
+		}
+
+		for i := range upList {
+			if upList[i].Installed == "" {
+				upList[i].Installed = versions[upList[i].Name]
+			}
+		}
+	}
+
+	return upList, nil
+} // func (pk *PkgZypp) ListUpgrades(ctx context.Context, securityOnly bool) ([]PendingUpgrade, error)
+
+func (pk *PkgZypp) ListInstalled(ctx context.Context) ([]Package, error) {
+	return rpmListInstalled(ctx, pk.run, pk.log)
+} // func (pkg *PkgZypp) ListInstalled(ctx context.Context) ([]Package, error)
+
+func (pk *PkgZypp) Clean(ctx context.Context) error {
+	return runTransaction(ctx, pk.run, nil, cmdZypper, "--non-interactive", "clean", "--all")
+} // func (pk *PkgZypp) Clean(ctx context.Context) error
+
+func (pkg *PkgZypp) LastUpdate(ctx context.Context) (time.Time, error) {
+	return time.Unix(0, 0), krylib.ErrNotImplemented
+} // func (pkg *PkgZypp) LastUpdate(ctx context.Context) (time.Time, error)
+
+// LockStatus checks the PID file libzypp uses to lock the system, and the
+// lock on the rpm database.
+func (pk *PkgZypp) LockStatus(ctx context.Context) (*LockInfo, error) {
+	return firstLock(
+		pidFileLock("/run/zypp.pid"),
+		pidFileLock("/var/run/zypp.pid"),
+		fcntlLock("/var/lib/rpm/.rpm.lock"),
+	), nil
+} // func (pk *PkgZypp) LockStatus(ctx context.Context) (*LockInfo, error)
+
+// zypper itself has no convenient way to list the dependencies of installed
+// packages in terms of package names, so we ask rpm.
+
+func (pk *PkgZypp) Depends(ctx context.Context, name string) ([]string, error) {
+	return rpmDepends(ctx, pk.run, pk.log, name)
+} // func (pk *PkgZypp) Depends(ctx context.Context, name string) ([]string, error)
+
+func (pk *PkgZypp) RequiredBy(ctx context.Context, name string) ([]string, error) {
+	return rpmRequiredBy(ctx, pk.run, pk.log, name)
+} // func (pk *PkgZypp) RequiredBy(ctx context.Context, name string) ([]string, error)
+
+/* Output of zypper --xmlout list-patches --category security (excerpt):
+<stream>
+<update-status version="0.6">
+<update-list>
+<update kind="patch" name="openSUSE-SLE-15.4-2023-2345" edition="1" arch="noarch" status="needed" category="security" severity="important" pkgmanager="false" restart="false" interactive="false">
+<summary>Security update for curl</summary>
+</update>
+</update-list>
+</update-status>
+</stream>
+
+Output of zypper info -t patch openSUSE-SLE-15.4-2023-2345 (excerpt):
+Conflicts : [4]
+    curl.x86_64 < 8.0.1-150400.5.23.1
+    libcurl4.x86_64 < 8.0.1-150400.5.23.1
+    srcpackage:curl < 8.0.1-150400.5.23.1
+*/
+
+var patPatchConflictZypp = regexp.MustCompile(`(?m)^\s+([^\s:]+)\.[^.\s]+ < (\S+)\s*$`)
+
+// listSecurityUpgrades lists the packages that would be updated by installing
+// the pending security patches.
+// zypper lists patches, not packages, so for each patch we have to look up
+// which packages it updates.
+func (pk *PkgZypp) listSecurityUpgrades(ctx context.Context) ([]PendingUpgrade, error) {
+	var (
+		err       error
+		output    string
+		doc       *zyppStream
+		installed []Package
+		patches   []string
+		upList    []PendingUpgrade
+		versions  = make(map[string]string)
+		idx       = make(map[string]int)
+	)
+
+	if doc, err = pk.zypperXML(ctx, "list-patches", "--category", "security"); err != nil {
+		pk.log.Printf("[ERROR] Cannot list security patches: %s\n",
+			err.Error())
+		return nil, err
+	}
+
+	for _, u := range doc.Updates {
+		if u.Kind == "patch" && u.Category == "security" {
+			patches = append(patches, u.Name)
+		}
+	}
+
+	if len(patches) == 0 {
+		return nil, nil
+	} else if installed, err = pk.ListInstalled(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, p := range installed {
+		versions[p.Name] = p.Version
+	}
+
+	for _, patch := range patches {
+		if output, _, err = runCommand(ctx, pk.run, cmdZypper,
+			"--non-interactive",
+			"info",
+			"-t", "patch",
+			patch); err != nil {
+			pk.log.Printf("[ERROR] Cannot get info on patch %s: %s\n",
+				patch,
 				err.Error())
 			return nil, err
 		}
+
+		for _, m := range patPatchConflictZypp.FindAllStringSubmatch(output, -1) {
+			var name = m[1]
+
+			if _, ok := versions[name]; !ok {
+				continue
+			} else if i, ok := idx[name]; ok {
+				upList[i].Advisories = append(upList[i].Advisories, patch)
+				continue
+			}
+
+			idx[name] = len(upList)
+			upList = append(upList, PendingUpgrade{
+				Name:       name,
+				Installed:  versions[name],
+				Candidate:  m[2],
+				Advisories: []string{patch},
+			})
+		}
+	}
+
+	return upList, nil
+} // func (pk *PkgZypp) listSecurityUpgrades(ctx context.Context) ([]PendingUpgrade, error)
+
+// zyppArgs returns the arguments to zypper for the given operation on the
+// given packages.
+func zyppArgs(op event.ID, pkgs []string) ([]string, error) {
+	switch op {
+	case event.Add:
+		return append([]string{"install"}, pkgs...), nil
+	case event.Delete:
+		return append([]string{"remove"}, pkgs...), nil
+	case event.Update:
+		return append([]string{"update"}, pkgs...), nil
+	default:
+		return nil, errUnsupportedOp(op)
 	}
+} // func zyppArgs(op event.ID, pkgs []string) ([]string, error)
 
+// transaction performs the given operation on the given packages.
+func (pk *PkgZypp) transaction(ctx context.Context, op event.ID, pkgs []string) error {
 	var (
-		matches = patSearchZypp.FindAllStringSubmatch(bufOut.String(), -1)
-		pkList  = make([]Package, len(matches))
+		err     error
+		args    []string
+		release func()
 	)
 
-	for i, m := range matches {
-		pkList[i] = Package{
-			Name:        m[1],
-			Description: m[2],
+	if args, err = zyppArgs(op, pkgs); err != nil {
+		return err
+	} else if release, err = pk.holder().holdExcluded(ctx, op); err != nil {
+		return err
+	}
+
+	defer release()
+
+	return runTransaction(ctx, pk.run, nil, cmdZypper, append([]string{"--non-interactive"}, args...)...)
+} // func (pk *PkgZypp) transaction(ctx context.Context, op event.ID, pkgs []string) error
+
+/*
+Output of zypper locks
+
+# | Name | Matches | Type    | Repository
+--+------+---------+---------+-----------
+1 | mg   | 1       | package | (any)
+*/
+
+var patLockZypp = regexp.MustCompile(`(?m)^\s*\d+\s*\|\s*(\S+)\s*\|`)
+
+// holder keeps excluded packages out of a transaction by locking them, as
+// zypper has no way to skip packages otherwise.
+func (pk *PkgZypp) holder() *holder {
+	var lock = func(verb string) holdFunc {
+		return func(ctx context.Context, names []string) error {
+			var _, _, err = runPrivileged(ctx, pk.run, nil, cmdZypper,
+				append([]string{"--non-interactive", verb}, names...)...)
+
+			if err != nil {
+				pk.log.Printf("[ERROR] Cannot %s %s: %s\n",
+					verb,
+					strings.Join(names, ", "),
+					err.Error())
+			}
+
+			return err
 		}
 	}
 
-	return pkList, nil
-} // func (pk *PkgZypp) Search(query string) ([]Package, error)
+	return &holder{
+		installed: pk.ListInstalled,
+		held: func(ctx context.Context) ([]string, error) {
+			var (
+				names          []string
+				output, _, err = runCommand(ctx, pk.run, cmdZypper, "locks")
+			)
+
+			for _, m := range patLockZypp.FindAllStringSubmatch(output, -1) {
+				names = append(names, m[1])
+			}
+
+			return names, err
+		},
+		hold:    lock("addlock"),
+		release: lock("removelock"),
+	}
+} // func (pk *PkgZypp) holder() *holder
+
+/*
+Output of zypper --non-interactive install emacs-x11 (excerpt)
+Retrieving: emacs-x11-27.2-150400.3.3.1.x86_64 (Main Update Repository) (1/2),   2.4 MiB
+(1/2) Installing: emacs-x11-27.2-150400.3.3.1.x86_64 ..........................[done]
+(2/2) Removing yasr-0.6.9-10.1.x86_64 .........................................[done]
+*/
+
+var (
+	patProgressRetrieveZypp = regexp.MustCompile(`^Retrieving(?: package|:) (\S+) .*\((\d+)/(\d+)\)`)
+	patProgressStepZypp     = regexp.MustCompile(`^\((\d+)/(\d+)\) (Installing|Removing):? (\S+)`)
+)
 
-func (pk *PkgZypp) Install(args ...string) error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgZypp) Install(args ...string) error
+// parseProgressZypp makes sense of a line of zypper's output.
+// zypper does not tell an upgrade from an installation.
+func parseProgressZypp(line string) *Progress {
+	var (
+		m []string
+		p = new(Progress)
+	)
 
-func (pk *PkgZypp) Remove(args ...string) error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgZypp) Remove(args ...string) error
+	if m = patProgressRetrieveZypp.FindStringSubmatch(line); m != nil {
+		p.Stage = stage.Download
+		p.Package, _ = splitNEVRA(m[1])
+		p.Done, _ = strconv.Atoi(m[2])
+		p.Total, _ = strconv.Atoi(m[3])
+		return p
+	} else if m = patProgressStepZypp.FindStringSubmatch(line); m == nil {
+		return nil
+	} else if m[3] == "Removing" {
+		p.Stage = stage.Remove
+	} else {
+		p.Stage = stage.Install
+	}
 
-func (pk *PkgZypp) Update() error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgZypp) Update() error
+	p.Package, _ = splitNEVRA(m[4])
+	p.Done, _ = strconv.Atoi(m[1])
+	p.Total, _ = strconv.Atoi(m[2])
 
-func (pk *PkgZypp) Upgrade() error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgZypp) Upgrade() error
+	return p
+} // func parseProgressZypp(line string) *Progress
 
-func (pk *PkgZypp) ListInstalled() ([]Package, error) {
-	return nil, krylib.ErrNotImplemented
-} // func (pkg *PkgZypp) ListInstalled() ([]Package, error)
+/* Output of zypper --non-interactive install --dry-run --details emacs-x11 (excerpt):
+Loading repository data...
+Reading installed packages...
+Resolving package dependencies...
 
-func (pk *PkgZypp) Clean() error {
-	return krylib.ErrNotImplemented
-} // func (pk *PkgZypp) Clean() error
+The following 2 NEW packages are going to be installed:
+  emacs-x11   28.2-8.1  x86_64  openSUSE-Tumbleweed-Oss  openSUSE
+  libXaw3d8   1.6.4-1.1 x86_64  openSUSE-Tumbleweed-Oss  openSUSE
 
-func (pkg *PkgZypp) LastUpdate() (time.Time, error) {
-	return time.Unix(0, 0), krylib.ErrNotImplemented
-} // func (pkg *PkgZypp) LastUpdate() (time.Time, error)
+The following package is going to be upgraded:
+  bash  5.2.15-1.2 -> 5.2.15-2.1  x86_64  openSUSE-Tumbleweed-Oss  openSUSE
+
+The following package is going to be REMOVED:
+  emacs-nox  28.2-7.3  x86_64  @System  openSUSE
+
+2 new packages to install, 1 to upgrade, 1 to remove.
+Overall download size: 12.3 MiB. Already cached: 0 B. After the operation, additional 45.6 MiB will be used.
+*/
+
+var (
+	patPlanSectionZypp  = regexp.MustCompile(`^The following (?:\d+ )?(?:NEW )?packages? (?:is|are) going to be (installed|upgraded|downgraded|REMOVED|reinstalled):`)
+	patPlanDownloadZypp = regexp.MustCompile(`Overall download size: ([\d.,]+ \w+)\.`)
+	patPlanUsedZypp     = regexp.MustCompile(`After the operation, additional ([\d.,]+ \w+) will be used`)
+	patPlanFreedZypp    = regexp.MustCompile(`After the operation, ([\d.,]+ \w+) will be freed`)
+)
+
+var zyppSections = map[string]action.ID{
+	"installed":   action.Install,
+	"upgraded":    action.Upgrade,
+	"downgraded":  action.Downgrade,
+	"REMOVED":     action.Remove,
+	"reinstalled": action.Reinstall,
+}
+
+func (pk *PkgZypp) Preview(ctx context.Context, op event.ID, pkgs ...string) (*Plan, error) {
+	var (
+		err       error
+		args      []string
+		output    string
+		installed []Package
+		act       action.ID
+		inSection bool
+		release   func()
+		versions  = make(map[string]string)
+		plan      = newPlan(op)
+	)
+
+	if args, err = zyppArgs(op, pkgs); err != nil {
+		return nil, err
+	} else if installed, err = pk.ListInstalled(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, p := range installed {
+		versions[p.Name] = p.Version
+	}
+
+	args = append(args, "--dry-run", "--details")
+
+	if release, err = pk.holder().holdExcluded(ctx, op); err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	// zypper insists on root privileges even for a dry run.
+	if output, _, err = runPrivileged(ctx, pk.run, nil, cmdZypper, append([]string{"--non-interactive"}, args...)...); err != nil {
+		pk.log.Printf("[ERROR] Failed to simulate %s: %s\n",
+			op,
+			err.Error())
+		return nil, err
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		var m []string
+
+		if m = patPlanSectionZypp.FindStringSubmatch(line); m != nil {
+			act, inSection = zyppSections[m[1]], true
+			continue
+		} else if strings.TrimSpace(line) == "" {
+			inSection = false
+			continue
+		} else if !inSection {
+			continue
+		}
+
+		var fields = strings.Fields(line)
+
+		// Without --details, or with an old version of zypper, we only
+		// get a list of names.
+		if len(fields) < 2 || !isDigit(fields[1][0]) {
+			for _, name := range fields {
+				plan.Changes = append(plan.Changes, Change{
+					Name:       name,
+					Action:     act,
+					OldVersion: versions[name],
+				})
+			}
+			continue
+		}
+
+		var c = Change{Name: fields[0], Action: act}
+
+		switch {
+		case act == action.Remove:
+			c.OldVersion = fields[1]
+		case len(fields) >= 4 && fields[2] == "->":
+			c.OldVersion = fields[1]
+			c.NewVersion = fields[3]
+		default:
+			c.OldVersion = versions[fields[0]]
+			c.NewVersion = fields[1]
+		}
+
+		plan.Changes = append(plan.Changes, c)
+	}
+
+	if m := patPlanDownloadZypp.FindStringSubmatch(output); m != nil {
+		plan.DownloadSize = parseSize(m[1])
+	}
+
+	if m := patPlanUsedZypp.FindStringSubmatch(output); m != nil {
+		plan.DiskDelta = parseSize(m[1])
+	} else if m = patPlanFreedZypp.FindStringSubmatch(output); m != nil {
+		plan.DiskDelta = negateSize(parseSize(m[1]))
+	}
+
+	return plan, nil
+} // func (pk *PkgZypp) Preview(ctx context.Context, op event.ID, pkgs ...string) (*Plan, error)
@@ -0,0 +1,255 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/plan.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/blicero/pkman/database/action"
+	"github.com/blicero/pkman/database/event"
+)
+
+// Change is the change a transaction makes to a single package.
+// OldVersion is empty for packages that are newly installed, NewVersion is
+// empty for packages that are removed.
+type Change struct {
+	Name       string    `json:"name" yaml:"name"`
+	Action     action.ID `json:"action" yaml:"action"`
+	OldVersion string    `json:"old_version" yaml:"old_version"`
+	NewVersion string    `json:"new_version" yaml:"new_version"`
+}
+
+// Plan describes what a transaction would do, as reported by the package
+// manager's simulation mode.
+// DownloadSize is the number of bytes that need to be downloaded, DiskDelta
+// is the change in disk usage in bytes, which is negative if the transaction
+// frees space. Either is nil if the package manager does not tell us, and
+// then left out of JSON and YAML output.
+type Plan struct {
+	Op           event.ID `json:"op" yaml:"op"`
+	Changes      []Change `json:"changes" yaml:"changes"`
+	DownloadSize *int64   `json:"download_size,omitempty" yaml:"download_size,omitempty"`
+	DiskDelta    *int64   `json:"disk_delta,omitempty" yaml:"disk_delta,omitempty"`
+}
+
+// errUnsupportedOp returns the error for an operation that cannot be
+// performed or previewed.
+func errUnsupportedOp(op event.ID) error {
+	return fmt.Errorf("Cannot perform operation %s: %w", op, ErrUnsupported)
+} // func errUnsupportedOp(op event.ID) error
+
+func newPlan(op event.ID) *Plan {
+	return &Plan{
+		Op:      op,
+		Changes: make([]Change, 0),
+	}
+} // func newPlan(op event.ID) *Plan
+
+// Count returns the number of changes with the given action.
+func (p *Plan) Count(a action.ID) int {
+	var cnt int
+
+	for _, c := range p.Changes {
+		if c.Action == a {
+			cnt++
+		}
+	}
+
+	return cnt
+} // func (p *Plan) Count(a action.ID) int
+
+// Empty returns true if the Plan does not change anything.
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0
+} // func (p *Plan) Empty() bool
+
+// versionChange returns the action that takes a package from version
+// oldVersion to newVersion.
+func versionChange(oldVersion, newVersion string) action.ID {
+	switch {
+	case oldVersion == "":
+		return action.Install
+	case newVersion == "":
+		return action.Remove
+	}
+
+	switch cmp := compareVersions(oldVersion, newVersion); {
+	case cmp < 0:
+		return action.Upgrade
+	case cmp > 0:
+		return action.Downgrade
+	default:
+		return action.Reinstall
+	}
+} // func versionChange(oldVersion, newVersion string) action.ID
+
+// compareVersions compares two version strings and returns a negative number
+// if a is older than b, a positive number if a is newer than b, and zero if
+// both are the same.
+// It is a simplified take on the algorithm used by rpm and dpkg: Versions are
+// split into runs of digits and non-digits, digit runs are compared
+// numerically, everything else lexically, and a tilde sorts before
+// everything, even the end of the string.
+func compareVersions(a, b string) int {
+	for a != "" || b != "" {
+		var ta, tb string
+
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			} else if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		ta, a = versionToken(a)
+		tb, b = versionToken(b)
+
+		if ta == tb {
+			continue
+		} else if ta == "" {
+			return -1
+		} else if tb == "" {
+			return 1
+		}
+
+		var (
+			na, errA = strconv.ParseUint(ta, 10, 64)
+			nb, errB = strconv.ParseUint(tb, 10, 64)
+		)
+
+		switch {
+		case errA == nil && errB == nil:
+			if na < nb {
+				return -1
+			} else if na > nb {
+				return 1
+			}
+		case errA == nil:
+			// Numbers sort after letters.
+			return 1
+		case errB == nil:
+			return -1
+		case ta < tb:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	return 0
+} // func compareVersions(a, b string) int
+
+// versionToken splits off the first run of digits or letters from a version
+// string, skipping separators.
+func versionToken(s string) (string, string) {
+	s = strings.TrimLeft(s, ".-_+:,")
+
+	if s == "" || s[0] == '~' {
+		return "", s
+	}
+
+	var (
+		i     int
+		digit = isDigit(s[0])
+	)
+
+	for i < len(s) && s[i] != '~' && !strings.ContainsRune(".-_+:,", rune(s[i])) && isDigit(s[i]) == digit {
+		i++
+	}
+
+	return s[:i], s[i:]
+} // func versionToken(s string) (string, string)
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+} // func isDigit(c byte) bool
+
+var patSize = regexp.MustCompile(`^([\d.,]+)\s*([kKMGT]?)(i?)B?$`)
+
+// parseSize parses a human-readable size as printed by the various package
+// managers, e.g. "12.3 MiB", "41 M", "100 k" or "4,5 MB".
+// Units with a "B" but without an "i", like MB, are taken to be powers of
+// 1000, all others powers of 1024. If s is not a size, it returns nil.
+func parseSize(s string) *int64 {
+	var m = patSize.FindStringSubmatch(strings.TrimSpace(s))
+
+	if m == nil {
+		return nil
+	}
+
+	var (
+		err  error
+		num  float64
+		base = 1024.0
+		mult = 1.0
+	)
+
+	if num, err = strconv.ParseFloat(normalizeNumber(m[1]), 64); err != nil {
+		return nil
+	} else if m[3] == "" && strings.HasSuffix(s, "B") && m[2] != "" {
+		base = 1000.0
+	}
+
+	switch strings.ToUpper(m[2]) {
+	case "K":
+		mult = base
+	case "M":
+		mult = base * base
+	case "G":
+		mult = base * base * base
+	case "T":
+		mult = base * base * base * base
+	}
+
+	return newSize(int64(num * mult))
+} // func parseSize(s string) *int64
+
+// newSize returns a pointer to a copy of n, for the sizes in a Plan or a
+// PackageInfo.
+func newSize(n int64) *int64 {
+	return &n
+} // func newSize(n int64) *int64
+
+// negateSize flips the sign of a size, if it is known.
+func negateSize(n *int64) *int64 {
+	if n != nil {
+		*n = -*n
+	}
+
+	return n
+} // func negateSize(n *int64) *int64
+
+// normalizeNumber converts a number that may contain commas as decimal or
+// thousands separators into a form strconv.ParseFloat accepts.
+// If the number contains both, the last one is the decimal separator. A single
+// comma followed by exactly three digits is taken to be a thousands separator,
+// as in "1,234 kB", which is ambiguous, but a lot more common.
+func normalizeNumber(s string) string {
+	var (
+		comma = strings.LastIndexByte(s, ',')
+		dot   = strings.LastIndexByte(s, '.')
+	)
+
+	switch {
+	case comma == -1:
+		return s
+	case dot > comma:
+		return strings.ReplaceAll(s, ",", "")
+	case dot != -1:
+		return strings.ReplaceAll(strings.ReplaceAll(s, ".", ""), ",", ".")
+	case strings.Count(s, ",") > 1 || len(s)-comma-1 == 3:
+		return strings.ReplaceAll(s, ",", "")
+	default:
+		return strings.Replace(s, ",", ".", 1)
+	}
+} // func normalizeNumber(s string) string
@@ -0,0 +1,145 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/progress.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/blicero/pkman/backend/stage"
+)
+
+// Progress is a line of output from a package manager performing a
+// transaction, along with what we made of it.
+// Stage is stage.Output for lines we do not understand. For the others,
+// Package names the package the line is about. Done and Total count the
+// packages acted on so far and altogether, or are 0 if we cannot tell.
+type Progress struct {
+	Time    time.Time `json:"time"`
+	Stage   stage.ID  `json:"stage"`
+	Package string    `json:"package,omitempty"`
+	Done    int       `json:"done,omitempty"`
+	Total   int       `json:"total,omitempty"`
+	Line    string    `json:"line"`
+}
+
+// ProgressFunc receives the Progress of a transaction, one line at a time,
+// while the package manager runs.
+type ProgressFunc func(*Progress)
+
+type progressKey struct{}
+
+// WithProgress returns a Context that makes the PkgManager report the
+// progress of the operations that change the system to fn.
+// fn is called from the goroutine running the package manager, so it should
+// not take long.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+} // func WithProgress(ctx context.Context, fn ProgressFunc) context.Context
+
+// progressFunc returns the ProgressFunc attached to the Context, or nil.
+func progressFunc(ctx context.Context) ProgressFunc {
+	var fn, _ = ctx.Value(progressKey{}).(ProgressFunc)
+
+	return fn
+} // func progressFunc(ctx context.Context) ProgressFunc
+
+// progressParser makes sense of a single line of a package manager's
+// output. It returns nil for lines it does not understand.
+// To tell us how many packages a transaction involves, a parser returns a
+// Progress with stage.Output and only the Total set.
+type progressParser func(line string) *Progress
+
+// progressParsers maps the commands that perform transactions to the
+// parsers for their output.
+var progressParsers = map[string]progressParser{
+	cmdAptGet:    parseProgressApt,
+	cmdDnf:       parseProgressDnf,
+	cmdZypper:    parseProgressZypp,
+	cmdPacman:    parseProgressPacman,
+	cmdPkg:       parseProgressPkg,
+	cmdPkgAdd:    parseProgressPkgAdd,
+	cmdPkgDelete: parseProgressPkgDelete,
+}
+
+// progressWriter splits a command's output into lines and reports each of
+// them, parsed as far as we can, to a ProgressFunc.
+// Package managers that draw progress bars overwrite the current line with
+// a carriage return, so a carriage return ends a line, too.
+type progressWriter struct {
+	fn          ProgressFunc
+	parse       progressParser
+	buf         []byte
+	done, total int
+}
+
+func newProgressWriter(fn ProgressFunc, path string) *progressWriter {
+	return &progressWriter{
+		fn:    fn,
+		parse: progressParsers[path],
+	}
+} // func newProgressWriter(fn ProgressFunc, path string) *progressWriter
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	for {
+		var idx = bytes.IndexAny(w.buf, "\r\n")
+
+		if idx < 0 {
+			break
+		}
+
+		w.emit(string(w.buf[:idx]))
+		w.buf = w.buf[idx+1:]
+	}
+
+	return len(p), nil
+} // func (w *progressWriter) Write(p []byte) (int, error)
+
+// Flush reports the last line, if the command did not end it.
+func (w *progressWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.emit(string(w.buf))
+		w.buf = nil
+	}
+} // func (w *progressWriter) Flush()
+
+func (w *progressWriter) emit(line string) {
+	var p *Progress
+
+	if line = strings.TrimRight(line, " \t"); strings.TrimSpace(line) == "" {
+		return
+	} else if w.parse != nil {
+		p = w.parse(line)
+	}
+
+	if p == nil {
+		p = &Progress{Stage: stage.Output}
+	}
+
+	p.Time = time.Now()
+	p.Line = line
+
+	switch p.Stage {
+	case stage.Output:
+		if p.Total > 0 {
+			w.total, p.Total = p.Total, 0
+		}
+	case stage.Download:
+	default:
+		// If the package manager does not count for us, we do.
+		if p.Done == 0 {
+			w.done++
+			p.Done, p.Total = w.done, w.total
+		}
+	}
+
+	w.fn(p)
+} // func (w *progressWriter) emit(line string)
@@ -0,0 +1,298 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/record.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReplayBackend is the name GetPkgManager knows the replay backend by,
+// followed by a colon and the directory holding a recording, e.g.
+// replay:/tmp/bug-4711.
+const ReplayBackend = "replay"
+
+// manifestFile is the file in a recording's directory that describes the
+// system it was made on.
+const manifestFile = "manifest.json"
+
+// ErrNotRecorded is returned by a ReplayRunner for commands that are not part
+// of the recording.
+var ErrNotRecorded = errors.New("command was not recorded")
+
+// Manifest describes the system a recording was made on.
+// Synthetic recordings were written by hand, after the documented output of
+// the package manager, rather than captured by a RecordingRunner. They have
+// no Recorded time, and only show that a backend copes with the output we
+// expect, not with what the tool really prints.
+type Manifest struct {
+	System    string    `json:"system"`
+	Release   string    `json:"release"`
+	Recorded  time.Time `json:"recorded"`
+	Synthetic bool      `json:"synthetic,omitempty"`
+}
+
+// Recording is a single command captured by a RecordingRunner, with what it
+// wrote and how it exited.
+// Error is set if the command could not be run at all, or was stopped, in
+// which case the output is empty.
+type Recording struct {
+	Seq        int      `json:"seq"`
+	Argv       []string `json:"argv"`
+	Env        []string `json:"env,omitempty"`
+	Privileged bool     `json:"privileged,omitempty"`
+	Stdout     string   `json:"stdout"`
+	Stderr     string   `json:"stderr"`
+	ExitCode   int      `json:"exit_code"`
+	Error      string   `json:"error,omitempty"`
+}
+
+func (rec *Recording) key() string {
+	return argvKey(rec.Argv)
+} // func (rec *Recording) key() string
+
+// argvKey returns the key a command line is recorded under. Joining the
+// arguments with spaces would not do, as arguments may contain spaces
+// themselves, so we use the JSON encoding of the list instead.
+func argvKey(argv []string) string {
+	var raw, _ = json.Marshal(argv)
+
+	return string(raw)
+} // func argvKey(argv []string) string
+
+// RecordingRunner runs commands via another Runner, and saves each command,
+// its output and exit status to a file in a directory, so a ReplayRunner can
+// serve them back later.
+type RecordingRunner struct {
+	r    Runner
+	dir  string
+	lock sync.Mutex
+	seq  int
+}
+
+// NewRecordingRunner creates a RecordingRunner that runs commands via r and
+// records them in dir, which is created if it does not exist. system and
+// release identify the system we are running on, so the recording can be
+// replayed with the matching backend.
+// If dir holds a recording already, the new commands are added to it, and
+// its manifest is kept as it is.
+func NewRecordingRunner(r Runner, dir, system, release string) (*RecordingRunner, error) {
+	var (
+		err   error
+		raw   []byte
+		files []string
+		rr    = &RecordingRunner{r: r, dir: dir}
+		mpath = filepath.Join(dir, manifestFile)
+		m     = Manifest{
+			System:   system,
+			Release:  release,
+			Recorded: time.Now(),
+		}
+	)
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Cannot create %s: %w", dir, err)
+	} else if _, err = os.Stat(mpath); errors.Is(err, os.ErrNotExist) {
+		if raw, err = json.MarshalIndent(&m, "", "  "); err != nil {
+			return nil, err
+		} else if err = os.WriteFile(mpath, raw, 0644); err != nil {
+			return nil, fmt.Errorf("Cannot write manifest: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("Cannot read manifest: %w", err)
+	}
+
+	if files, err = filepath.Glob(filepath.Join(dir, "[0-9]*.json")); err != nil {
+		return nil, err
+	}
+
+	for _, path := range files {
+		var seq int
+
+		if seq, err = strconv.Atoi(strings.TrimSuffix(filepath.Base(path), ".json")); err == nil && seq > rr.seq {
+			rr.seq = seq
+		}
+	}
+
+	return rr, nil
+} // func NewRecordingRunner(r Runner, dir, system, release string) (*RecordingRunner, error)
+
+// Run runs the command via the wrapped Runner and records it.
+// Failing to save the recording is an error, even if the command itself
+// succeeded, as a recording with gaps is not worth much. If the command
+// failed, too, its error takes precedence.
+func (r *RecordingRunner) Run(ctx context.Context, c *Command) (*Result, error) {
+	var (
+		res, err = r.r.Run(ctx, c)
+		rec      = &Recording{
+			Argv:       append([]string{c.Path}, c.Args...),
+			Env:        c.Env,
+			Privileged: c.Privileged,
+			ExitCode:   -1,
+		}
+	)
+
+	if res != nil {
+		rec.Stdout = res.Stdout
+		rec.Stderr = res.Stderr
+		rec.ExitCode = res.ExitCode
+	}
+
+	var cerr *CmdError
+
+	if err != nil && !errors.As(err, &cerr) {
+		rec.Error = err.Error()
+	}
+
+	if serr := r.save(rec); serr != nil && err == nil {
+		return res, serr
+	}
+
+	return res, err
+} // func (r *RecordingRunner) Run(ctx context.Context, c *Command) (*Result, error)
+
+func (r *RecordingRunner) save(rec *Recording) error {
+	var (
+		err  error
+		raw  []byte
+		path string
+	)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.seq++
+	rec.Seq = r.seq
+	path = filepath.Join(r.dir, fmt.Sprintf("%04d.json", rec.Seq))
+
+	if raw, err = json.MarshalIndent(rec, "", "  "); err != nil {
+		return err
+	} else if err = os.WriteFile(path, raw, 0644); err != nil {
+		return fmt.Errorf("Cannot record %s: %w", strings.Join(rec.Argv, " "), err)
+	}
+
+	return nil
+} // func (r *RecordingRunner) save(rec *Recording) error
+
+// ReplayRunner serves the commands captured by a RecordingRunner instead of
+// running them.
+// Commands are looked up by their arguments. If a command was recorded more
+// than once, the recordings are served in the order they were made, and the
+// last one is repeated once they run out. Commands that were not recorded
+// fail with ErrNotRecorded.
+type ReplayRunner struct {
+	Manifest Manifest
+	lock     sync.Mutex
+	recs     map[string][]*Recording
+}
+
+// NewReplayRunner loads the recording in the given directory.
+func NewReplayRunner(dir string) (*ReplayRunner, error) {
+	var (
+		err   error
+		raw   []byte
+		files []string
+		r     = &ReplayRunner{recs: make(map[string][]*Recording)}
+	)
+
+	if raw, err = os.ReadFile(filepath.Join(dir, manifestFile)); err != nil {
+		return nil, fmt.Errorf("Cannot read manifest of recording %s: %w", dir, err)
+	} else if err = json.Unmarshal(raw, &r.Manifest); err != nil {
+		return nil, fmt.Errorf("Cannot parse manifest of recording %s: %w", dir, err)
+	} else if files, err = filepath.Glob(filepath.Join(dir, "[0-9]*.json")); err != nil {
+		return nil, err
+	}
+
+	var list = make([]*Recording, 0, len(files))
+
+	for _, path := range files {
+		var rec = new(Recording)
+
+		if raw, err = os.ReadFile(path); err != nil {
+			return nil, err
+		} else if err = json.Unmarshal(raw, rec); err != nil {
+			return nil, fmt.Errorf("Cannot parse %s: %w", path, err)
+		} else if len(rec.Argv) == 0 {
+			return nil, fmt.Errorf("%s does not contain a command", path)
+		}
+
+		list = append(list, rec)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Seq < list[j].Seq })
+
+	for _, rec := range list {
+		var key = rec.key()
+
+		r.recs[key] = append(r.recs[key], rec)
+	}
+
+	return r, nil
+} // func NewReplayRunner(dir string) (*ReplayRunner, error)
+
+// Run serves the recording of the given command.
+func (r *ReplayRunner) Run(ctx context.Context, c *Command) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s was not started: %w", c.Path, err)
+	}
+
+	var rec = r.next(argvKey(append([]string{c.Path}, c.Args...)))
+
+	if rec == nil {
+		return nil, fmt.Errorf("%s: %w", c, ErrNotRecorded)
+	} else if rec.Error != "" && rec.ExitCode == -1 {
+		return nil, errors.New(rec.Error)
+	}
+
+	var res = &Result{
+		Stdout:   rec.Stdout,
+		Stderr:   rec.Stderr,
+		ExitCode: rec.ExitCode,
+	}
+
+	if c.Output != nil {
+		io.WriteString(c.Output, res.Stdout) // nolint: errcheck
+		io.WriteString(c.Output, res.Stderr) // nolint: errcheck
+	}
+
+	if rec.Error != "" {
+		return res, errors.New(rec.Error)
+	} else if res.ExitCode != 0 {
+		return res, newCmdError(c, res, nil)
+	}
+
+	return res, nil
+} // func (r *ReplayRunner) Run(ctx context.Context, c *Command) (*Result, error)
+
+// next returns the next recording for the command line with the given key,
+// or nil if there is none.
+func (r *ReplayRunner) next(key string) *Recording {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var list = r.recs[key]
+
+	switch len(list) {
+	case 0:
+		return nil
+	case 1:
+		return list[0]
+	default:
+		r.recs[key] = list[1:]
+		return list[0]
+	}
+} // func (r *ReplayRunner) next(key string) *Recording
@@ -0,0 +1,163 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/rpm.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Both zypper and dnf sit on top of rpm, and for some queries on installed
+// packages, rpm is the most direct way to get at the data.
+
+const cmdRpm = "rpm"
+
+const fmtRpmList = `%{NAME}\t%{VERSION}-%{RELEASE}\t%{SUMMARY}\n`
+
+var patRpmName = regexp.MustCompile(`(?m)^([\w@.+-]+)[ \t]*$`)
+
+func rpmListInstalled(ctx context.Context, r Runner, lg *log.Logger) ([]Package, error) {
+	var (
+		err    error
+		output string
+		pkList []Package
+	)
+
+	if output, _, err = runCommand(ctx, r, cmdRpm, "-qa", "--qf", fmtRpmList); err != nil {
+		lg.Printf("[ERROR] Cannot list installed packages: %s\n",
+			err.Error())
+		return nil, err
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		var fields = strings.SplitN(line, "\t", 3)
+
+		if len(fields) != 3 || fields[0] == "gpg-pubkey" {
+			continue
+		}
+
+		pkList = append(pkList, Package{
+			Name:        fields[0],
+			Version:     fields[1],
+			Description: fields[2],
+		})
+	}
+
+	return pkList, nil
+} // func rpmListInstalled(ctx context.Context, r Runner, lg *log.Logger) ([]Package, error)
+
+// rpmCapabilities returns the capabilities the given package requires or
+// provides, depending on the flag passed in, which must be either --requires
+// or --provides.
+func rpmCapabilities(ctx context.Context, r Runner, lg *log.Logger, flag, name string) ([]string, error) {
+	var (
+		err    error
+		output string
+		caps   []string
+	)
+
+	if output, _, err = runCommand(ctx, r, cmdRpm, "-q", flag, name); err != nil {
+		lg.Printf("[ERROR] Cannot query %s of %s: %s\n",
+			flag,
+			name,
+			err.Error())
+		return nil, err
+	}
+
+	for _, line := range uniqueLines(output) {
+		var c = strings.Fields(line)[0]
+
+		// rpmlib(...) capabilities are provided by rpm itself, not by
+		// any package.
+		if !strings.HasPrefix(c, "rpmlib(") {
+			caps = append(caps, c)
+		}
+	}
+
+	return caps, nil
+} // func rpmCapabilities(ctx context.Context, r Runner, lg *log.Logger, flag, name string) ([]string, error)
+
+// rpmResolve runs rpm with the given query flag (--whatprovides or
+// --whatrequires) on the list of capabilities and returns the names of the
+// packages it finds, excluding the package given as self.
+func rpmResolve(ctx context.Context, r Runner, lg *log.Logger, flag, self string, caps []string) ([]string, error) {
+	var (
+		err    error
+		output string
+		names  []string
+		args   = make([]string, 0, len(caps)+4)
+	)
+
+	if len(caps) == 0 {
+		return nil, nil
+	}
+
+	args = append(args, "-q", flag, "--qf", `%{NAME}\n`)
+	args = append(args, caps...)
+
+	// rpm exits with a non-zero status if any of the capabilities cannot be
+	// resolved, which is not an error from our point of view.
+	if output, _, err = runCommand(ctx, r, cmdRpm, args...); err != nil {
+		if exitCode(err) == -1 {
+			return nil, err
+		}
+	}
+
+	for _, n := range submatches(patRpmName, output) {
+		if n != self {
+			names = append(names, n)
+		}
+	}
+
+	return names, nil
+} // func rpmResolve(ctx context.Context, r Runner, lg *log.Logger, flag, self string, caps []string) ([]string, error)
+
+func rpmDepends(ctx context.Context, r Runner, lg *log.Logger, name string) ([]string, error) {
+	var (
+		err  error
+		caps []string
+	)
+
+	if caps, err = rpmCapabilities(ctx, r, lg, "--requires", name); err != nil {
+		return nil, err
+	}
+
+	return rpmResolve(ctx, r, lg, "--whatprovides", name, caps)
+} // func rpmDepends(ctx context.Context, r Runner, lg *log.Logger, name string) ([]string, error)
+
+func rpmRequiredBy(ctx context.Context, r Runner, lg *log.Logger, name string) ([]string, error) {
+	var (
+		err  error
+		caps []string
+	)
+
+	if caps, err = rpmCapabilities(ctx, r, lg, "--provides", name); err != nil {
+		return nil, err
+	}
+
+	return rpmResolve(ctx, r, lg, "--whatrequires", name, caps)
+} // func rpmRequiredBy(ctx context.Context, r Runner, lg *log.Logger, name string) ([]string, error)
+
+// splitNEVRA splits a package name of the form name-[epoch:]version-release.arch
+// into the name and the version-release.
+func splitNEVRA(s string) (string, string) {
+	var idx int
+
+	if idx = strings.LastIndexByte(s, '.'); idx > strings.LastIndexByte(s, '-') {
+		s = s[:idx]
+	}
+
+	if idx = strings.LastIndexByte(s, '-'); idx <= 0 {
+		return s, ""
+	} else if idx = strings.LastIndexByte(s[:idx], '-'); idx <= 0 {
+		return s, ""
+	}
+
+	return s[:idx], s[idx+1:]
+} // func splitNEVRA(s string) (string, string)
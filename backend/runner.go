@@ -0,0 +1,312 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/runner.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Command is a native command for a Runner to run.
+type Command struct {
+	// Path is the command to run. Unless it is an absolute path, the
+	// Runner looks it up.
+	Path string
+	Args []string
+	// Env holds additional environment variables, in the form KEY=value.
+	Env []string
+	// Privileged commands change the system and run with root
+	// privileges, see SetEscalation.
+	Privileged bool
+	// Output, if it is not nil, receives everything the command writes to
+	// stdout and stderr while it runs.
+	Output io.Writer
+}
+
+func (c *Command) String() string {
+	if len(c.Args) == 0 {
+		return c.Path
+	}
+
+	return c.Path + " " + strings.Join(c.Args, " ")
+} // func (c *Command) String() string
+
+// Result holds what a command wrote to stdout and stderr, and its exit status.
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// Runner runs native commands on behalf of the backends.
+// Run returns the Result even if the command fails. If the command exits
+// with a non-zero status, the error is a *CmdError. If the context is
+// cancelled while the command runs, the command is stopped, and the error
+// wraps the context's error.
+type Runner interface {
+	Run(context.Context, *Command) (*Result, error)
+}
+
+// searchDirs are where we look for commands that are not in $PATH. pkg_add
+// and friends live in /usr/sbin, which is usually not in a user's $PATH.
+var searchDirs = []string{
+	"/usr/local/sbin",
+	"/usr/local/bin",
+	"/usr/sbin",
+	"/usr/bin",
+	"/sbin",
+	"/bin",
+}
+
+// localeEnv makes the native tools speak English and format their output the
+// same way everywhere, so our parsers understand them. C.UTF-8 keeps
+// non-ASCII package descriptions intact, systems that do not have it fall
+// back to C. LANGUAGE would override LC_ALL for gettext's translations.
+var localeEnv = []string{"LC_ALL=C.UTF-8", "LANGUAGE="}
+
+// ExecRunner is the Runner that runs commands on the local system.
+// It runs all commands in the C locale, see localeEnv.
+type ExecRunner struct {
+	log   *log.Logger
+	lock  sync.Mutex
+	paths map[string]string
+}
+
+// NewExecRunner creates an ExecRunner that logs to the given Logger.
+func NewExecRunner(lg *log.Logger) *ExecRunner {
+	return &ExecRunner{
+		log:   lg,
+		paths: make(map[string]string),
+	}
+} // func NewExecRunner(lg *log.Logger) *ExecRunner
+
+// LookPath returns the absolute path of the given command. It looks in
+// $PATH first, then in the usual system directories.
+func (r *ExecRunner) LookPath(name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if p, ok := r.paths[name]; ok {
+		return p, nil
+	} else if p, err := exec.LookPath(name); err == nil {
+		r.paths[name] = p
+		return p, nil
+	}
+
+	for _, dir := range searchDirs {
+		var p = filepath.Join(dir, name)
+
+		if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0 {
+			r.paths[name] = p
+			return p, nil
+		}
+	}
+
+	return "", fmt.Errorf("Cannot find %s: %w", name, exec.ErrNotFound)
+} // func (r *ExecRunner) LookPath(name string) (string, error)
+
+// Run runs the command and waits for it to finish.
+func (r *ExecRunner) Run(ctx context.Context, c *Command) (*Result, error) {
+	var (
+		err            error
+		path           string
+		args, env      []string
+		bufOut, bufErr bytes.Buffer
+		cmd            *exec.Cmd
+	)
+
+	if err = ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s was not started: %w", c.Path, err)
+	} else if path, err = r.LookPath(c.Path); err != nil {
+		r.log.Printf("[ERROR] %s\n", err.Error())
+		return nil, err
+	}
+
+	args = c.Args
+	env = append(append([]string{}, localeEnv...), c.Env...)
+
+	if c.Privileged {
+		path, args, env = privilegedCommand(env, path, args...)
+		r.log.Printf("[INFO] Running %s %s\n",
+			path,
+			strings.Join(args, " "))
+	}
+
+	cmd = exec.Command(path, args...)
+	cmd.Env = append(os.Environ(), env...)
+
+	if c.Output != nil {
+		// stdout and stderr are copied by separate goroutines.
+		var out = &lockedWriter{w: c.Output}
+
+		cmd.Stdout = io.MultiWriter(&bufOut, out)
+		cmd.Stderr = io.MultiWriter(&bufErr, out)
+	} else {
+		cmd.Stdout = &bufOut
+		cmd.Stderr = &bufErr
+	}
+
+	if err = cmd.Start(); err != nil {
+		r.log.Printf("[ERROR] Failed to run %s: %s\n",
+			path,
+			err.Error())
+		return nil, err
+	}
+
+	var done = make(chan struct{})
+
+	go stopOnCancel(ctx, cmd, done)
+	err = cmd.Wait()
+	close(done)
+
+	var res = &Result{
+		Stdout:   bufOut.String(),
+		Stderr:   bufErr.String(),
+		ExitCode: cmd.ProcessState.ExitCode(),
+	}
+
+	if ctx.Err() != nil {
+		r.log.Printf("[INFO] %s was stopped: %s\n",
+			path,
+			ctx.Err().Error())
+		return res, fmt.Errorf("%s was stopped: %w", path, ctx.Err())
+	} else if err != nil {
+		var xerr *exec.ExitError
+
+		if !errors.As(err, &xerr) {
+			return res, err
+		}
+
+		err = newCmdError(c, res, err)
+		if c.Privileged {
+			r.log.Printf("[ERROR] %s failed: %s\n%s\n",
+				c,
+				err.Error(),
+				res.Stderr)
+		}
+		return res, err
+	} else if c.Privileged {
+		r.log.Printf("[DEBUG] Output of %s:\n%s\n",
+			path,
+			res.Stdout)
+	}
+
+	return res, nil
+} // func (r *ExecRunner) Run(ctx context.Context, c *Command) (*Result, error)
+
+// killDelay is how long a command gets to shut down after we asked it to,
+// before we kill it.
+const killDelay = 10 * time.Second
+
+// stopOnCancel stops the command when the context is cancelled, until done
+// is closed.
+// A package manager in the middle of a transaction deserves the chance to
+// clean up after itself, so the command gets a SIGTERM first, and only if
+// that does not help, a SIGKILL. If the command runs via sudo or doas, they
+// pass the signal on.
+func stopOnCancel(ctx context.Context, cmd *exec.Cmd, done <-chan struct{}) {
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	cmd.Process.Signal(syscall.SIGTERM) // nolint: errcheck
+
+	select {
+	case <-done:
+	case <-time.After(killDelay):
+		cmd.Process.Kill() // nolint: errcheck
+	}
+} // func stopOnCancel(ctx context.Context, cmd *exec.Cmd, done <-chan struct{})
+
+// lockedWriter serializes writes to a Writer.
+type lockedWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.w.Write(p)
+} // func (l *lockedWriter) Write(p []byte) (int, error)
+
+// runCommand runs the given command and returns what it wrote to stdout
+// and stderr.
+// If the command exits with a non-zero status, the error is a *CmdError,
+// and the output is returned nonetheless, so the caller can decide what to make
+// of it.
+func runCommand(ctx context.Context, r Runner, path string, args ...string) (string, string, error) {
+	return runCommandEnv(ctx, r, nil, path, args...)
+} // func runCommand(ctx context.Context, r Runner, path string, args ...string) (string, string, error)
+
+// runCommandEnv works like runCommand, but adds the given variables, in the
+// form KEY=value, to the command's environment.
+func runCommandEnv(ctx context.Context, r Runner, env []string, path string, args ...string) (string, string, error) {
+	return run(ctx, r, &Command{Path: path, Args: args, Env: env})
+} // func runCommandEnv(ctx context.Context, r Runner, env []string, path string, args ...string) (string, string, error)
+
+// runPrivileged works like runCommandEnv, but runs the command with root
+// privileges.
+func runPrivileged(ctx context.Context, r Runner, env []string, path string, args ...string) (string, string, error) {
+	return run(ctx, r, &Command{Path: path, Args: args, Env: env, Privileged: true})
+} // func runPrivileged(ctx context.Context, r Runner, env []string, path string, args ...string) (string, string, error)
+
+// runTransaction runs a command that changes the state of the system, with
+// root privileges, and reports its progress to the ProgressFunc attached to
+// the Context, if there is one. If the operation is recorded in the history,
+// the command line is, too.
+// The caller is responsible for passing whatever flags the package manager
+// needs to not ask any questions.
+func runTransaction(ctx context.Context, r Runner, env []string, path string, args ...string) error {
+	var c = &Command{Path: path, Args: args, Env: env, Privileged: true}
+
+	logCommand(ctx, c)
+
+	if fn := progressFunc(ctx); fn != nil {
+		var w = newProgressWriter(fn, path)
+
+		c.Output = w
+		defer w.Flush()
+	}
+
+	var stdout, stderr, err = run(ctx, r, c)
+
+	logOutput(ctx, stdout, stderr)
+
+	return err
+} // func runTransaction(ctx context.Context, r Runner, env []string, path string, args ...string) error
+
+func run(ctx context.Context, r Runner, c *Command) (string, string, error) {
+	var (
+		err error
+		res *Result
+	)
+
+	if res, err = r.Run(ctx, c); res == nil {
+		return "", "", err
+	}
+
+	return res.Stdout, res.Stderr, err
+} // func run(ctx context.Context, r Runner, c *Command) (string, string, error)
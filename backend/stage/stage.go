@@ -0,0 +1,45 @@
+// /home/krylon/go/src/github.com/blicero/pkman/backend/stage/stage.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+//go:generate stringer -type=ID
+
+// Package stage provides symbolic constants for the progress a package
+// manager reports while it performs a transaction.
+package stage
+
+// ID is the type used to represent stages.
+type ID uint8
+
+// Output is a line of output we could not make sense of. The other stages
+// are the package manager fetching or acting on a single package.
+const (
+	Output ID = iota
+	Download
+	Install
+	Upgrade
+	Downgrade
+	Remove
+	Reinstall
+)
+
+// AllStages returns a slice of all defined values of ID.
+func AllStages() []ID {
+	return []ID{
+		Output,
+		Download,
+		Install,
+		Upgrade,
+		Downgrade,
+		Remove,
+		Reinstall,
+	}
+} // func AllStages() []ID
+
+// MarshalText renders the ID by its name, so stages show up readably in
+// JSON or YAML output.
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+} // func (id ID) MarshalText() ([]byte, error)
@@ -101,3 +101,117 @@ func parseOSRelease(path string) (string, string, error) {
 
 	return name, version, err
 } // func DetectOSVersion() (string, string, error)
+
+// upgradeNames returns the names of the packages in a list of pending
+// upgrades.
+func upgradeNames(upList []PendingUpgrade) []string {
+	var names = make([]string, len(upList))
+
+	for i, u := range upList {
+		names[i] = u.Name
+	}
+
+	return names
+} // func upgradeNames(upList []PendingUpgrade) []string
+
+// splitNameVersion splits a string of the form name-version, as used by the
+// package tools on the BSDs, into the name and the version. The version is
+// assumed to start after the last dash that is followed by a digit.
+func splitNameVersion(s string) (string, string) {
+	for i := len(s) - 2; i > 0; i-- {
+		if s[i] == '-' && s[i+1] >= '0' && s[i+1] <= '9' {
+			return s[:i], s[i+1:]
+		}
+	}
+
+	return s, ""
+} // func splitNameVersion(s string) (string, string)
+
+// uniqueLines returns the non-empty lines of the given string, with leading and
+// trailing whitespace removed, and with duplicates dropped. The order in which
+// lines appear is preserved.
+func uniqueLines(s string) []string {
+	var (
+		lines = strings.Split(s, "\n")
+		seen  = make(map[string]bool, len(lines))
+		res   = make([]string, 0, len(lines))
+	)
+
+	for _, l := range lines {
+		l = strings.TrimSpace(l)
+		if l == "" || seen[l] {
+			continue
+		}
+		seen[l] = true
+		res = append(res, l)
+	}
+
+	return res
+} // func uniqueLines(s string) []string
+
+// submatches returns the first capture group of every match of pat in s,
+// without duplicates.
+func submatches(pat *regexp.Regexp, s string) []string {
+	var (
+		matches = pat.FindAllStringSubmatch(s, -1)
+		seen    = make(map[string]bool, len(matches))
+		res     = make([]string, 0, len(matches))
+	)
+
+	for _, m := range matches {
+		if seen[m[1]] {
+			continue
+		}
+		seen[m[1]] = true
+		res = append(res, m[1])
+	}
+
+	return res
+} // func submatches(pat *regexp.Regexp, s string) []string
+
+var patKeyValue = regexp.MustCompile(`^(\S[^:]*?)\s*:(?:\s+(.*))?$`)
+
+// parseKeyValues parses the "Key : Value" format most package managers use
+// to display information on a package. Lines that start with whitespace
+// continue the value of the previous key. If a key appears more than once,
+// the first value wins.
+func parseKeyValues(s string) map[string]string {
+	var (
+		key    string
+		values = make(map[string]string)
+	)
+
+	for _, line := range strings.Split(s, "\n") {
+		var m []string
+
+		if line == "" {
+			key = ""
+			continue
+		} else if line[0] == ' ' || line[0] == '\t' {
+			if key != "" {
+				values[key] = strings.TrimSpace(values[key] + "\n" + strings.TrimSpace(line))
+			}
+			continue
+		} else if m = patKeyValue.FindStringSubmatch(line); m == nil {
+			key = ""
+			continue
+		} else if _, ok := values[m[1]]; ok {
+			key = ""
+			continue
+		}
+
+		key = m[1]
+		values[key] = strings.TrimSpace(m[2])
+	}
+
+	return values
+} // func parseKeyValues(s string) map[string]string
+
+// firstLine returns the first line of a string.
+func firstLine(s string) string {
+	if idx := strings.IndexByte(s, '\n'); idx != -1 {
+		return s[:idx]
+	}
+
+	return s
+} // func firstLine(s string) string
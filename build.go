@@ -63,19 +63,27 @@ var candidates = map[string][]string{
 		"common",
 		"logdomain",
 		"backend/platform",
+		"backend/stage",
 		"database/query",
 		"database/event",
+		"database/action",
 	},
 	"test": []string{
+		"common",
 		"backend",
+		"backend/conformance",
+		"database",
+		"cli",
 	},
 	"vet": []string{
 		"common",
 		"logdomain",
 		"backend",
 		"backend/platform",
+		"backend/stage",
 		"database/query",
 		"database/event",
+		"database/action",
 		"database",
 	},
 	"lint": []string{
@@ -83,8 +91,10 @@ var candidates = map[string][]string{
 		"logdomain",
 		"backend",
 		"backend/platform",
+		"backend/stage",
 		"database/query",
 		"database/event",
+		"database/action",
 		"database",
 	},
 }
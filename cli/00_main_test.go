@@ -0,0 +1,39 @@
+// /home/krylon/go/src/github.com/blicero/pkman/cli/00_main_test.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/blicero/pkman/common"
+)
+
+// The commands record events in the history database, so we keep them away
+// from the real one.
+func TestMain(m *testing.M) {
+	var (
+		err     error
+		result  int
+		baseDir = time.Now().Format("/tmp/pkman_cli_test_20060102_150405")
+	)
+
+	if err = common.SetBaseDir(baseDir); err != nil {
+		fmt.Printf("Cannot set base directory to %s: %s\n",
+			baseDir,
+			err.Error())
+		os.Exit(1)
+	} else if result = m.Run(); result == 0 {
+		_ = os.RemoveAll(baseDir)
+	} else {
+		fmt.Printf(">>> TEST DIRECTORY: %s\n", baseDir)
+	}
+
+	os.Exit(result)
+} // func TestMain(m *testing.M)
@@ -0,0 +1,196 @@
+// /home/krylon/go/src/github.com/blicero/pkman/cli/01_output_test.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blicero/pkman/backend"
+	"github.com/blicero/pkman/common"
+	"github.com/blicero/pkman/database"
+	"github.com/blicero/pkman/logdomain"
+)
+
+// capture calls fn and returns what it wrote to stdout.
+func capture(t *testing.T, fn func()) []byte {
+	var (
+		err    error
+		r, w   *os.File
+		out    []byte
+		done   = make(chan struct{})
+		stdout = os.Stdout
+	)
+
+	if r, w, err = os.Pipe(); err != nil {
+		t.Fatalf("Cannot create pipe: %s", err.Error())
+	}
+
+	go func() {
+		out, _ = io.ReadAll(r)
+		close(done)
+	}()
+
+	os.Stdout = w
+
+	defer func() { os.Stdout = stdout }()
+
+	fn()
+
+	os.Stdout = stdout
+	w.Close() // nolint: errcheck
+	<-done
+
+	return out
+} // func capture(t *testing.T, fn func()) []byte
+
+// runCaptured runs a command against the fake backend, with logging at its
+// most verbose, and returns what it wrote to stdout.
+// The loggers are created while stdout is captured, so anything they write
+// there ends up in the result.
+func runCaptured(t *testing.T, args ...string) []byte {
+	var out, err = runConfigured(t, common.DefaultConfig(), args...)
+
+	if err != nil {
+		t.Errorf("%s failed: %s", args[0], err.Error())
+	}
+
+	return out
+} // func runCaptured(t *testing.T, args ...string) []byte
+
+// runConfigured works like runCaptured, but with the given configuration,
+// and returns the command's error instead of failing the test.
+func runConfigured(t *testing.T, cfg *common.Config, args ...string) ([]byte, error) {
+	var (
+		cmdErr   error
+		c        = &CLI{cfg: cfg}
+		catalog  = filepath.Join("..", "backend", "testdata", "fake", "demo.yaml")
+		cmd, rem = c.resolve(args)
+	)
+
+	if cmd == nil {
+		t.Fatalf("Unknown command %q", args[0])
+	}
+
+	var out = capture(t, func() {
+		var err error
+
+		c.cfg.LogLevel = "TRACE"
+		c.cfg.ApplyLogLevels()
+
+		if c.log, err = common.GetLogger(logdomain.CLI); err != nil {
+			t.Fatalf("Cannot create logger: %s", err.Error())
+		} else if c.db, err = database.OpenDB(common.DbPath); err != nil {
+			t.Fatalf("Cannot open database: %s", err.Error())
+		} else if c.pk, err = backend.GetPkgManager(backend.FakeBackend+":"+catalog, nil); err != nil {
+			t.Fatalf("Cannot create fake backend: %s", err.Error())
+		}
+
+		defer c.db.Close()
+
+		cmdErr = c.dispatch(context.Background(), cmd, rem)
+	})
+
+	return out, cmdErr
+} // func runConfigured(t *testing.T, cfg *common.Config, args ...string) ([]byte, error)
+
+func TestJSONOutput(t *testing.T) {
+	var (
+		err    error
+		pkList []backend.Package
+		evList []map[string]any
+	)
+
+	// This also records an event, so the history below is not empty.
+	runCaptured(t, "install", "-yes", "emacs")
+
+	if out := runCaptured(t, "search", "-output", "json", "yasr"); !json.Valid(out) {
+		t.Errorf("search did not print valid JSON:\n%s", out)
+	} else if err = json.Unmarshal(out, &pkList); err != nil {
+		t.Errorf("Cannot parse output of search: %s", err.Error())
+	} else if len(pkList) != 1 || pkList[0].Name != "yasr" {
+		t.Errorf("Unexpected search result: %v", pkList)
+	}
+
+	if out := runCaptured(t, "history", "-output", "json"); !json.Valid(out) {
+		t.Errorf("history did not print valid JSON:\n%s", out)
+	} else if err = json.Unmarshal(out, &evList); err != nil {
+		t.Errorf("Cannot parse output of history: %s", err.Error())
+	} else if len(evList) != 1 || evList[0]["type"] != "Add" {
+		t.Errorf("Unexpected history: %v", evList)
+	}
+} // func TestJSONOutput(t *testing.T)
+
+func TestGraphOutput(t *testing.T) {
+	if out := runCaptured(t, "deps", "-graph", "json", "emacs"); !json.Valid(out) {
+		t.Errorf("deps -graph json did not print valid JSON:\n%s", out)
+	}
+
+	if out := runCaptured(t, "deps", "-graph", "dot", "emacs"); !bytes.HasPrefix(out, []byte("digraph")) {
+		t.Errorf("deps -graph dot did not print a DOT graph:\n%s", out)
+	}
+} // func TestGraphOutput(t *testing.T)
+
+// TestUnknownSize checks that sizes the package manager does not tell us
+// about are left out of JSON and YAML and are empty cells in CSV and TSV,
+// rather than some magic number a script might take for a real size.
+func TestUnknownSize(t *testing.T) {
+	var (
+		err  error
+		info = []*backend.PackageInfo{
+			{Package: backend.Package{Name: "mg", Version: "20230406"}},
+		}
+		plan = &backend.Plan{Changes: []backend.Change{}}
+	)
+
+	for _, f := range []string{outJSON, outYAML, outCSV, outTSV} {
+		var c = &CLI{output: f}
+
+		if c.log, err = common.GetLogger(logdomain.CLI); err != nil {
+			t.Fatalf("Cannot create logger: %s", err.Error())
+		}
+
+		var (
+			infoOut = capture(t, func() { c.emitInfo(info) }) // nolint: errcheck
+			planOut = capture(t, func() { c.emitPlan(plan) }) // nolint: errcheck
+		)
+
+		switch f {
+		case outJSON, outYAML:
+			for _, field := range []string{"size", "download_size", "disk_delta"} {
+				if bytes.Contains(infoOut, []byte(field)) || bytes.Contains(planOut, []byte(field)) {
+					t.Errorf("%s output contains unknown %s:\n%s\n%s",
+						f,
+						field,
+						infoOut,
+						planOut)
+				}
+			}
+		default:
+			var (
+				rows [][]string
+				r    = csv.NewReader(bytes.NewReader(infoOut))
+			)
+
+			if f == outTSV {
+				r.Comma = '\t'
+			}
+
+			if rows, err = r.ReadAll(); err != nil {
+				t.Errorf("Cannot parse %s output: %s", f, err.Error())
+			} else if len(rows) != 2 || rows[0][7] != "size" || rows[1][7] != "" {
+				t.Errorf("Unexpected %s output for an unknown size: %v", f, rows)
+			}
+		}
+	}
+} // func TestUnknownSize(t *testing.T)
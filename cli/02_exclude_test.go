@@ -0,0 +1,44 @@
+// /home/krylon/go/src/github.com/blicero/pkman/cli/02_exclude_test.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package cli
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/blicero/pkman/common"
+)
+
+// TestExclude checks that an excluded package is skipped when upgrading,
+// rather than blocking the upgrade of everything else, and that asking for
+// it by name is refused.
+func TestExclude(t *testing.T) {
+	var (
+		err  error
+		out  []byte
+		cfg  = common.DefaultConfig()
+		plan struct {
+			Changes []struct {
+				Name string `json:"name"`
+			} `json:"changes"`
+		}
+	)
+
+	cfg.Exclude = []string{"tz*"}
+
+	if out, err = runConfigured(t, cfg, "upgrade", "-yes", "-output", "json"); err != nil {
+		t.Errorf("upgrade failed: %s", err.Error())
+	} else if err = json.Unmarshal(out, &plan); err != nil {
+		t.Errorf("Cannot parse output of upgrade: %s\n%s", err.Error(), out)
+	} else if len(plan.Changes) != 1 || plan.Changes[0].Name != "libc6" {
+		t.Errorf("Expected an upgrade of libc6 only, got %v", plan.Changes)
+	}
+
+	if _, err = runConfigured(t, cfg, "install", "-yes", "tzdata"); err == nil {
+		t.Error("Installing an excluded package should have failed")
+	}
+} // func TestExclude(t *testing.T)
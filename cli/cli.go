@@ -8,34 +8,68 @@
 package cli
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/blicero/krylib"
 	"github.com/blicero/pkman/backend"
+	"github.com/blicero/pkman/backend/stage"
 	"github.com/blicero/pkman/common"
 	"github.com/blicero/pkman/database"
+	"github.com/blicero/pkman/database/action"
+	"github.com/blicero/pkman/database/event"
 	"github.com/blicero/pkman/logdomain"
 )
 
 // CLI is the nexus of the user interface.
 type CLI struct {
-	log *log.Logger
-	db  *database.Database
-	pk  backend.PkgManager
+	log         *log.Logger
+	db          *database.Database
+	pk          backend.PkgManager
+	cfg         *common.Config
+	output      string
+	lockTimeout time.Duration
+	timeout     time.Duration
+	recordDir   string
+	verbose     bool
 }
 
 // Open creates a new CLI instance.
+// It parses the global flags and loads the configuration, so it has to be
+// called before anything else touches the command line.
 func Open() (*CLI, error) {
 	var (
-		err error
-		c   = new(CLI)
+		err                  error
+		cfgPath, backendName string
+		c                    = new(CLI)
 	)
 
+	flag.StringVar(&cfgPath, "config", "", "Read the user configuration from this file")
+	flag.StringVar(&backendName, "backend", "", "Use the package manager of this system instead of detecting it")
+	flag.StringVar(&c.recordDir, "record", "", "Record the package manager commands pkman runs, and their output, in this directory")
+	flag.Usage = func() { printCommands(os.Stderr) }
+	flag.Parse()
+
+	if c.cfg, err = common.LoadConfig(cfgPath); err != nil {
+		return nil, err
+	} else if backendName != "" {
+		c.cfg.Backend = backendName
+	}
+
+	c.cfg.ApplyLogLevels()
+
 	if c.log, err = common.GetLogger(logdomain.CLI); err != nil {
-		fmt.Fprintf(os.Stdout, "Failed to open Logger for CLI: %s\n",
+		fmt.Fprintf(os.Stderr, "Failed to open Logger for CLI: %s\n",
 			err.Error())
 		return nil, err
 	} else if c.db, err = database.OpenDB(common.DbPath); err != nil {
@@ -48,68 +82,983 @@ func Open() (*CLI, error) {
 	return c, nil
 } // func Open() (*CLI, error)
 
-func (c *CLI) Run() {
+// Run detects the package manager, and runs the command given on the
+// command line.
+// SIGINT and SIGTERM cancel the command, stopping any package manager
+// command that is still running.
+func (c *CLI) Run() error {
 	var (
-		err               error
-		name, release, op string
-		args              []string
-		pk                backend.PkgManager
+		err           error
+		name, release string
+		args          []string
+		cmd           *command
+		ctx, stop     = signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	)
 
-	if name, release, err = backend.DetectOS(); err != nil {
+	defer stop()
+
+	args = flag.Args()
+
+	if len(args) == 0 {
+		printCommands(os.Stderr)
+		return nil
+	} else if cmd, args = c.resolve(args); cmd == nil {
+		printCommands(os.Stderr)
+		return fmt.Errorf("Unknown command %q", args[0])
+	} else if cmd.name == "help" {
+		// help does not need a package manager
+		return c.dispatch(ctx, cmd, args)
+	}
+
+	if c.cfg.Backend != "" {
+		name, release = c.cfg.Backend, "(configured)"
+	} else if name, release, err = backend.DetectOS(); err != nil {
 		c.log.Printf("[ERROR] Cannot detect operating system: %s\n",
 			err.Error())
-		return
-	} else if pk, err = backend.GetPkgManager(name); err != nil {
+		return err
+	}
+
+	var r backend.Runner
+
+	if c.recordDir != "" {
+		if r, err = c.recorder(name, release); err != nil {
+			c.log.Printf("[ERROR] Cannot record to %s: %s\n",
+				c.recordDir,
+				err.Error())
+			return err
+		}
+	}
+
+	if c.pk, err = backend.GetPkgManager(name, r); err != nil {
 		c.log.Printf("[ERROR] Failed to get PkgManager for %s: %s\n",
 			name,
 			err.Error())
-		return
+		return err
+	} else if err = backend.SetEscalation(c.cfg.Escalate); err != nil {
+		c.log.Printf("[ERROR] %s\n", err.Error())
+		return err
 	}
 
 	c.log.Printf("[DEBUG] We are running on %s %s\n",
 		name,
 		release)
 
-	flag.Parse()
+	return c.dispatch(ctx, cmd, args)
+} // func (c *CLI) Run() error
 
-	args = flag.Args()
+// recorder returns a Runner that runs commands on the local system and
+// records them in c.recordDir.
+func (c *CLI) recorder(name, release string) (backend.Runner, error) {
+	var (
+		err error
+		lg  *log.Logger
+	)
 
-	if len(args) == 0 {
-		fmt.Println("No arguments were given. So long.")
-		os.Exit(0)
+	if lg, err = common.GetLogger(logdomain.PkgManager); err != nil {
+		return nil, err
+	}
+
+	return backend.NewRecordingRunner(backend.NewExecRunner(lg), c.recordDir, name, release)
+} // func (c *CLI) recorder(name, release string) (backend.Runner, error)
+
+// search displays the packages matching a search query.
+func (c *CLI) search(ctx context.Context, args []string) error {
+	var (
+		err     error
+		namelen int
+		pkList  []backend.Package
+	)
+
+	if pkList, err = c.pk.Search(ctx, args[0]); err != nil {
+		c.log.Printf("[ERROR] Failed to search for %q: %s\n",
+			args[0],
+			err.Error())
+		return err
+	} else if c.machine() {
+		return c.emitPackages(pkList)
 	}
 
-	op = args[0]
-	args = args[1:]
+	for _, p := range pkList {
+		if len(p.Name) > namelen {
+			namelen = len(p.Name)
+		}
+	}
 
-	switch strings.ToLower(op) {
-	case "se", "search":
-		var pkList []backend.Package
+	var format = fmt.Sprintf("%%-%ds | %%s\n", namelen+2)
+
+	for _, p := range pkList {
+		fmt.Printf(format,
+			p.Name,
+			p.Description)
+	}
 
-		if pkList, err = pk.Search(args[0]); err != nil {
-			c.log.Printf("[ERROR] Failed to search for %q: %s\n",
-				args[0],
+	return nil
+} // func (c *CLI) search(ctx context.Context, args []string) error
+
+// info displays detailed information on packages.
+func (c *CLI) info(ctx context.Context, args []string) error {
+	var (
+		err      error
+		infoList = make([]*backend.PackageInfo, len(args))
+	)
+
+	for i, name := range args {
+		if infoList[i], err = c.pk.Info(ctx, name); err != nil {
+			c.log.Printf("[ERROR] Failed to get information on %s: %s\n",
+				name,
 				err.Error())
-		} else {
-			var namelen int
+			return err
+		}
+	}
+
+	if c.machine() {
+		return c.emitInfo(infoList)
+	}
+
+	for i, info := range infoList {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		fmt.Printf("Name        : %s\n", info.Name)
+		fmt.Printf("Version     : %s\n", info.Version)
+		fmt.Printf("Installed   : %t\n", info.Installed)
+		if info.Repository != "" {
+			fmt.Printf("Repository  : %s\n", info.Repository)
+		}
+		if info.URL != "" {
+			fmt.Printf("URL         : %s\n", info.URL)
+		}
+		if info.License != "" {
+			fmt.Printf("License     : %s\n", info.License)
+		}
+		if info.Size != nil {
+			fmt.Printf("Size        : %s\n", krylib.FmtBytes(*info.Size))
+		}
+		fmt.Printf("Description : %s\n", info.Description)
+	}
+
+	return nil
+} // func (c *CLI) info(ctx context.Context, args []string) error
+
+// list displays the installed packages, optionally only those whose name
+// contains the given pattern.
+func (c *CLI) list(ctx context.Context, args []string) error {
+	var (
+		err     error
+		namelen int
+		pkList  []backend.Package
+	)
+
+	if pkList, err = c.pk.ListInstalled(ctx); err != nil {
+		c.log.Printf("[ERROR] Failed to list installed packages: %s\n",
+			err.Error())
+		return err
+	}
+
+	if len(args) == 1 {
+		var filtered = make([]backend.Package, 0, len(pkList))
+
+		for _, p := range pkList {
+			if strings.Contains(p.Name, args[0]) {
+				filtered = append(filtered, p)
+			}
+		}
+
+		pkList = filtered
+	}
+
+	if c.machine() {
+		return c.emitPackages(pkList)
+	}
+
+	for _, p := range pkList {
+		if len(p.Name) > namelen {
+			namelen = len(p.Name)
+		}
+	}
+
+	var format = fmt.Sprintf("%%-%ds | %%s\n", namelen+2)
+
+	for _, p := range pkList {
+		fmt.Printf(format,
+			p.Name,
+			p.Version)
+	}
+
+	return nil
+} // func (c *CLI) list(ctx context.Context, args []string) error
+
+// update refreshes the package database.
+func (c *CLI) update(ctx context.Context, args []string) error {
+	var err error
+
+	if err = c.withLock(ctx, c.pk.Update); err != nil {
+		c.log.Printf("[ERROR] Failed to refresh package database: %s\n",
+			err.Error())
+	}
+
+	return err
+} // func (c *CLI) update(ctx context.Context, args []string) error
+
+// clean removes cached package files.
+func (c *CLI) clean(ctx context.Context, args []string) error {
+	var err error
+
+	if err = c.withLock(ctx, c.pk.Clean); err != nil {
+		c.log.Printf("[ERROR] Failed to clean package cache: %s\n",
+			err.Error())
+	}
+
+	return err
+} // func (c *CLI) clean(ctx context.Context, args []string) error
+
+// historyFilter selects the events the history command displays.
+type historyFilter struct {
+	cnt          int
+	evType       string
+	failed       bool
+	since, until string
+	pkg          string
+}
+
+// history displays the most recent events recorded in the database, or
+// with "show", a single event in full.
+func (c *CLI) history(fs *flag.FlagSet) func(context.Context, []string) error {
+	var f historyFilter
+
+	fs.IntVar(&f.cnt, "n", 20, "The number of events to display")
+	fs.StringVar(&f.evType, "type", "", "Only display operations of this type ("+eventNames()+")")
+	fs.BoolVar(&f.failed, "failed", false, "Only display operations that failed")
+	fs.StringVar(&f.since, "since", "", "Only display operations started at or after this time")
+	fs.StringVar(&f.until, "until", "", "Only display operations started before this time")
+	fs.StringVar(&f.pkg, "package", "", "Only display operations that were given or changed this package")
+
+	return func(ctx context.Context, args []string) error {
+		switch {
+		case len(args) == 0:
+			return c.historyList(ctx, &f)
+		case len(args) == 2 && args[0] == "show":
+			return c.historyShow(ctx, args[1])
+		default:
+			fs.Usage()
+			return fmt.Errorf("history: unexpected arguments: %s",
+				strings.Join(args, " "))
+		}
+	}
+} // func (c *CLI) history(fs *flag.FlagSet) func(context.Context, []string) error
+
+// historyList displays the most recent events that match the filter.
+// The type and -failed are handled by the database, the remaining criteria
+// by looking at all events it returns.
+func (c *CLI) historyList(ctx context.Context, f *historyFilter) error {
+	var (
+		err          error
+		evType       event.ID
+		since, until time.Time
+		evList       []event.Event
+		changed      map[int64]bool
+		limit        = f.cnt
+	)
+
+	if f.cnt <= 0 {
+		return fmt.Errorf("Invalid number of events: %d", f.cnt)
+	} else if f.evType != "" {
+		if evType, err = parseEventType(f.evType); err != nil {
+			return err
+		}
+	}
+
+	if since, err = parseTime(f.since); err != nil {
+		return err
+	} else if until, err = parseTime(f.until); err != nil {
+		return err
+	} else if f.pkg != "" {
+		if changed, err = c.changedBy(ctx, f.pkg); err != nil {
+			return err
+		}
+	}
+
+	if !since.IsZero() || !until.IsZero() || f.pkg != "" || (f.evType != "" && f.failed) {
+		limit = -1
+	}
+
+	switch {
+	case f.evType != "":
+		evList, err = c.db.EventGetRecentByType(ctx, limit, evType)
+	case f.failed:
+		evList, err = c.db.EventGetRecentErr(ctx, limit)
+	default:
+		evList, err = c.db.EventGetRecent(ctx, limit)
+	}
+
+	if err != nil {
+		c.log.Printf("[ERROR] Failed to load recent events: %s\n",
+			err.Error())
+		return err
+	}
+
+	var matches = make([]event.Event, 0, len(evList))
+
+	for _, ev := range evList {
+		switch {
+		case f.failed && ev.Status == 0:
+		case !since.IsZero() && ev.Timestamp.Before(since):
+		case !until.IsZero() && !ev.Timestamp.Before(until):
+		case f.pkg != "" && !changed[ev.ID] && !common.Contains(ev.Packages, f.pkg):
+		default:
+			matches = append(matches, ev)
+		}
+
+		if len(matches) == f.cnt {
+			break
+		}
+	}
+
+	if c.machine() {
+		return c.emitEvents(matches)
+	} else if len(matches) == 0 {
+		fmt.Println("No matching events were recorded.")
+		return nil
+	}
+
+	for _, ev := range matches {
+		fmt.Printf("%5d | %s | %-10s | %3d | %6s | %-8s | %s\n",
+			ev.ID,
+			ev.Timestamp.Format(common.TimestampFormat),
+			ev.Type,
+			ev.Status,
+			ev.Duration().Round(time.Second),
+			ev.User,
+			strings.Join(ev.Packages, " "))
+	}
+
+	return nil
+} // func (c *CLI) historyList(ctx context.Context, f *historyFilter) error
+
+// changedBy returns the IDs of the events that changed the given package.
+func (c *CLI) changedBy(ctx context.Context, pkg string) (map[int64]bool, error) {
+	var (
+		err     error
+		chgList []event.Change
+		ids     = make(map[int64]bool)
+	)
+
+	if chgList, err = c.db.ChangeGetByPackage(ctx, pkg); err != nil {
+		c.log.Printf("[ERROR] Failed to load history of %s: %s\n",
+			pkg,
+			err.Error())
+		return nil, err
+	}
+
+	for _, ch := range chgList {
+		ids[ch.EventID] = true
+	}
+
+	return ids, nil
+} // func (c *CLI) changedBy(ctx context.Context, pkg string) (map[int64]bool, error)
+
+// historyEntry is a single event, along with the changes it made.
+type historyEntry struct {
+	*event.Event `yaml:",inline"`
+	Changes      []event.Change `json:"changes" yaml:"changes"`
+}
+
+// historyShow displays the event with the given ID in full.
+func (c *CLI) historyShow(ctx context.Context, arg string) error {
+	var (
+		err   error
+		id    int64
+		entry historyEntry
+	)
+
+	if id, err = strconv.ParseInt(arg, 10, 64); err != nil {
+		return fmt.Errorf("Invalid event ID %q", arg)
+	} else if entry.Event, err = c.db.EventGetByID(ctx, id); err != nil {
+		c.log.Printf("[ERROR] Failed to load event %d: %s\n",
+			id,
+			err.Error())
+		return err
+	} else if entry.Event == nil {
+		return fmt.Errorf("There is no event with ID %d", id)
+	} else if entry.Changes, err = c.db.ChangeGetByEvent(ctx, id); err != nil {
+		c.log.Printf("[ERROR] Failed to load changes of event %d: %s\n",
+			id,
+			err.Error())
+		return err
+	} else if c.machine() {
+		return c.emitHistoryEntry(&entry)
+	}
+
+	var ev = entry.Event
+
+	fmt.Printf("ID        : %d\n", ev.ID)
+	fmt.Printf("Operation : %s\n", ev.Type)
+	fmt.Printf("Started   : %s\n", ev.Timestamp.Format(common.TimestampFormat))
+	if d := ev.Duration(); d > 0 {
+		fmt.Printf("Finished  : %s (%s)\n",
+			ev.End.Format(common.TimestampFormat),
+			d.Round(time.Second))
+	}
+	fmt.Printf("Status    : %d\n", ev.Status)
+	fmt.Printf("User      : %s\n", ev.User)
+	fmt.Printf("Packages  : %s\n", strings.Join(ev.Packages, " "))
+
+	if ev.Command != "" {
+		fmt.Println("\nCommands:")
+		for _, line := range strings.Split(ev.Command, "\n") {
+			fmt.Printf("    %s\n", line)
+		}
+	}
+
+	if len(entry.Changes) > 0 {
+		fmt.Println("\nChanges:")
+		for _, ch := range entry.Changes {
+			switch ch.Action {
+			case action.Install:
+				fmt.Printf("    %-10s %s %s\n", ch.Action, ch.Package, ch.NewVersion)
+			case action.Remove:
+				fmt.Printf("    %-10s %s %s\n", ch.Action, ch.Package, ch.OldVersion)
+			default:
+				fmt.Printf("    %-10s %s %s -> %s\n", ch.Action, ch.Package, ch.OldVersion, ch.NewVersion)
+			}
+		}
+	}
+
+	if ev.Output != "" {
+		fmt.Println("\nOutput:")
+		fmt.Print(ev.Output)
+		if !strings.HasSuffix(ev.Output, "\n") {
+			fmt.Println()
+		}
+	}
+
+	return nil
+} // func (c *CLI) historyShow(ctx context.Context, arg string) error
+
+// eventNames returns the names of all event types, for the usage text.
+func eventNames() string {
+	var names = make([]string, event.EventCnt)
 
-			for _, p := range pkList {
-				if len(p.Name) > namelen {
-					namelen = len(p.Name)
+	for i, id := range event.AllEvents() {
+		names[i] = id.String()
+	}
+
+	return strings.Join(names, ", ")
+} // func eventNames() string
+
+// parseEventType returns the event type with the given name, ignoring case.
+func parseEventType(s string) (event.ID, error) {
+	for _, id := range event.AllEvents() {
+		if strings.EqualFold(id.String(), s) {
+			return id, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Unknown event type %q, expected one of %s",
+		s,
+		eventNames())
+} // func parseEventType(s string) (event.ID, error)
+
+var timeFormats = []string{
+	common.TimestampFormat,
+	common.TimestampFormatMinute,
+	common.TimestampFormatDate,
+}
+
+// parseTime parses a point in time given on the command line, either as a
+// date, optionally with a time, in the local time zone, or as a duration
+// meaning that long ago. An empty string yields the zero time.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	} else if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	for _, f := range timeFormats {
+		if t, err := time.ParseInLocation(f, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Cannot parse time %q, expected a date like %s, or a duration like 48h",
+		s,
+		common.TimestampFormatMinute)
+} // func parseTime(s string) (time.Time, error)
+
+// deps displays the dependencies of packages.
+// With -graph, it emits the dependency graph of the given packages - or of
+// all installed packages, if none are given - as DOT or JSON instead.
+func (c *CLI) deps(fs *flag.FlagSet) func(context.Context, []string) error {
+	var (
+		reverse bool
+		graph   string
+	)
+
+	fs.BoolVar(&reverse, "r", false, "Show the packages that depend on the given packages")
+	fs.StringVar(&graph, "graph", "", "Emit the dependency graph in the given format (dot or json)")
+
+	return func(ctx context.Context, args []string) error {
+		var err error
+
+		if graph != "" {
+			var g *backend.DepGraph
+
+			switch strings.ToLower(graph) {
+			case "dot", "json":
+			default:
+				return fmt.Errorf("Unsupported graph format %q", graph)
+			}
+
+			if g, err = backend.BuildDepGraph(ctx, c.pk, reverse, args...); err != nil {
+				c.log.Printf("[ERROR] Failed to build dependency graph: %s\n",
+					err.Error())
+				return err
+			}
+
+			if strings.ToLower(graph) == "dot" {
+				err = g.WriteDOT(os.Stdout)
+			} else {
+				err = g.WriteJSON(os.Stdout)
+			}
+
+			if err != nil {
+				c.log.Printf("[ERROR] Failed to write dependency graph: %s\n",
+					err.Error())
+			}
+
+			return err
+		} else if len(args) == 0 {
+			fs.Usage()
+			return errors.New("deps: no packages were given")
+		}
+
+		var edges = make([]backend.DepEdge, 0)
+
+		for _, name := range args {
+			var deps []string
+
+			if reverse {
+				deps, err = c.pk.RequiredBy(ctx, name)
+			} else {
+				deps, err = c.pk.Depends(ctx, name)
+			}
+
+			if err != nil {
+				c.log.Printf("[ERROR] Failed to get dependencies of %s: %s\n",
+					name,
+					err.Error())
+				return err
+			} else if c.machine() {
+				for _, d := range deps {
+					if reverse {
+						edges = append(edges, backend.DepEdge{From: d, To: name})
+					} else {
+						edges = append(edges, backend.DepEdge{From: name, To: d})
+					}
 				}
+				continue
 			}
 
-			var format = fmt.Sprintf("%%-%ds | %%s\n", namelen+2)
+			fmt.Printf("%s:\n", name)
+			for _, d := range deps {
+				fmt.Printf("\t%s\n", d)
+			}
+		}
 
-			for _, p := range pkList {
-				fmt.Printf(format,
-					p.Name,
-					p.Description)
+		if c.machine() {
+			return c.emitEdges(edges)
+		}
+
+		return nil
+	}
+} // func (c *CLI) deps(fs *flag.FlagSet) func(context.Context, []string) error
+
+// outdated displays the updates that are available for installed packages.
+// With -security, only security updates are displayed, along with the
+// advisories they resolve.
+func (c *CLI) outdated(fs *flag.FlagSet) func(context.Context, []string) error {
+	var security bool
+
+	fs.BoolVar(&security, "security", false, "Only list security updates")
+
+	return func(ctx context.Context, args []string) error {
+		var (
+			err     error
+			namelen int
+			upList  []backend.PendingUpgrade
+		)
+
+		if upList, err = c.pk.ListUpgrades(ctx, security); err != nil {
+			c.log.Printf("[ERROR] Failed to list available upgrades: %s\n",
+				err.Error())
+			return err
+		} else if c.machine() {
+			return c.emitUpgrades(upList)
+		} else if len(upList) == 0 {
+			fmt.Println("All packages are up to date.")
+			return nil
+		}
+
+		for _, u := range upList {
+			if len(u.Name) > namelen {
+				namelen = len(u.Name)
+			}
+		}
+
+		var format = fmt.Sprintf("%%-%ds | %%s -> %%s\n", namelen+2)
+
+		for _, u := range upList {
+			fmt.Printf(format,
+				u.Name,
+				u.Installed,
+				u.Candidate)
+			if len(u.Advisories) > 0 {
+				fmt.Printf("    %s\n", strings.Join(u.Advisories, ", "))
 			}
 		}
+
+		return nil
+	}
+} // func (c *CLI) outdated(fs *flag.FlagSet) func(context.Context, []string) error
+
+// txOptions holds the flags shared by the commands that change the set of
+// installed packages.
+type txOptions struct {
+	dryRun   bool
+	yes      bool
+	security bool
+}
+
+// transaction registers the flags for one of the operations that change the
+// set of installed packages and returns the function performing it.
+// Before anything is done, it displays what the operation is going to do
+// and asks the user for confirmation, unless -yes was given. With -dry-run,
+// it only displays what would be done.
+func (c *CLI) transaction(fs *flag.FlagSet, op event.ID) func(context.Context, []string) error {
+	var opt txOptions
+
+	fs.BoolVar(&opt.dryRun, "dry-run", false, "Only show what would be done")
+	fs.BoolVar(&opt.yes, "yes", false, "Do not ask for confirmation")
+	fs.BoolVar(&opt.yes, "y", false, "Short for -yes")
+	if op == event.Update {
+		fs.BoolVar(&opt.security, "security", false, "Only install security updates")
+	}
+
+	return func(ctx context.Context, pkgs []string) error {
+		return c.runTransaction(ctx, op, &opt, pkgs)
+	}
+} // func (c *CLI) transaction(fs *flag.FlagSet, op event.ID) func(context.Context, []string) error
+
+// runTransaction previews the operation, asks for confirmation if needed,
+// and performs it.
+// Asking for an excluded package by name is an error. Otherwise, the
+// package manager is told to skip the excluded packages.
+func (c *CLI) runTransaction(ctx context.Context, op event.ID, opt *txOptions, pkgs []string) error {
+	var (
+		err  error
+		plan *backend.Plan
+	)
+
+	if c.machine() && !opt.dryRun && !opt.yes {
+		// We cannot mix a machine-readable plan with an interactive
+		// prompt.
+		return fmt.Errorf("-output %s requires -dry-run or -yes", c.output)
+	} else if err = c.checkExcluded(pkgs); err != nil {
+		return err
+	}
+
+	ctx = backend.WithExclude(ctx, c.cfg.Exclude)
+
+	if opt.security {
+		// Previewing a security-only upgrade means previewing the
+		// upgrade of the packages that have security updates.
+		var upList []backend.PendingUpgrade
+
+		if upList, err = c.pk.ListUpgrades(ctx, true); err != nil {
+			c.log.Printf("[ERROR] Failed to list security updates: %s\n",
+				err.Error())
+			return err
+		}
+
+		for _, u := range upList {
+			if !c.cfg.Excluded(u.Name) {
+				pkgs = append(pkgs, u.Name)
+			}
+		}
+
+		if len(pkgs) == 0 {
+			if c.machine() {
+				return c.emitPlan(&backend.Plan{
+					Op:      op,
+					Changes: []backend.Change{},
+				})
+			}
+			fmt.Println("Nothing to do.")
+			return nil
+		}
+	}
+
+	if plan, err = c.pk.Preview(ctx, op, pkgs...); err != nil {
+		c.log.Printf("[ERROR] Failed to preview %s: %s\n",
+			op,
+			err.Error())
+		return err
+	}
+
+	if c.machine() {
+		if err = c.emitPlan(plan); err != nil {
+			return err
+		}
+	} else {
+		printPlan(plan)
+	}
+
+	if opt.dryRun || plan.Empty() {
+		return nil
+	} else if !opt.yes && !confirm(ctx, "Continue?") {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		fmt.Println("Aborted.")
+		return nil
+	}
+
+	err = c.withLock(ctx, func(ctx context.Context) error {
+		switch op {
+		case event.Add:
+			return c.pk.Install(ctx, pkgs...)
+		case event.Delete:
+			return c.pk.Remove(ctx, pkgs...)
+		default:
+			return c.pk.Upgrade(ctx, opt.security)
+		}
+	})
+
+	if err != nil {
+		c.log.Printf("[ERROR] %s failed: %s\n",
+			op,
+			err.Error())
+	}
+
+	return err
+} // func (c *CLI) runTransaction(ctx context.Context, op event.ID, opt *txOptions, pkgs []string) error
+
+// withLock runs fn once the package database is no longer locked by another
+// process. If fn fails because someone grabbed the lock in the meantime, or
+// because the backend cannot see the lock beforehand, it waits and tries
+// again, until the lock timeout expires or the context is cancelled.
+// While fn runs, the package manager's progress is displayed.
+func (c *CLI) withLock(ctx context.Context, fn func(context.Context) error) error {
+	var (
+		err      error
+		deadline = time.Now().Add(c.lockTimeout)
+	)
+
+	ctx = backend.WithProgress(ctx, c.progress)
+
+	for {
+		if err = backend.WaitForLock(ctx, c.pk, time.Until(deadline), c.lockProgress); err != nil {
+			c.log.Printf("[ERROR] %s\n", err.Error())
+			return err
+		} else if err = fn(ctx); !errors.Is(err, backend.ErrLocked) || time.Now().After(deadline) {
+			return err
+		}
+
+		c.log.Printf("[INFO] The package database is locked, trying again: %s\n",
+			err.Error())
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backend.LockPollInterval):
+		}
+	}
+} // func (c *CLI) withLock(ctx context.Context, fn func(context.Context) error) error
+
+// lockProgress tells the user we are waiting for a lock. To keep the noise
+// down, it only speaks up every ten seconds.
+func (c *CLI) lockProgress(info *backend.LockInfo, waited time.Duration) {
+	if waited%(10*time.Second) >= backend.LockPollInterval {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Waiting for lock on %s (%s so far, giving up after %s)\n",
+		info,
+		waited.Round(time.Second),
+		c.lockTimeout)
+} // func (c *CLI) lockProgress(info *backend.LockInfo, waited time.Duration)
+
+var progressVerbs = map[stage.ID]string{
+	stage.Download:  "Downloading",
+	stage.Install:   "Installing",
+	stage.Upgrade:   "Upgrading",
+	stage.Downgrade: "Downgrading",
+	stage.Remove:    "Removing",
+	stage.Reinstall: "Reinstalling",
+}
+
+// progress displays the progress of a transaction as it happens. Unless
+// -verbose was given, only the lines we make sense of are displayed, in a
+// form that is the same for all package managers.
+func (c *CLI) progress(p *backend.Progress) {
+	switch {
+	case c.verbose:
+		fmt.Fprintln(os.Stderr, p.Line)
+	case p.Stage == stage.Output:
+	case p.Total > 0:
+		fmt.Fprintf(os.Stderr, "[%d/%d] %s %s\n",
+			p.Done,
+			p.Total,
+			progressVerbs[p.Stage],
+			p.Package)
+	default:
+		fmt.Fprintf(os.Stderr, "%s %s\n",
+			progressVerbs[p.Stage],
+			p.Package)
+	}
+} // func (c *CLI) progress(p *backend.Progress)
+
+// checkExcluded returns an error if any of the given packages is excluded
+// by the configuration.
+func (c *CLI) checkExcluded(names []string) error {
+	var excl []string
+
+	for _, n := range names {
+		if c.cfg.Excluded(n) {
+			excl = append(excl, n)
+		}
+	}
+
+	if len(excl) > 0 {
+		return fmt.Errorf("Refusing to touch excluded packages: %s",
+			strings.Join(excl, ", "))
+	}
+
+	return nil
+} // func (c *CLI) checkExcluded(names []string) error
+
+// confirm asks the user a yes/no question on the terminal. Anything but an
+// explicit yes counts as no, and so does cancelling the context while we
+// wait for an answer.
+func confirm(ctx context.Context, question string) bool {
+	var (
+		answer string
+		input  = make(chan string, 1)
+	)
+
+	fmt.Printf("%s [y/N] ", question)
+
+	go func() {
+		var line, _ = bufio.NewReader(os.Stdin).ReadString('\n')
+		input <- line
+	}()
+
+	select {
+	case <-ctx.Done():
+		fmt.Println()
+		return false
+	case answer = <-input:
+	}
+
+	if answer == "" {
+		fmt.Println()
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
 	default:
-		c.log.Printf("[ERROR] Unsupported operation %q\n",
-			op)
+		return false
 	}
+} // func confirm(ctx context.Context, question string) bool
+
+var planHeadings = map[action.ID]string{
+	action.Install:   "The following packages will be installed:",
+	action.Upgrade:   "The following packages will be upgraded:",
+	action.Downgrade: "The following packages will be DOWNGRADED:",
+	action.Remove:    "The following packages will be REMOVED:",
+	action.Reinstall: "The following packages will be reinstalled:",
 }
+
+// printPlan displays a Plan in a form suitable for humans.
+func printPlan(plan *backend.Plan) {
+	if plan.Empty() {
+		fmt.Println("Nothing to do.")
+		return
+	}
+
+	for _, a := range action.AllActions() {
+		if plan.Count(a) == 0 {
+			continue
+		}
+
+		fmt.Println(planHeadings[a])
+
+		for _, ch := range plan.Changes {
+			if ch.Action != a {
+				continue
+			}
+
+			switch a {
+			case action.Install:
+				fmt.Printf("    %s %s\n", ch.Name, ch.NewVersion)
+			case action.Remove:
+				fmt.Printf("    %s %s\n", ch.Name, ch.OldVersion)
+			default:
+				fmt.Printf("    %s %s -> %s\n", ch.Name, ch.OldVersion, ch.NewVersion)
+			}
+		}
+
+		fmt.Println()
+	}
+
+	if plan.DownloadSize != nil {
+		fmt.Printf("Download size: %s\n", krylib.FmtBytes(*plan.DownloadSize))
+	}
+
+	if plan.DiskDelta != nil {
+		if *plan.DiskDelta < 0 {
+			fmt.Printf("Disk space freed: %s\n", krylib.FmtBytes(-*plan.DiskDelta))
+		} else {
+			fmt.Printf("Additional disk space: %s\n", krylib.FmtBytes(*plan.DiskDelta))
+		}
+	}
+} // func printPlan(plan *backend.Plan)
+
+// exitStatuses maps the errors the backend can classify to the exit status
+// pkman exits with, so scripts can tell the failures apart.
+var exitStatuses = []struct {
+	err    error
+	status int
+}{
+	{backend.ErrNotFound, 3},
+	{backend.ErrLocked, 4},
+	{backend.ErrPermission, 5},
+	{backend.ErrNetwork, 6},
+	{backend.ErrConflict, 7},
+	{backend.ErrDiskFull, 8},
+	{backend.ErrUnsupported, 9},
+	// Like timeout(1) and the shell on SIGINT
+	{context.DeadlineExceeded, 124},
+	{context.Canceled, 130},
+}
+
+// ExitStatus returns the exit status for an error returned by Run: 0 for no
+// error, 1 for errors we cannot classify, and a distinct status for each
+// kind of failure reported by the package manager.
+func ExitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	for _, e := range exitStatuses {
+		if errors.Is(err, e.err) {
+			return e.status
+		}
+	}
+
+	return 1
+} // func ExitStatus(err error) int
@@ -0,0 +1,398 @@
+// /home/krylon/go/src/github.com/blicero/pkman/cli/command.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/blicero/pkman/common"
+	"github.com/blicero/pkman/database/event"
+)
+
+// command is a subcommand of the CLI.
+// setup defines the command's flags on the given FlagSet and returns the
+// function that performs the command once the flags have been parsed.
+// minArgs and maxArgs limit the number of positional arguments, a maxArgs of
+// -1 means there is no upper limit.
+// Commands that need the lock on the package database set locks, which gives
+// them the -wait flag.
+type command struct {
+	name     string
+	aliases  []string
+	synopsis string
+	help     string
+	minArgs  int
+	maxArgs  int
+	locks    bool
+	setup    func(c *CLI, fs *flag.FlagSet) func(ctx context.Context, args []string) error
+}
+
+// commands is the list of subcommands, in the order they are listed by help.
+// It is filled in init, because the help command refers to it.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			name:     "search",
+			aliases:  []string{"se"},
+			synopsis: "search pattern",
+			help:     "Search the repositories for packages matching pattern.",
+			minArgs:  1,
+			maxArgs:  1,
+			setup: func(c *CLI, fs *flag.FlagSet) func(context.Context, []string) error {
+				return c.search
+			},
+		},
+		{
+			name:     "info",
+			aliases:  []string{"if", "show"},
+			synopsis: "info package...",
+			help:     "Display detailed information on packages.",
+			minArgs:  1,
+			maxArgs:  -1,
+			setup: func(c *CLI, fs *flag.FlagSet) func(context.Context, []string) error {
+				return c.info
+			},
+		},
+		{
+			name:     "list",
+			aliases:  []string{"ls"},
+			synopsis: "list [pattern]",
+			help:     "List installed packages, optionally only those whose name contains pattern.",
+			minArgs:  0,
+			maxArgs:  1,
+			setup: func(c *CLI, fs *flag.FlagSet) func(context.Context, []string) error {
+				return c.list
+			},
+		},
+		{
+			name:     "install",
+			aliases:  []string{"in", "add"},
+			synopsis: "install [-dry-run] [-yes] package...",
+			help:     "Install packages.",
+			minArgs:  1,
+			maxArgs:  -1,
+			locks:    true,
+			setup: func(c *CLI, fs *flag.FlagSet) func(context.Context, []string) error {
+				return c.transaction(fs, event.Add)
+			},
+		},
+		{
+			name:     "remove",
+			aliases:  []string{"rm", "del", "delete"},
+			synopsis: "remove [-dry-run] [-yes] package...",
+			help:     "Remove packages.",
+			minArgs:  1,
+			maxArgs:  -1,
+			locks:    true,
+			setup: func(c *CLI, fs *flag.FlagSet) func(context.Context, []string) error {
+				return c.transaction(fs, event.Delete)
+			},
+		},
+		{
+			name:     "update",
+			aliases:  []string{"ref", "refresh"},
+			synopsis: "update",
+			help:     "Refresh the package database from the repositories.",
+			minArgs:  0,
+			maxArgs:  0,
+			locks:    true,
+			setup: func(c *CLI, fs *flag.FlagSet) func(context.Context, []string) error {
+				return c.update
+			},
+		},
+		{
+			name:     "upgrade",
+			aliases:  []string{"up"},
+			synopsis: "upgrade [-security] [-dry-run] [-yes]",
+			help:     "Upgrade all installed packages for which updates are available.",
+			minArgs:  0,
+			maxArgs:  0,
+			locks:    true,
+			setup: func(c *CLI, fs *flag.FlagSet) func(context.Context, []string) error {
+				return c.transaction(fs, event.Update)
+			},
+		},
+		{
+			name:     "outdated",
+			aliases:  []string{"lu", "list-updates"},
+			synopsis: "outdated [-security]",
+			help:     "List the updates that are available for installed packages.",
+			minArgs:  0,
+			maxArgs:  0,
+			setup:    (*CLI).outdated,
+		},
+		{
+			name:     "deps",
+			aliases:  []string{"dep"},
+			synopsis: "deps [-r] [-graph dot|json] package...",
+			help: "Display the dependencies of packages, or with -r, the packages that depend on them.\n" +
+				"With -graph, emit the dependency graph instead, which covers all installed\n" +
+				"packages if none are given.",
+			minArgs: 0,
+			maxArgs: -1,
+			setup:   (*CLI).deps,
+		},
+		{
+			name:     "clean",
+			aliases:  []string{"cc"},
+			synopsis: "clean",
+			help:     "Remove cached package files.",
+			minArgs:  0,
+			maxArgs:  0,
+			locks:    true,
+			setup: func(c *CLI, fs *flag.FlagSet) func(context.Context, []string) error {
+				return c.clean
+			},
+		},
+		{
+			name:     "history",
+			aliases:  []string{"hist"},
+			synopsis: "history [-n count] [-type type] [-failed] [-since time] [-until time] [-package name]\n       " + common.AppName + " history show id",
+			help: "Display the most recent operations performed through pkman.\n" +
+				"Times are given as a date, a date and time, or a duration like 48h, meaning\n" +
+				"that long ago. history show displays a single operation in full, with the\n" +
+				"commands it ran, their output, and the packages it changed.",
+			minArgs: 0,
+			maxArgs: 2,
+			setup:   (*CLI).history,
+		},
+		{
+			name:     "help",
+			aliases:  []string{"h"},
+			synopsis: "help [command]",
+			help:     "Display the list of commands, or the usage of a single command.",
+			minArgs:  0,
+			maxArgs:  1,
+			setup: func(c *CLI, fs *flag.FlagSet) func(context.Context, []string) error {
+				return c.help
+			},
+		},
+	}
+} // func init()
+
+// lookupCommand returns the command with the given name or alias, or nil if
+// there is no such command.
+func lookupCommand(name string) *command {
+	name = strings.ToLower(name)
+
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+
+		for _, a := range cmd.aliases {
+			if a == name {
+				return cmd
+			}
+		}
+	}
+
+	return nil
+} // func lookupCommand(name string) *command
+
+// resolve looks up the command named by the first argument, expanding the
+// aliases defined in the configuration. It returns the command and its
+// arguments, or nil and the unchanged arguments if there is no such command.
+func (c *CLI) resolve(args []string) (*command, []string) {
+	var cmd = lookupCommand(args[0])
+
+	if cmd != nil {
+		return cmd, args[1:]
+	} else if line, ok := c.cfg.Aliases[args[0]]; ok {
+		var fields = strings.Fields(line)
+
+		if len(fields) > 0 {
+			if cmd = lookupCommand(fields[0]); cmd != nil {
+				return cmd, append(fields[1:], args[1:]...)
+			}
+		}
+	}
+
+	return nil, args
+} // func (c *CLI) resolve(args []string) (*command, []string)
+
+// usage prints the usage text of the command, including its flags, if any.
+func (cmd *command) usage(fs *flag.FlagSet) {
+	var out = fs.Output()
+
+	fmt.Fprintf(out, "Usage: %s %s\n\n%s\n",
+		common.AppName,
+		cmd.synopsis,
+		cmd.help)
+
+	if len(cmd.aliases) > 0 {
+		fmt.Fprintf(out, "\nAliases: %s\n",
+			strings.Join(cmd.aliases, ", "))
+	}
+
+	var hasFlags bool
+
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+
+	if hasFlags {
+		fmt.Fprintln(out, "\nFlags:")
+		fs.PrintDefaults()
+	}
+} // func (cmd *command) usage(fs *flag.FlagSet)
+
+// flagSet creates the FlagSet for the command and registers its flags.
+// It returns the FlagSet along with the function that performs the command.
+func (cmd *command) flagSet(c *CLI) (*flag.FlagSet, func(context.Context, []string) error) {
+	var (
+		fs  = flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		run func(context.Context, []string) error
+	)
+
+	fs.StringVar(&c.output,
+		"output",
+		c.cfg.Output,
+		"Output format: "+strings.Join(outputFormats, ", "))
+	fs.DurationVar(&c.timeout,
+		"timeout",
+		c.cfg.Timeout,
+		"Give up if the command takes longer than this, 0 means never")
+	if cmd.locks {
+		fs.DurationVar(&c.lockTimeout,
+			"wait",
+			c.cfg.LockTimeout,
+			"How long to wait for another process to release the package database")
+		fs.BoolVar(&c.verbose,
+			"verbose",
+			false,
+			"Display everything the package manager prints, not just its progress")
+	}
+	run = cmd.setup(c, fs)
+
+	fs.Usage = func() { cmd.usage(fs) }
+
+	return fs, run
+} // func (cmd *command) flagSet(c *CLI) (*flag.FlagSet, func(context.Context, []string) error)
+
+// dispatch parses the arguments of the given command, checks their number,
+// and runs the command, giving up once the -timeout expires.
+func (c *CLI) dispatch(ctx context.Context, cmd *command, args []string) error {
+	var (
+		err     error
+		fs, run = cmd.flagSet(c)
+	)
+
+	fs.SetOutput(os.Stderr)
+
+	if err = fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	args = fs.Args()
+
+	if !validOutput(c.output) {
+		return fmt.Errorf("Unsupported output format %q", c.output)
+	} else if len(args) < cmd.minArgs || (cmd.maxArgs >= 0 && len(args) > cmd.maxArgs) {
+		fs.Usage()
+		return fmt.Errorf("%s: wrong number of arguments (%d)",
+			cmd.name,
+			len(args))
+	}
+
+	if !c.machine() {
+		// Other programs parse what we print, so we only greet
+		// humans, and even then not on stdout, where some commands
+		// emit DOT or JSON regardless of -output.
+		fmt.Fprintf(os.Stderr, "%s %s built on %s\n",
+			common.AppName,
+			common.Version,
+			common.BuildStamp.Format(common.TimestampFormat))
+	}
+
+	c.log.Printf("[DEBUG] Running command %s %s\n",
+		cmd.name,
+		strings.Join(args, " "))
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	if err = run(ctx, args); errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%s timed out after %s: %w", cmd.name, c.timeout, err)
+	} else if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("%s was cancelled: %w", cmd.name, err)
+	}
+
+	return err
+} // func (c *CLI) dispatch(ctx context.Context, cmd *command, args []string) error
+
+// help displays the list of commands, or the usage of a single command.
+func (c *CLI) help(ctx context.Context, args []string) error {
+	if len(args) == 1 {
+		var cmd = lookupCommand(args[0])
+
+		if cmd == nil {
+			return fmt.Errorf("Unknown command %q", args[0])
+		}
+
+		var fs, _ = cmd.flagSet(c)
+
+		fs.SetOutput(os.Stdout)
+		fs.Usage()
+		return nil
+	}
+
+	printCommands(os.Stdout)
+	return nil
+} // func (c *CLI) help(ctx context.Context, args []string) error
+
+// printCommands prints a short overview of all commands.
+func printCommands(out *os.File) {
+	var namelen int
+
+	for _, cmd := range commands {
+		if len(cmd.name) > namelen {
+			namelen = len(cmd.name)
+		}
+	}
+
+	var format = fmt.Sprintf("    %%-%ds  %%s\n", namelen)
+
+	fmt.Fprintf(out, "Usage: %s command [flags] [arguments]\n\nCommands:\n",
+		common.AppName)
+
+	for _, cmd := range commands {
+		fmt.Fprintf(out, format,
+			cmd.name,
+			firstSentence(cmd.help))
+	}
+
+	fmt.Fprintln(out, "\nGlobal flags:")
+	flag.CommandLine.SetOutput(out)
+	flag.PrintDefaults()
+
+	fmt.Fprintf(out, "\nRun '%s help command' for details on a command.\n",
+		common.AppName)
+} // func printCommands(out *os.File)
+
+// firstSentence returns the first sentence of a help text.
+func firstSentence(s string) string {
+	if idx := strings.Index(s, ". "); idx != -1 {
+		return s[:idx+1]
+	} else if idx = strings.IndexByte(s, '\n'); idx != -1 {
+		return s[:idx]
+	}
+
+	return s
+} // func firstSentence(s string) string
@@ -0,0 +1,251 @@
+// /home/krylon/go/src/github.com/blicero/pkman/cli/output.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blicero/pkman/backend"
+	"github.com/blicero/pkman/database/event"
+	"gopkg.in/yaml.v3"
+)
+
+// The output formats the CLI supports. Everything but outText is meant to be
+// consumed by other programs, so the field names and column orders must not
+// change lightly.
+const (
+	outText = "text"
+	outJSON = "json"
+	outYAML = "yaml"
+	outCSV  = "csv"
+	outTSV  = "tsv"
+)
+
+var outputFormats = []string{outText, outJSON, outYAML, outCSV, outTSV}
+
+// validOutput returns true if f is one of the supported output formats.
+func validOutput(f string) bool {
+	for _, o := range outputFormats {
+		if f == o {
+			return true
+		}
+	}
+
+	return false
+} // func validOutput(f string) bool
+
+// machine returns true if the output is meant for other programs rather
+// than for humans.
+func (c *CLI) machine() bool {
+	return c.output != outText
+} // func (c *CLI) machine() bool
+
+// emit writes a command's result to stdout in the selected machine-readable
+// format. JSON and YAML are rendered from v, CSV and TSV from header and
+// rows.
+func (c *CLI) emit(v any, header []string, rows [][]string) error {
+	var err error
+
+	switch c.output {
+	case outJSON:
+		var enc = json.NewEncoder(os.Stdout)
+
+		enc.SetIndent("", "  ")
+		err = enc.Encode(v)
+	case outYAML:
+		var enc = yaml.NewEncoder(os.Stdout)
+
+		enc.SetIndent(2)
+		if err = enc.Encode(v); err == nil {
+			err = enc.Close()
+		}
+	case outCSV, outTSV:
+		var w = csv.NewWriter(os.Stdout)
+
+		if c.output == outTSV {
+			w.Comma = '\t'
+		}
+
+		if err = w.Write(header); err == nil {
+			err = w.WriteAll(rows)
+		}
+	default:
+		err = fmt.Errorf("Unsupported output format %q", c.output)
+	}
+
+	if err != nil {
+		c.log.Printf("[ERROR] Failed to write %s output: %s\n",
+			c.output,
+			err.Error())
+	}
+
+	return err
+} // func (c *CLI) emit(v any, header []string, rows [][]string) error
+
+func (c *CLI) emitPackages(pkList []backend.Package) error {
+	var rows = make([][]string, len(pkList))
+
+	if pkList == nil {
+		pkList = []backend.Package{}
+	}
+
+	for i, p := range pkList {
+		rows[i] = []string{p.Name, p.Version, p.Description}
+	}
+
+	return c.emit(pkList,
+		[]string{"name", "version", "description"},
+		rows)
+} // func (c *CLI) emitPackages(pkList []backend.Package) error
+
+// emitInfo writes detailed information on packages. In CSV and TSV, an
+// unknown size is an empty cell.
+func (c *CLI) emitInfo(infoList []*backend.PackageInfo) error {
+	var rows = make([][]string, len(infoList))
+
+	for i, p := range infoList {
+		rows[i] = []string{
+			p.Name,
+			p.Version,
+			p.Description,
+			strconv.FormatBool(p.Installed),
+			p.Repository,
+			p.URL,
+			p.License,
+			formatSize(p.Size),
+		}
+	}
+
+	return c.emit(infoList,
+		[]string{"name", "version", "description", "installed", "repository", "url", "license", "size"},
+		rows)
+} // func (c *CLI) emitInfo(infoList []*backend.PackageInfo) error
+
+// formatSize renders a size in bytes for CSV and TSV output, or an empty
+// string if the size is unknown.
+func formatSize(n *int64) string {
+	if n == nil {
+		return ""
+	}
+
+	return strconv.FormatInt(*n, 10)
+} // func formatSize(n *int64) string
+
+// emitUpgrades writes a list of pending upgrades. In CSV and TSV, the
+// advisories are separated by spaces.
+func (c *CLI) emitUpgrades(upList []backend.PendingUpgrade) error {
+	var rows = make([][]string, len(upList))
+
+	if upList == nil {
+		upList = []backend.PendingUpgrade{}
+	}
+
+	for i := range upList {
+		if upList[i].Advisories == nil {
+			upList[i].Advisories = []string{}
+		}
+
+		rows[i] = []string{
+			upList[i].Name,
+			upList[i].Installed,
+			upList[i].Candidate,
+			strings.Join(upList[i].Advisories, " "),
+		}
+	}
+
+	return c.emit(upList,
+		[]string{"name", "installed", "candidate", "advisories"},
+		rows)
+} // func (c *CLI) emitUpgrades(upList []backend.PendingUpgrade) error
+
+func (c *CLI) emitEdges(edges []backend.DepEdge) error {
+	var rows = make([][]string, len(edges))
+
+	for i, e := range edges {
+		rows[i] = []string{e.From, e.To}
+	}
+
+	return c.emit(edges,
+		[]string{"from", "to"},
+		rows)
+} // func (c *CLI) emitEdges(edges []backend.DepEdge) error
+
+// emitEvents writes a list of events. In CSV and TSV, timestamps are
+// rendered as RFC 3339.
+func (c *CLI) emitEvents(evList []event.Event) error {
+	var rows = make([][]string, len(evList))
+
+	if evList == nil {
+		evList = []event.Event{}
+	}
+
+	for i, ev := range evList {
+		rows[i] = []string{
+			strconv.FormatInt(ev.ID, 10),
+			ev.Timestamp.Format(time.RFC3339),
+			ev.End.Format(time.RFC3339),
+			ev.Type.String(),
+			strconv.FormatInt(ev.Status, 10),
+			ev.User,
+			strings.Join(ev.Packages, " "),
+			ev.Command,
+		}
+	}
+
+	return c.emit(evList,
+		[]string{"id", "timestamp", "end", "type", "status", "user", "packages", "command"},
+		rows)
+} // func (c *CLI) emitEvents(evList []event.Event) error
+
+// emitPlan writes the Plan of a transaction. CSV and TSV only cover the
+// changes, not the sizes.
+func (c *CLI) emitPlan(plan *backend.Plan) error {
+	var rows = make([][]string, len(plan.Changes))
+
+	for i, ch := range plan.Changes {
+		rows[i] = []string{
+			ch.Action.String(),
+			ch.Name,
+			ch.OldVersion,
+			ch.NewVersion,
+		}
+	}
+
+	return c.emit(plan,
+		[]string{"action", "name", "old_version", "new_version"},
+		rows)
+} // func (c *CLI) emitPlan(plan *backend.Plan) error
+
+// emitHistoryEntry writes a single event with the changes it made. CSV and
+// TSV only cover the changes.
+func (c *CLI) emitHistoryEntry(entry *historyEntry) error {
+	var rows = make([][]string, len(entry.Changes))
+
+	if entry.Changes == nil {
+		entry.Changes = []event.Change{}
+	}
+
+	for i, ch := range entry.Changes {
+		rows[i] = []string{
+			strconv.FormatInt(ch.EventID, 10),
+			ch.Action.String(),
+			ch.Package,
+			ch.OldVersion,
+			ch.NewVersion,
+		}
+	}
+
+	return c.emit(entry,
+		[]string{"event_id", "action", "package", "old_version", "new_version"},
+		rows)
+} // func (c *CLI) emitHistoryEntry(entry *historyEntry) error
@@ -0,0 +1,78 @@
+// /home/krylon/go/src/github.com/blicero/pkman/common/01_config_test.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+output = "json"
+exclude = ["linux-image-*"]
+lock_timeout = "90s"
+
+[log_levels]
+Database = "INFO"
+
+[aliases]
+u = "upgrade -security"
+`
+
+func TestLoadConfig(t *testing.T) {
+	var (
+		err  error
+		cfg  *Config
+		path = filepath.Join(t.TempDir(), "config.toml")
+	)
+
+	if err = os.WriteFile(path, []byte(testConfig), 0644); err != nil {
+		t.Fatalf("Cannot write test config: %s", err.Error())
+	}
+
+	t.Setenv("PKMAN_OUTPUT", "yaml")
+	t.Setenv("PKMAN_BACKEND", "")
+
+	if cfg, err = LoadConfig(path); err != nil {
+		t.Fatalf("Cannot load config %s: %s", path, err.Error())
+	} else if cfg.Output != "yaml" {
+		t.Errorf("Environment did not override output: %q", cfg.Output)
+	} else if cfg.LogLevel != MinLogLevel {
+		t.Errorf("Default log level was lost: %q", cfg.LogLevel)
+	} else if cfg.LogLevels["Database"] != "INFO" {
+		t.Errorf("Unexpected log levels: %v", cfg.LogLevels)
+	} else if cfg.Aliases["u"] != "upgrade -security" {
+		t.Errorf("Unexpected aliases: %v", cfg.Aliases)
+	} else if !cfg.Excluded("linux-image-6.1.0-13-amd64") {
+		t.Error("linux-image-6.1.0-13-amd64 should be excluded")
+	} else if cfg.LockTimeout != 90*time.Second {
+		t.Errorf("Unexpected lock timeout %s", cfg.LockTimeout)
+	} else if cfg.Excluded("linux-base") {
+		t.Error("linux-base should not be excluded")
+	}
+} // func TestLoadConfig(t *testing.T)
+
+func TestLoadConfigInvalid(t *testing.T) {
+	var cases = []string{
+		`bogus = 1`,
+		`escalate = "su"`,
+		`log_level = "LOUD"`,
+		"[log_levels]\nNoSuchDomain = \"INFO\"",
+	}
+
+	for _, c := range cases {
+		var path = filepath.Join(t.TempDir(), "config.toml")
+
+		if err := os.WriteFile(path, []byte(c), 0644); err != nil {
+			t.Fatalf("Cannot write test config: %s", err.Error())
+		} else if _, err = LoadConfig(path); err == nil {
+			t.Errorf("Config %q should have been rejected", c)
+		}
+	}
+} // func TestLoadConfigInvalid(t *testing.T)
@@ -15,6 +15,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"os/user"
 	"path/filepath"
 	"time"
 
@@ -61,7 +62,9 @@ var LogLevels = []logutils.LogLevel{
 // PackageLevels defines minimum log levels per package.
 var PackageLevels = make(map[logdomain.ID]logutils.LogLevel, len(LogLevels))
 
-const MinLogLevel = "TRACE"
+// MinLogLevel is the default minimum log level, which can be changed in the
+// configuration.
+const MinLogLevel = "INFO"
 
 func init() {
 	for _, id := range logdomain.AllDomains() {
@@ -69,26 +72,77 @@ func init() {
 	}
 } // func init()
 
+// SystemStateDir is the folder that holds the state when pkman runs as root,
+// so root runs and sudo runs share one history database.
+const SystemStateDir = "/var/lib/pkman"
+
 // BaseDir is the folder where all application-specific files (database,
 // log files, etc) are stored.
 // LogPath is the file to the log path.
 // DbPath is the path of the main database.
-// HostCachePath is the path to the IP cache.
-// XfrDbgPath is the path of the folder where data on DNS zone transfers
-// are stored.
 var (
-	BaseDir = filepath.Join(os.Getenv("HOME"), "pkman.d")
+	BaseDir = DefaultBaseDir()
 	LogPath = filepath.Join(BaseDir, "pkman.log")
 	DbPath  = filepath.Join(BaseDir, "pkman.db")
 )
 
+// DefaultBaseDir returns the folder to keep our state in.
+// For root, this is SystemStateDir. Everyone else gets the pkman folder in
+// $XDG_STATE_HOME, which defaults to ~/.local/state. If that does not exist,
+// but ~/pkman.d, where earlier versions kept their state, does, we stick
+// with the latter, so the history is not lost.
+func DefaultBaseDir() string {
+	if os.Geteuid() == 0 {
+		return SystemStateDir
+	}
+
+	var (
+		home     = os.Getenv("HOME")
+		stateDir = os.Getenv("XDG_STATE_HOME")
+		legacy   = filepath.Join(home, "pkman.d")
+	)
+
+	if stateDir == "" || !filepath.IsAbs(stateDir) {
+		// The XDG spec says relative paths are to be ignored.
+		stateDir = filepath.Join(home, ".local", "state")
+	}
+
+	stateDir = filepath.Join(stateDir, AppName)
+
+	if _, err := os.Stat(stateDir); os.IsNotExist(err) {
+		if st, err := os.Stat(legacy); err == nil && st.IsDir() {
+			return legacy
+		}
+	}
+
+	return stateDir
+} // func DefaultBaseDir() string
+
+// InvokingUser returns the name of the user who runs pkman. If pkman runs
+// as root via sudo or doas, that is the user who ran sudo or doas.
+func InvokingUser() string {
+	if os.Geteuid() == 0 {
+		for _, v := range []string{"SUDO_USER", "DOAS_USER"} {
+			if name := os.Getenv(v); name != "" {
+				return name
+			}
+		}
+	}
+
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+
+	return os.Getenv("USER")
+} // func InvokingUser() string
+
 // SetBaseDir sets the BaseDir and related variables.
 func SetBaseDir(path string) error {
 	fmt.Printf("Setting BASE_DIR to %s\n", path)
 
 	BaseDir = path
-	LogPath = filepath.Join(BaseDir, "guang.log")
-	DbPath = filepath.Join(BaseDir, "guang.db")
+	LogPath = filepath.Join(BaseDir, "pkman.log")
+	DbPath = filepath.Join(BaseDir, "pkman.db")
 
 	if err := InitApp(); err != nil {
 		fmt.Printf("Error initializing application environment: %s\n", err.Error())
@@ -100,6 +154,8 @@ func SetBaseDir(path string) error {
 
 // GetLogger Tries to create a named logger instance and return it.
 // If the directory to hold the log file does not exist, try to create it.
+// The logger writes to the log file and to stderr, never to stdout, which
+// belongs to the output of the CLI.
 func GetLogger(dom logdomain.ID) (*log.Logger, error) {
 	var err error
 	err = InitApp()
@@ -115,20 +171,25 @@ func GetLogger(dom logdomain.ID) (*log.Logger, error) {
 	logfile, err = os.OpenFile(LogPath, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
 	if err != nil {
 		msg := fmt.Sprintf("Error opening log file: %s\n", err.Error())
-		fmt.Println(msg)
+		fmt.Fprintln(os.Stderr, msg)
 		return nil, errors.New(msg)
 	}
 
-	writer := io.MultiWriter(os.Stdout, logfile)
+	writer := &logutils.LevelFilter{
+		Levels:   LogLevels,
+		MinLevel: PackageLevels[dom],
+		Writer:   io.MultiWriter(os.Stderr, logfile),
+	}
 
 	logger := log.New(writer, logName, log.Ldate|log.Ltime|log.Lshortfile)
 	return logger, nil
 } // func GetLogger(name string) (*log.logger, error)
 
 // InitApp performs some basic preparations for the application to run.
-// Currently, this means creating the BASE_DIR folder.
+// Currently, this means creating the BASE_DIR folder, along with any missing
+// parents.
 func InitApp() error {
-	err := os.Mkdir(BaseDir, 0755)
+	err := os.MkdirAll(BaseDir, 0755)
 	if err != nil {
 		if !os.IsExist(err) {
 			msg := fmt.Sprintf("Error creating BASE_DIR %s: %s", BaseDir, err.Error())
@@ -155,6 +216,17 @@ func TimeEqual(t1, t2 time.Time) bool {
 	return delta < time.Second
 } // func TimeEqual(t1, t2 time.Time) bool
 
+// Contains returns true if list contains s.
+func Contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+} // func Contains(list []string, s string) bool
+
 // GetChecksum computes the SHA512 checksum of the given data.
 func GetChecksum(data []byte) (string, error) {
 	var err error
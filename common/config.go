@@ -0,0 +1,263 @@
+// /home/krylon/go/src/github.com/blicero/pkman/common/config.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/blicero/pkman/logdomain"
+	"github.com/hashicorp/logutils"
+)
+
+// SystemConfigPath is the location of the system-wide configuration file.
+const SystemConfigPath = "/etc/pkman.conf"
+
+// Config holds the settings that can be changed at runtime.
+//
+// Settings are taken from, in increasing order of precedence:
+//
+//  1. the built-in defaults
+//  2. the system-wide config file, /etc/pkman.conf
+//  3. the user's config file, config.toml in the pkman folder of the user's
+//     configuration directory, e.g. ~/.config/pkman/config.toml
+//  4. environment variables (PKMAN_BACKEND, PKMAN_ESCALATE, PKMAN_OUTPUT,
+//     PKMAN_LOG_LEVEL, PKMAN_LOCK_TIMEOUT, PKMAN_TIMEOUT)
+//  5. command line flags
+//
+// If PKMAN_CONFIG or the -config flag name a file, it is read instead of the
+// user's config file. Missing config files are not an error.
+// Later files only override the keys they set. Tables, like log_levels and
+// aliases, are merged key by key, lists, like exclude, replace each other.
+type Config struct {
+	// Backend is the name of the system whose package manager to use,
+	// e.g. "debian", "fedora", "opensuse", "arch", "freebsd", "openbsd".
+	// If it is empty, we detect the system we are running on.
+	// "fake:<catalog>" uses a simulated package manager working on the
+	// packages listed in a JSON or YAML file, see backend.PkgFake.
+	Backend string `toml:"backend"`
+	// Escalate is the command used to gain root privileges for
+	// operations that change the system: "sudo", "doas", "pkexec", or
+	// "none". If it is empty, we pick the first one that is installed.
+	Escalate string `toml:"escalate"`
+	// Output is the default output format of the CLI.
+	Output string `toml:"output"`
+	// LogLevel is the minimum log level for all domains, LogLevels
+	// overrides it for individual domains, e.g. Database = "INFO".
+	LogLevel  string            `toml:"log_level"`
+	LogLevels map[string]string `toml:"log_levels"`
+	// Aliases maps additional command names to a command line, e.g.
+	// u = "upgrade -security". Aliases cannot override built-in commands.
+	Aliases map[string]string `toml:"aliases"`
+	// Exclude is a list of shell patterns of packages pkman must not
+	// install or upgrade. The package manager is told to skip them, and
+	// asking for one of them by name is refused.
+	Exclude []string `toml:"exclude"`
+	// LockTimeout is how long to wait for another process to release
+	// its lock on the package database, e.g. "90s" or "10m".
+	LockTimeout time.Duration `toml:"lock_timeout"`
+	// Timeout is how long a command may take before it is stopped,
+	// including the time spent waiting for the lock. Zero means no limit.
+	Timeout time.Duration `toml:"timeout"`
+}
+
+// DefaultConfig returns a Config holding the built-in defaults.
+func DefaultConfig() *Config {
+	return &Config{
+		Output:    "text",
+		LogLevel:  MinLogLevel,
+		LogLevels: make(map[string]string),
+		Aliases:   make(map[string]string),
+		Exclude:   []string{},
+		// unattended-upgrades and friends can take a while.
+		LockTimeout: 5 * time.Minute,
+	}
+} // func DefaultConfig() *Config
+
+// UserConfigPath returns the path of the user's config file.
+func UserConfigPath() string {
+	var (
+		err error
+		dir string
+	)
+
+	if dir, err = os.UserConfigDir(); err != nil {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+
+	return filepath.Join(dir, AppName, "config.toml")
+} // func UserConfigPath() string
+
+// LoadConfig reads the configuration from the system-wide config file and
+// the user's config file, or the file given by userPath, if it is not empty,
+// and applies the environment variables on top.
+func LoadConfig(userPath string) (*Config, error) {
+	var (
+		err error
+		cfg = DefaultConfig()
+	)
+
+	if userPath == "" {
+		if userPath = os.Getenv("PKMAN_CONFIG"); userPath == "" {
+			userPath = UserConfigPath()
+		}
+	}
+
+	for _, p := range []string{SystemConfigPath, userPath} {
+		if err = cfg.readFile(p); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = cfg.applyEnv(); err != nil {
+		return nil, err
+	} else if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+} // func LoadConfig(userPath string) (*Config, error)
+
+// readFile merges the settings from the given file into the Config.
+// A missing file is silently ignored.
+func (cfg *Config) readFile(p string) error {
+	var (
+		err error
+		md  toml.MetaData
+	)
+
+	if md, err = toml.DecodeFile(p, cfg); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("Cannot read config file %s: %w", p, err)
+	} else if undec := md.Undecoded(); len(undec) > 0 {
+		return fmt.Errorf("Unknown setting %q in config file %s",
+			undec[0].String(),
+			p)
+	}
+
+	return nil
+} // func (cfg *Config) readFile(p string) error
+
+// applyEnv overrides settings with the values of the corresponding
+// environment variables, if they are set.
+func (cfg *Config) applyEnv() error {
+	var env = map[string]*string{
+		"PKMAN_BACKEND":   &cfg.Backend,
+		"PKMAN_ESCALATE":  &cfg.Escalate,
+		"PKMAN_OUTPUT":    &cfg.Output,
+		"PKMAN_LOG_LEVEL": &cfg.LogLevel,
+	}
+
+	for name, val := range env {
+		if s, ok := os.LookupEnv(name); ok && s != "" {
+			*val = s
+		}
+	}
+
+	var durations = map[string]*time.Duration{
+		"PKMAN_LOCK_TIMEOUT": &cfg.LockTimeout,
+		"PKMAN_TIMEOUT":      &cfg.Timeout,
+	}
+
+	for name, val := range durations {
+		if s := os.Getenv(name); s != "" {
+			var err error
+
+			if *val, err = time.ParseDuration(s); err != nil {
+				return fmt.Errorf("Invalid %s %q: %w", name, s, err)
+			}
+		}
+	}
+
+	return nil
+} // func (cfg *Config) applyEnv() error
+
+// Validate checks the Config for invalid values.
+func (cfg *Config) Validate() error {
+	switch cfg.Escalate {
+	case "", "sudo", "doas", "pkexec", "none":
+	default:
+		return fmt.Errorf("Invalid escalation command %q", cfg.Escalate)
+	}
+
+	if cfg.LockTimeout < 0 {
+		return fmt.Errorf("Invalid lock timeout %s", cfg.LockTimeout)
+	} else if cfg.Timeout < 0 {
+		return fmt.Errorf("Invalid timeout %s", cfg.Timeout)
+	} else if !validLogLevel(cfg.LogLevel) {
+		return fmt.Errorf("Invalid log level %q", cfg.LogLevel)
+	}
+
+	for dom, lvl := range cfg.LogLevels {
+		if _, ok := parseDomain(dom); !ok {
+			return fmt.Errorf("Invalid log domain %q", dom)
+		} else if !validLogLevel(lvl) {
+			return fmt.Errorf("Invalid log level %q for domain %s", lvl, dom)
+		}
+	}
+
+	for _, pat := range cfg.Exclude {
+		if _, err := path.Match(pat, ""); err != nil {
+			return fmt.Errorf("Invalid exclude pattern %q: %w", pat, err)
+		}
+	}
+
+	return nil
+} // func (cfg *Config) Validate() error
+
+// ApplyLogLevels sets the minimum log level of each domain according to the
+// Config. It has to be called before the loggers are created.
+func (cfg *Config) ApplyLogLevels() {
+	for _, id := range logdomain.AllDomains() {
+		PackageLevels[id] = logutils.LogLevel(strings.ToUpper(cfg.LogLevel))
+	}
+
+	for dom, lvl := range cfg.LogLevels {
+		if id, ok := parseDomain(dom); ok {
+			PackageLevels[id] = logutils.LogLevel(strings.ToUpper(lvl))
+		}
+	}
+} // func (cfg *Config) ApplyLogLevels()
+
+// Excluded returns true if the package matches one of the exclude patterns.
+func (cfg *Config) Excluded(name string) bool {
+	for _, pat := range cfg.Exclude {
+		if ok, _ := path.Match(pat, name); ok {
+			return true
+		}
+	}
+
+	return false
+} // func (cfg *Config) Excluded(name string) bool
+
+func validLogLevel(lvl string) bool {
+	for _, l := range LogLevels {
+		if strings.EqualFold(string(l), lvl) {
+			return true
+		}
+	}
+
+	return false
+} // func validLogLevel(lvl string) bool
+
+func parseDomain(name string) (logdomain.ID, bool) {
+	for _, id := range logdomain.AllDomains() {
+		if strings.EqualFold(id.String(), name) {
+			return id, true
+		}
+	}
+
+	return 0, false
+} // func parseDomain(name string) (logdomain.ID, bool)
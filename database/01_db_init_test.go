@@ -7,6 +7,10 @@
 package database
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
 	"testing"
 
 	"github.com/blicero/pkman/common"
@@ -33,7 +37,7 @@ func TestQueries(t *testing.T) {
 	}
 
 	for id, str := range qDb {
-		if _, err = db.getQuery(id); err != nil {
+		if _, err = db.getQuery(context.Background(), id); err != nil {
 			t.Errorf("Failed to prepare query %s: %s\n%s",
 				id,
 				err.Error(),
@@ -41,3 +45,118 @@ func TestQueries(t *testing.T) {
 		}
 	}
 } // func TestQueries(t *testing.T)
+
+func TestMigrationOrder(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("Migration #%d has version %d, expected %d",
+				i,
+				m.version,
+				i+1)
+		} else if m.desc == "" || len(m.queries) == 0 {
+			t.Errorf("Migration %d lacks a description or queries", m.version)
+		}
+	}
+} // func TestMigrationOrder(t *testing.T)
+
+// TestUpgrade checks that a database created before we kept track of the
+// schema version, when the event table only had its first four columns, is
+// backed up and brought up to date.
+func TestUpgrade(t *testing.T) {
+	var (
+		err     error
+		raw     *sql.DB
+		old     *Database
+		path    = filepath.Join(common.BaseDir, "old.db")
+		count   int
+		version int
+		tracked bool
+		backups []string
+	)
+
+	if raw, err = sql.Open("sqlite3", path); err != nil {
+		t.Fatalf("Cannot create database at %s: %s", path, err.Error())
+	} else if _, err = raw.Exec(`
+CREATE TABLE event (
+    id		INTEGER PRIMARY KEY,
+    event	INTEGER NOT NULL,
+    timestamp	INTEGER NOT NULL,
+    status	INTEGER NOT NULL
+) STRICT`); err != nil {
+		t.Fatalf("Cannot create old event table: %s", err.Error())
+	} else if _, err = raw.Exec("INSERT INTO event (event, timestamp, status) VALUES (3, 1682000000, 0)"); err != nil {
+		t.Fatalf("Cannot add old event: %s", err.Error())
+	}
+
+	raw.Close() // nolint: errcheck
+
+	if old, err = OpenDB(path); err != nil {
+		t.Fatalf("Cannot open old database: %s", err.Error())
+	}
+
+	defer old.Close()
+
+	if err = old.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('event')").Scan(&count); err != nil {
+		t.Fatalf("Cannot inspect event table: %s", err.Error())
+	} else if count != 9 {
+		t.Errorf("Expected 9 columns in the event table, got %d", count)
+	} else if evList, err := old.EventGetRecent(context.Background(), 10); err != nil {
+		t.Errorf("Cannot load old events: %s", err.Error())
+	} else if len(evList) != 1 || evList[0].Duration() != 0 {
+		t.Errorf("Unexpected events in old database: %v", evList)
+	} else if chgList, err := old.ChangeGetByEvent(context.Background(), evList[0].ID); err != nil {
+		t.Errorf("Cannot load changes from old database: %s", err.Error())
+	} else if len(chgList) != 0 {
+		t.Errorf("Unexpected changes in old database: %v", chgList)
+	}
+
+	if version, tracked, err = old.schemaVersion(); err != nil {
+		t.Errorf("Cannot get schema version: %s", err.Error())
+	} else if version != SchemaVersion() || !tracked {
+		t.Errorf("Database has schema version %d (tracked: %t), expected %d",
+			version,
+			tracked,
+			SchemaVersion())
+	}
+
+	if backups, err = filepath.Glob(path + ".v1-*.bak"); err != nil {
+		t.Errorf("Cannot look for backup: %s", err.Error())
+	} else if len(backups) != 1 {
+		t.Errorf("Expected 1 backup of the old database, found %d", len(backups))
+	} else if raw, err = sql.Open("sqlite3", backups[0]); err != nil {
+		t.Errorf("Cannot open backup %s: %s", backups[0], err.Error())
+	} else {
+		defer raw.Close() // nolint: errcheck
+
+		if err = raw.QueryRow("SELECT COUNT(*) FROM pragma_table_info('event')").Scan(&count); err != nil {
+			t.Errorf("Cannot inspect event table of backup: %s", err.Error())
+		} else if count != 4 {
+			t.Errorf("Expected 4 columns in the event table of the backup, got %d", count)
+		}
+	}
+} // func TestUpgrade(t *testing.T)
+
+func TestSchemaTooNew(t *testing.T) {
+	var (
+		err  error
+		tmp  *Database
+		path = filepath.Join(common.BaseDir, "new.db")
+	)
+
+	if tmp, err = OpenDB(path); err != nil {
+		t.Fatalf("Cannot create database at %s: %s", path, err.Error())
+	} else if _, err = tmp.db.Exec("INSERT INTO schema_version (version, timestamp) VALUES (?, 0)",
+		SchemaVersion()+1); err != nil {
+		tmp.Close()
+		t.Fatalf("Cannot bump schema version: %s", err.Error())
+	}
+
+	tmp.Close()
+
+	if tmp, err = OpenDB(path); err == nil {
+		tmp.Close()
+		t.Error("Opening a database with a newer schema should fail")
+	} else if !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Expected ErrSchemaTooNew, got %s", err.Error())
+	}
+} // func TestSchemaTooNew(t *testing.T)
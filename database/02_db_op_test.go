@@ -7,10 +7,13 @@
 package database
 
 import (
+	"context"
 	"math/rand"
+	"reflect"
 	"testing"
 	"time"
 
+	"github.com/blicero/pkman/database/action"
 	"github.com/blicero/pkman/database/event"
 )
 
@@ -30,6 +33,11 @@ func randomEvent(ev *event.Event) {
 	} else {
 		ev.Status = rand.Int63n(254) + 1
 	}
+	ev.End = evStamp.Add(time.Duration(rand.Intn(600)) * time.Second)
+	ev.Command = "apt-get -y install yasr"
+	ev.User = "krylon"
+	ev.Packages = []string{"yasr"}
+	ev.Output = "Setting up yasr (0.6.9-11) ...\n"
 	evStamp = evStamp.Add(time.Hour)
 } // func randomEvent(ev *event.Event)
 
@@ -46,7 +54,7 @@ func TestEventAdd(t *testing.T) {
 
 		randomEvent(ev)
 
-		if err = db.EventAdd(ev); err != nil {
+		if err = db.EventAdd(context.Background(), ev); err != nil {
 			t.Errorf("Failed to add Event #%d: %s",
 				i,
 				err.Error())
@@ -68,7 +76,7 @@ func TestEventGetRecent(t *testing.T) {
 			cnt    = rand.Intn(evCnt) + 1
 		)
 
-		if evList, err = db.EventGetRecent(cnt); err != nil {
+		if evList, err = db.EventGetRecent(context.Background(), cnt); err != nil {
 			t.Errorf("Error fetching %d recent events: %s",
 				cnt,
 				err.Error())
@@ -79,3 +87,107 @@ func TestEventGetRecent(t *testing.T) {
 		}
 	}
 } // func TestEventGetRecent(t *testing.T)
+
+func TestEventFields(t *testing.T) {
+	var (
+		err    error
+		evList []event.Event
+		last   = initEvents[evCnt-1]
+	)
+
+	if db == nil {
+		t.SkipNow()
+	} else if evList, err = db.EventGetRecent(context.Background(), 1); err != nil {
+		t.Fatalf("Error fetching the most recent event: %s", err.Error())
+	} else if len(evList) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(evList))
+	}
+
+	var ev = evList[0]
+
+	if ev.ID != last.ID ||
+		!ev.Timestamp.Equal(last.Timestamp.Truncate(time.Second)) ||
+		!ev.End.Equal(last.End.Truncate(time.Second)) ||
+		ev.Command != last.Command ||
+		ev.User != last.User ||
+		!reflect.DeepEqual(ev.Packages, last.Packages) {
+		t.Errorf("Event was not stored faithfully:\nstored: %#v\nloaded: %#v",
+			last,
+			ev)
+	}
+} // func TestEventFields(t *testing.T)
+
+func TestEventGetByID(t *testing.T) {
+	var (
+		err  error
+		ev   *event.Event
+		last = initEvents[evCnt-1]
+	)
+
+	if db == nil {
+		t.SkipNow()
+	} else if ev, err = db.EventGetByID(context.Background(), last.ID); err != nil {
+		t.Fatalf("Cannot load Event %d: %s", last.ID, err.Error())
+	} else if ev == nil {
+		t.Fatalf("Event %d was not found", last.ID)
+	} else if ev.Type != last.Type || ev.Output != last.Output || ev.Command != last.Command {
+		t.Errorf("Event was not stored faithfully:\nstored: %#v\nloaded: %#v",
+			last,
+			ev)
+	}
+
+	if ev, err = db.EventGetByID(context.Background(), last.ID+1000); err != nil {
+		t.Errorf("Cannot look for missing Event: %s", err.Error())
+	} else if ev != nil {
+		t.Errorf("Found Event that does not exist: %#v", ev)
+	}
+} // func TestEventGetByID(t *testing.T)
+
+func TestChanges(t *testing.T) {
+	if db == nil {
+		t.SkipNow()
+	}
+
+	var (
+		err     error
+		chgList []event.Change
+		first   = &initEvents[0]
+		last    = &initEvents[evCnt-1]
+		changes = []event.Change{
+			{EventID: first.ID, Package: "yasr", Action: action.Install, NewVersion: "0.6.9-10"},
+			{EventID: last.ID, Package: "emacs", Action: action.Install, NewVersion: "29.1"},
+			{EventID: last.ID, Package: "yasr", Action: action.Upgrade, OldVersion: "0.6.9-10", NewVersion: "0.6.9-11"},
+		}
+	)
+
+	for i := range changes {
+		if err = db.ChangeAdd(context.Background(), &changes[i]); err != nil {
+			t.Fatalf("Cannot add change #%d: %s", i, err.Error())
+		} else if changes[i].ID == 0 {
+			t.Errorf("ChangeAdd did not set an ID for change #%d", i)
+		}
+	}
+
+	if chgList, err = db.ChangeGetByEvent(context.Background(), last.ID); err != nil {
+		t.Errorf("Cannot load changes of Event %d: %s", last.ID, err.Error())
+	} else if len(chgList) != 2 ||
+		chgList[0].Package != "emacs" ||
+		chgList[1].OldVersion != "0.6.9-10" ||
+		!chgList[1].Timestamp.Equal(last.Timestamp.Truncate(time.Second)) {
+		t.Errorf("Unexpected changes of Event %d: %v", last.ID, chgList)
+	}
+
+	if chgList, err = db.ChangeGetByPackage(context.Background(), "yasr"); err != nil {
+		t.Errorf("Cannot load history of yasr: %s", err.Error())
+	} else if len(chgList) != 2 || chgList[0].Action != action.Upgrade || chgList[1].Action != action.Install {
+		t.Errorf("Unexpected history of yasr: %v", chgList)
+	}
+
+	var day = first.Timestamp.Truncate(24 * time.Hour)
+
+	if chgList, err = db.ChangeGetByPeriod(context.Background(), day, day.Add(24*time.Hour)); err != nil {
+		t.Errorf("Cannot load changes on %s: %s", day.Format("2006-01-02"), err.Error())
+	} else if len(chgList) != 1 || chgList[0].ID != changes[0].ID {
+		t.Errorf("Unexpected changes on %s: %v", day.Format("2006-01-02"), chgList)
+	}
+} // func TestChanges(t *testing.T)
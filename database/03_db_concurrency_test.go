@@ -0,0 +1,147 @@
+// /home/krylon/go/src/github.com/blicero/pkman/database/03_db_concurrency_test.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blicero/pkman/common"
+	"github.com/blicero/pkman/database/action"
+	"github.com/blicero/pkman/database/event"
+)
+
+func TestRetry(t *testing.T) {
+	var (
+		err    error
+		cnt    int
+		locked = errors.New("database is locked")
+		ctx    = context.Background()
+	)
+
+	if err = retry(ctx, func() error { cnt++; return locked }); !errors.Is(err, locked) {
+		t.Errorf("Expected the last error, got %v", err)
+	} else if cnt != maxRetries {
+		t.Errorf("Expected %d attempts, got %d", maxRetries, cnt)
+	}
+
+	cnt = 0
+
+	if err = retry(ctx, func() error { cnt++; return errors.New("no such table") }); err == nil || cnt != 1 {
+		t.Errorf("Errors not worth a retry should not be retried: %d attempts, error %v", cnt, err)
+	}
+
+	var cancelled, cancel = context.WithCancel(ctx)
+
+	cancel()
+	cnt = 0
+
+	if err = retry(cancelled, func() error { cnt++; return locked }); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	} else if cnt != 1 {
+		t.Errorf("Expected 1 attempt, got %d", cnt)
+	}
+} // func TestRetry(t *testing.T)
+
+// TestConcurrentUse adds events from several goroutines at once, half of
+// them through a second connection to the same file, so they compete for
+// the lock on it.
+func TestConcurrentUse(t *testing.T) {
+	const (
+		workers = 8
+		rounds  = 25
+	)
+
+	var (
+		err    error
+		dbs    [2]*Database
+		wg     sync.WaitGroup
+		errs   = make(chan error, workers*rounds)
+		path   = filepath.Join(common.BaseDir, "concurrent.db")
+		ctx    = context.Background()
+		evList []event.Event
+	)
+
+	for i := range dbs {
+		if dbs[i], err = OpenDB(path); err != nil {
+			t.Fatalf("Cannot open database at %s: %s", path, err.Error())
+		}
+
+		defer dbs[i].Close()
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			var db = dbs[w%2]
+
+			for r := 0; r < rounds; r++ {
+				if err := addWithChange(ctx, db, w, r); err != nil {
+					errs <- err
+				} else if _, err = db.EventGetRecent(ctx, 5); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err = range errs {
+		t.Error(err)
+	}
+
+	if evList, err = dbs[0].EventGetRecent(ctx, -1); err != nil {
+		t.Fatalf("Cannot load events: %s", err.Error())
+	} else if len(evList) != workers*rounds {
+		t.Fatalf("Expected %d events, got %d", workers*rounds, len(evList))
+	}
+
+	for _, ev := range evList {
+		var chgList []event.Change
+
+		if chgList, err = dbs[1].ChangeGetByEvent(ctx, ev.ID); err != nil {
+			t.Errorf("Cannot load changes of Event %d: %s", ev.ID, err.Error())
+		} else if len(chgList) != 1 || chgList[0].Package != ev.Packages[0] {
+			t.Errorf("Unexpected changes of Event %d: %v", ev.ID, chgList)
+		}
+	}
+} // func TestConcurrentUse(t *testing.T)
+
+func addWithChange(ctx context.Context, db *Database, w, r int) error {
+	var (
+		err error
+		tx  *Tx
+		pkg = fmt.Sprintf("pkg-%d-%d", w, r)
+		ev  = &event.Event{
+			Type:      event.Add,
+			Timestamp: time.Now(),
+			End:       time.Now(),
+			Packages:  []string{pkg},
+		}
+	)
+
+	if tx, err = db.Begin(ctx); err != nil {
+		return err
+	} else if err = tx.EventAdd(ctx, ev); err != nil {
+		tx.Rollback() // nolint: errcheck
+		return err
+	} else if err = tx.ChangeAdd(ctx, &event.Change{EventID: ev.ID, Package: pkg, Action: action.Install, NewVersion: "1.0"}); err != nil {
+		tx.Rollback() // nolint: errcheck
+		return err
+	}
+
+	return tx.Commit()
+} // func addWithChange(ctx context.Context, db *Database, w, r int) error
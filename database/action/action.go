@@ -0,0 +1,41 @@
+// /home/krylon/go/src/github.com/blicero/pkman/database/action/action.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+//go:generate stringer -type=ID
+
+// Package action provides symbolic constants for the things that can happen
+// to a single package in the course of a transaction. The backends use them
+// in a Plan, the database to record what an event did to each package.
+package action
+
+// ID is the type used to represent actions.
+type ID uint8
+
+// These constants represent the changes a transaction can make to a package.
+const (
+	Install ID = iota
+	Upgrade
+	Downgrade
+	Remove
+	Reinstall
+)
+
+// AllActions returns a slice of all defined values of ID.
+func AllActions() []ID {
+	return []ID{
+		Install,
+		Upgrade,
+		Downgrade,
+		Remove,
+		Reinstall,
+	}
+} // func AllActions() []ID
+
+// MarshalText renders the ID by its name, so actions show up readably in
+// JSON or YAML output.
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+} // func (id ID) MarshalText() ([]byte, error)
@@ -9,12 +9,14 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log"
 	"os"
 	"regexp"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,29 +34,73 @@ var (
 	retryPat *regexp.Regexp = regexp.MustCompile("(?i)(database is locked|busy)")
 )
 
+// When SQLite tells us the database is locked by another connection, we try
+// again a few times, waiting twice as long after each attempt.
 const (
-	retryDelay = 10 * time.Millisecond
+	retryDelay    = 10 * time.Millisecond
+	maxRetryDelay = time.Second
+	maxRetries    = 10
 )
 
 func worthARetry(err error) bool {
 	return retryPat.MatchString(err.Error())
 } // func (db *Database) worth_a_retry(err error) bool
 
-func waitForRetry() {
-	time.Sleep(retryDelay)
-}
+// retry calls fn until it succeeds, or fails with an error that is not worth
+// a retry. It gives up after maxRetries attempts, or when the context is
+// cancelled while it waits.
+func retry(ctx context.Context, fn func() error) error {
+	var (
+		err   error
+		delay = retryDelay
+	)
+
+	for i := 1; ; i++ {
+		if err = fn(); err == nil || !worthARetry(err) {
+			return err
+		} else if i == maxRetries {
+			return fmt.Errorf("Giving up after %d attempts: %w", i, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w while waiting to retry: %s", ctx.Err(), err.Error())
+		case <-time.After(delay):
+		}
+
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+} // func retry(ctx context.Context, fn func() error) error
+
+// capacity returns the capacity to allocate for the results of a query
+// limited to n rows, where n == -1 means there is no limit.
+func capacity(n int) int {
+	if n < 0 {
+		return 0
+	}
+
+	return n
+} // func capacity(n int) int
 
 // Database wraps the database connection and its associated state and exposes
 // the operations we can perform on it.
+// A Database is safe for concurrent use by multiple goroutines. Each
+// operation runs in a transaction of its own, unless it is performed through
+// a Tx.
 type Database struct {
 	db        *sql.DB
+	lock      sync.Mutex // protects stmtTable
 	stmtTable map[query.ID]*sql.Stmt
-	tx        *sql.Tx
 	log       *log.Logger
 	path      string
 }
 
 // OpenDB opens a new database connection.
+// The schema of the database is migrated to the current version, see
+// SchemaVersion. If the database was migrated by a newer version of pkman,
+// OpenDB fails with ErrSchemaTooNew.
 func OpenDB(path string) (*Database, error) {
 	var err error
 	var msg string
@@ -67,7 +113,7 @@ func OpenDB(path string) (*Database, error) {
 
 	if db.log, err = common.GetLogger(logdomain.Database); err != nil {
 		msg = fmt.Sprintf("Error creating logger for Database: %s", err.Error())
-		fmt.Println(msg)
+		fmt.Fprintln(os.Stderr, msg)
 		return nil, errors.New(msg)
 	}
 
@@ -85,382 +131,393 @@ func OpenDB(path string) (*Database, error) {
 		msg = fmt.Sprintf("Error opening database at %s: %s", path, err.Error())
 		db.log.Println(msg)
 		return nil, errors.New(msg)
-	} else if !dbExists {
+	}
+
+	if !dbExists {
 		db.log.Printf("Initializing fresh database at %s...\n", path)
-		if err = db.initialize(); err != nil {
-			msg = fmt.Sprintf("Error initializing database at %s: %s",
-				path, err.Error())
-			db.log.Println(msg)
-			db.db.Close()
+	}
+
+	if err = db.migrate(); err != nil {
+		db.log.Printf("[ERROR] Cannot migrate database at %s: %s\n",
+			path,
+			err.Error())
+		db.db.Close()
+		if !dbExists {
 			os.Remove(path)
-			return nil, errors.New(msg)
 		}
+		return nil, err
 	}
 
 	return db, nil
 } // func OpenDB(path string) (*Database, error)
 
-func (db *Database) getQuery(qid query.ID) (*sql.Stmt, error) {
-	if stmt, ok := db.stmtTable[qid]; ok {
-		return stmt, nil
-	}
+// getQuery returns the prepared statement for the given query, preparing it
+// the first time it is asked for.
+func (db *Database) getQuery(ctx context.Context, qid query.ID) (*sql.Stmt, error) {
+	var (
+		err  error
+		stmt *sql.Stmt
+		ok   bool
+	)
 
-	var stmt *sql.Stmt
-	var err error
-	var msg string
+	db.lock.Lock()
+	defer db.lock.Unlock()
 
-PREPARE_QUERY:
-	if stmt, err = db.db.Prepare(qDb[qid]); err != nil {
-		if worthARetry(err) {
-			time.Sleep(retryDelay)
-			goto PREPARE_QUERY
-		} else {
-			msg = fmt.Sprintf("Error preparing query %s %s\n\n%s\n",
-				qid, err.Error(), qDb[qid])
-			db.log.Println(msg)
-			return nil, errors.New(msg)
-		}
-	} else {
-		db.stmtTable[qid] = stmt
+	if db.stmtTable == nil {
+		return nil, errors.New("Database is closed")
+	} else if stmt, ok = db.stmtTable[qid]; ok {
 		return stmt, nil
 	}
-} // func (db *Database) getQuery(stmt_id query.QueryID) (*sql.Stmt, error)
-
-// Begin starts a transaction
-func (db *Database) Begin() error {
-	var err error
-	var msg string
-	var tx *sql.Tx
 
-	if db.tx != nil {
-		msg = "Cannot start transaction: A transaction is already in progress!"
+	if err = retry(ctx, func() (err error) {
+		stmt, err = db.db.PrepareContext(ctx, qDb[qid])
+		return err
+	}); err != nil {
+		var msg = fmt.Sprintf("Error preparing query %s %s\n\n%s\n",
+			qid, err.Error(), qDb[qid])
 		db.log.Println(msg)
-		return errors.New(msg)
+		return nil, errors.New(msg)
 	}
 
-BEGIN:
-	if tx, err = db.db.Begin(); err != nil {
-		if worthARetry(err) {
-			time.Sleep(retryDelay)
-			goto BEGIN
-		} else {
-			msg = fmt.Sprintf("Cannot start transaction: %s", err.Error())
-			db.log.Println(msg)
-			return errors.New(msg)
-		}
-	} else {
-		db.tx = tx
-		return nil
+	db.stmtTable[qid] = stmt
+	return stmt, nil
+} // func (db *Database) getQuery(ctx context.Context, qid query.ID) (*sql.Stmt, error)
+
+// exec runs a query that modifies the database, in the given transaction, or
+// on its own, if tx is nil.
+func (db *Database) exec(ctx context.Context, tx *sql.Tx, qid query.ID, args ...any) (sql.Result, error) {
+	var (
+		err  error
+		stmt *sql.Stmt
+		res  sql.Result
+	)
+
+	if stmt, err = db.getQuery(ctx, qid); err != nil {
+		db.log.Printf("[ERROR] Cannot prepare query %s: %s\n",
+			qid,
+			err.Error())
+		return nil, err
+	} else if tx != nil {
+		stmt = tx.StmtContext(ctx, stmt)
 	}
-} // func (db *Database) Begin() error
 
-// Rollback aborts a transaction
-func (db *Database) Rollback() error {
-	var err error
-	var msg string
+	err = retry(ctx, func() (err error) {
+		res, err = stmt.ExecContext(ctx, args...)
+		return err
+	})
 
-	if db.tx == nil {
-		msg = "Cannot roll back transaction: No transaction is active!"
-		db.log.Println(msg)
-		return errors.New(msg)
-	} else if err = db.tx.Rollback(); err != nil {
-		msg = fmt.Sprintf("Cannot roll back transaction: %s", err.Error())
-		db.log.Println(msg)
-		return errors.New(msg)
-	} else {
-		db.tx = nil
-		return nil
+	return res, err
+} // func (db *Database) exec(ctx context.Context, tx *sql.Tx, qid query.ID, args ...any) (sql.Result, error)
+
+// query runs a query that reads from the database.
+func (db *Database) query(ctx context.Context, qid query.ID, args ...any) (*sql.Rows, error) {
+	var (
+		err  error
+		stmt *sql.Stmt
+		rows *sql.Rows
+	)
+
+	if stmt, err = db.getQuery(ctx, qid); err != nil {
+		db.log.Printf("[ERROR] Cannot prepare query %s: %s\n",
+			qid,
+			err.Error())
+		return nil, err
 	}
-} // func (db *Database) Rollback() error
 
-// Commit finishes a transaction
-func (db *Database) Commit() error {
-	var err error
-	var msg string
+	err = retry(ctx, func() (err error) {
+		rows, err = stmt.QueryContext(ctx, args...)
+		return err
+	})
+
+	return rows, err
+} // func (db *Database) query(ctx context.Context, qid query.ID, args ...any) (*sql.Rows, error)
+
+// Tx is an explicit transaction. The operations performed through it take
+// effect together, once it is committed, or not at all.
+// Unlike a Database, a Tx must not be used by more than one goroutine at a
+// time.
+type Tx struct {
+	db *Database
+	tx *sql.Tx
+}
 
-	if db.tx == nil {
-		msg = "Cannot commit transaction: No transaction is active!"
-		db.log.Println(msg)
-		return errors.New(msg)
-	} else if err = db.tx.Commit(); err != nil {
-		msg = fmt.Sprintf("Cannot commit transaction: %s", err.Error())
-		db.log.Println(msg)
-		return errors.New(msg)
-	} else {
-		db.tx = nil
-		return nil
+// Begin starts a transaction. If the context is cancelled before the
+// transaction is committed, it is rolled back.
+func (db *Database) Begin(ctx context.Context) (*Tx, error) {
+	var (
+		err error
+		tx  *sql.Tx
+	)
+
+	if err = retry(ctx, func() (err error) {
+		tx, err = db.db.BeginTx(ctx, nil)
+		return err
+	}); err != nil {
+		err = fmt.Errorf("Cannot start transaction: %w", err)
+		db.log.Printf("[ERROR] %s\n", err.Error())
+		return nil, err
 	}
-} // func (db *Database) Commit() error
 
-// Initialize a fresh database, i.e. create all the tables and indices.
-// Commit if everythings works as planned, otherwise, roll back, close
-// the database, delete the database file, and return an error.
-func (db *Database) initialize() error {
+	return &Tx{db: db, tx: tx}, nil
+} // func (db *Database) Begin(ctx context.Context) (*Tx, error)
+
+// Rollback aborts the transaction.
+func (tx *Tx) Rollback() error {
 	var err error
 
-	err = db.Begin()
-	if err != nil {
-		msg := fmt.Sprintf("Error starting transaction to initialize database: %s",
-			err.Error())
-		db.log.Println(msg)
-		return errors.New(msg)
+	if err = tx.tx.Rollback(); err != nil {
+		err = fmt.Errorf("Cannot roll back transaction: %w", err)
+		tx.db.log.Printf("[ERROR] %s\n", err.Error())
 	}
 
-	for _, query := range qInit {
-		if _, err = db.tx.Exec(query); err != nil {
-			msg := fmt.Sprintf("Error executing query %s: %s",
-				query, err.Error())
-			db.log.Println(msg)
-			db.db.Close()
-			db.db = nil
-			os.Remove(db.path)
-			return errors.New(msg)
-		}
+	return err
+} // func (tx *Tx) Rollback() error
+
+// Commit finishes the transaction.
+func (tx *Tx) Commit() error {
+	var err error
+
+	if err = tx.tx.Commit(); err != nil {
+		err = fmt.Errorf("Cannot commit transaction: %w", err)
+		tx.db.log.Printf("[ERROR] %s\n", err.Error())
 	}
 
-	db.Commit() // nolint: errcheck
-	return nil
-} // func (db *Database) initialize() error
+	return err
+} // func (tx *Tx) Commit() error
 
-// Close closes the database connection
+// Close closes the database connection.
+// Transactions that have not been committed are rolled back.
 func (db *Database) Close() {
+	db.lock.Lock()
 	for _, stmt := range db.stmtTable {
 		stmt.Close()
 	}
 
 	db.stmtTable = nil
-
-	if db.tx != nil {
-		db.tx.Rollback() // nolint: errcheck
-		db.tx = nil
-	}
+	db.lock.Unlock()
 
 	db.db.Close()
 } // func (db *Database) Close()
 
 // EventAdd inserts an Event into the database.
-func (db *Database) EventAdd(ev *event.Event) error {
-	const qid query.ID = query.EventAdd
+func (db *Database) EventAdd(ctx context.Context, ev *event.Event) error {
+	return db.eventAdd(ctx, nil, ev)
+} // func (db *Database) EventAdd(ctx context.Context, ev *event.Event) error
+
+// EventAdd inserts an Event into the database as part of the transaction.
+func (tx *Tx) EventAdd(ctx context.Context, ev *event.Event) error {
+	return tx.db.eventAdd(ctx, tx.tx, ev)
+} // func (tx *Tx) EventAdd(ctx context.Context, ev *event.Event) error
+
+func (db *Database) eventAdd(ctx context.Context, tx *sql.Tx, ev *event.Event) error {
 	var (
-		err    error
-		msg    string
-		stmt   *sql.Stmt
-		tx     *sql.Tx
-		status bool
+		err error
+		res sql.Result
+		id  int64
 	)
 
-	if stmt, err = db.getQuery(qid); err != nil {
-		db.log.Printf("[ERROR] Cannot prepare query %s: %s\n",
-			qid.String(),
+	if res, err = db.exec(ctx, tx, query.EventAdd,
+		ev.Type,
+		ev.Timestamp.Unix(),
+		ev.End.Unix(),
+		ev.Status,
+		ev.Command,
+		ev.User,
+		strings.Join(ev.Packages, " "),
+		ev.Output); err != nil {
+		err = fmt.Errorf("Cannot add Event %s to database: %w",
+			ev.Type,
+			err)
+		db.log.Printf("[ERROR] %s\n", err.Error())
+		return err
+	} else if id, err = res.LastInsertId(); err != nil {
+		db.log.Printf("[ERROR] Cannot get ID of new Event %s: %s\n",
+			ev.Type,
 			err.Error())
 		return err
-	} else if db.tx != nil {
-		tx = db.tx
-	} else {
-	BEGIN_AD_HOC:
-		if tx, err = db.db.Begin(); err != nil {
-			if worthARetry(err) {
-				waitForRetry()
-				goto BEGIN_AD_HOC
-			} else {
-				msg = fmt.Sprintf("Error starting transaction: %s\n",
-					err.Error())
-				db.log.Printf("[ERROR] %s\n", msg)
-				return errors.New(msg)
-			}
-		} else {
-			defer func() {
-				var err2 error
-				if status {
-					if err2 = tx.Commit(); err2 != nil {
-						db.log.Printf("[ERROR] Failed to commit ad-hoc transaction: %s\n",
-							err2.Error())
-					}
-				} else if err2 = tx.Rollback(); err2 != nil {
-					db.log.Printf("[ERROR] Rollback of ad-hoc transaction failed: %s\n",
-						err2.Error())
-				}
-			}()
-		}
 	}
 
-	stmt = tx.Stmt(stmt)
-	var res sql.Result
-
-EXEC_QUERY:
-	if res, err = stmt.Exec(ev.Type, ev.Timestamp.Unix(), ev.Status); err != nil {
-		if worthARetry(err) {
-			waitForRetry()
-			goto EXEC_QUERY
-		} else {
-			err = fmt.Errorf("Cannot add Event %s to database: %s",
-				ev.Type,
-				err.Error())
-			db.log.Printf("[ERROR] %s\n", err.Error())
-			return err
-		}
-	} else {
-		var id int64
-
-		if id, err = res.LastInsertId(); err != nil {
-			db.log.Printf("[ERROR] Cannot get ID of new Event %s: %s\n",
-				ev.Type,
-				err.Error())
-			return err
-		}
-
-		status = true
-		ev.ID = id
-		return nil
-	}
-} // func (db *Database) EventAdd(ev *event.Event) error
+	ev.ID = id
+	return nil
+} // func (db *Database) eventAdd(ctx context.Context, tx *sql.Tx, ev *event.Event) error
 
 // EventGetRecent fetches the (up to) <n> most recent events from the database.
 // If n == -1, all Events are fetched.
-func (db *Database) EventGetRecent(n int) ([]event.Event, error) {
-	const qid query.ID = query.EventGetRecent
-	var (
-		err  error
-		stmt *sql.Stmt
-	)
+func (db *Database) EventGetRecent(ctx context.Context, n int) ([]event.Event, error) {
+	return db.getEvents(ctx, n, query.EventGetRecent, n)
+} // func (db *Database) EventGetRecent(ctx context.Context, n int) ([]event.Event, error)
 
-	if stmt, err = db.getQuery(qid); err != nil {
-		db.log.Printf("[ERROR] Cannot prepare query %s: %s\n",
-			qid,
-			err.Error())
-		return nil, err
-	} else if db.tx != nil {
-		stmt = db.tx.Stmt(stmt)
-	}
+// EventGetRecentByType fetches the <n> most recent Events of the given type.
+func (db *Database) EventGetRecentByType(ctx context.Context, n int, evType event.ID) ([]event.Event, error) {
+	return db.getEvents(ctx, n, query.EventGetRecentByType, evType, n)
+} // func (db *Database) EventGetRecentByType(ctx context.Context, n int, evType event.ID) ([]event.Event, error)
 
-	var rows *sql.Rows
+// EventGetRecentErr fetches the <n> most recent Events that failed.
+func (db *Database) EventGetRecentErr(ctx context.Context, n int) ([]event.Event, error) {
+	return db.getEvents(ctx, n, query.EventGetRecentErr, n)
+} // func (db *Database) EventGetRecentErr(ctx context.Context, n int) ([]event.Event, error)
 
-EXEC_QUERY:
-	if rows, err = stmt.Query(n); err != nil {
-		if worthARetry(err) {
-			waitForRetry()
-			goto EXEC_QUERY
-		}
+// getEvents runs one of the queries that fetch up to n events.
+func (db *Database) getEvents(ctx context.Context, n int, qid query.ID, args ...any) ([]event.Event, error) {
+	var (
+		err  error
+		rows *sql.Rows
+	)
 
+	if rows, err = db.query(ctx, qid, args...); err != nil {
 		return nil, err
 	}
 
 	defer rows.Close() // nolint: errcheck,gosec
-	var results = make([]event.Event, 0, n)
+	var results = make([]event.Event, 0, capacity(n))
 
 	for rows.Next() {
 		var (
-			ev    event.Event
-			stamp int64
+			ev         event.Event
+			stamp, end int64
+			pkgs       string
 		)
 
-		if err = rows.Scan(&ev.ID, &ev.Type, &stamp, &ev.Status); err != nil {
+		if err = rows.Scan(&ev.ID, &ev.Type, &stamp, &end, &ev.Status, &ev.Command, &ev.User, &pkgs); err != nil {
 			db.log.Printf("[ERROR] Cannot scan row: %s\n", err.Error())
 			return nil, err
 		}
 
 		ev.Timestamp = time.Unix(stamp, 0)
+		ev.End = time.Unix(end, 0)
+		ev.Packages = strings.Fields(pkgs)
 		results = append(results, ev)
 	}
 
-	return results, nil
-} // func (db *Database) EventGetRecent(n int) ([]event.Event, error)
+	return results, rows.Err()
+} // func (db *Database) getEvents(ctx context.Context, n int, qid query.ID, args ...any) ([]event.Event, error)
 
-// EventGetRecentByType fetches the <n> most recent Events of the given type.
-func (db *Database) EventGetRecentByType(n int, evType event.ID) ([]event.Event, error) {
-	const qid query.ID = query.EventGetRecentByType
+// EventGetByID fetches the Event with the given ID, including the output of
+// the package manager, which the other queries leave out. If there is no
+// such Event, it returns nil and no error.
+func (db *Database) EventGetByID(ctx context.Context, id int64) (*event.Event, error) {
 	var (
 		err  error
-		stmt *sql.Stmt
+		rows *sql.Rows
 	)
 
-	if stmt, err = db.getQuery(qid); err != nil {
-		db.log.Printf("[ERROR] Cannot prepare query %s: %s\n",
-			qid,
-			err.Error())
-		return nil, err
-	} else if db.tx != nil {
-		stmt = db.tx.Stmt(stmt)
-	}
-
-	var rows *sql.Rows
-
-EXEC_QUERY:
-	if rows, err = stmt.Query(n, evType); err != nil {
-		if worthARetry(err) {
-			waitForRetry()
-			goto EXEC_QUERY
-		}
-
+	if rows, err = db.query(ctx, query.EventGetByID, id); err != nil {
 		return nil, err
 	}
 
 	defer rows.Close() // nolint: errcheck,gosec
-	var results = make([]event.Event, 0, n)
 
-	for rows.Next() {
+	if rows.Next() {
 		var (
-			ev    = event.Event{Type: evType}
-			stamp int64
+			ev         = &event.Event{ID: id}
+			stamp, end int64
+			pkgs       string
 		)
 
-		if err = rows.Scan(&ev.ID, &stamp, &ev.Status); err != nil {
+		if err = rows.Scan(&ev.Type, &stamp, &end, &ev.Status, &ev.Command, &ev.User, &pkgs, &ev.Output); err != nil {
 			db.log.Printf("[ERROR] Cannot scan row: %s\n", err.Error())
 			return nil, err
 		}
 
 		ev.Timestamp = time.Unix(stamp, 0)
-		results = append(results, ev)
+		ev.End = time.Unix(end, 0)
+		ev.Packages = strings.Fields(pkgs)
+		return ev, nil
 	}
 
-	return results, nil
-} // func (db *Database) EventGetRecentByType(n int, evType event.ID) ([]event.Event, error)
+	return nil, rows.Err()
+} // func (db *Database) EventGetByID(ctx context.Context, id int64) (*event.Event, error)
 
-func (db *Database) EventGetRecentErr(n int) ([]event.Event, error) {
-	const qid query.ID = query.EventGetRecentErr
+// ChangeAdd adds the change an Event made to a single package to the
+// database. The Event must have been added already.
+func (db *Database) ChangeAdd(ctx context.Context, c *event.Change) error {
+	return db.changeAdd(ctx, nil, c)
+} // func (db *Database) ChangeAdd(ctx context.Context, c *event.Change) error
+
+// ChangeAdd adds the change an Event made to a single package to the
+// database as part of the transaction.
+func (tx *Tx) ChangeAdd(ctx context.Context, c *event.Change) error {
+	return tx.db.changeAdd(ctx, tx.tx, c)
+} // func (tx *Tx) ChangeAdd(ctx context.Context, c *event.Change) error
+
+func (db *Database) changeAdd(ctx context.Context, tx *sql.Tx, c *event.Change) error {
 	var (
-		err  error
-		stmt *sql.Stmt
+		err error
+		res sql.Result
+		id  int64
 	)
 
-	if stmt, err = db.getQuery(qid); err != nil {
-		db.log.Printf("[ERROR] Cannot prepare query %s: %s\n",
-			qid,
+	if res, err = db.exec(ctx, tx, query.ChangeAdd,
+		c.EventID,
+		c.Package,
+		c.Action,
+		c.OldVersion,
+		c.NewVersion); err != nil {
+		err = fmt.Errorf("Cannot add change of %s by Event %d to database: %w",
+			c.Package,
+			c.EventID,
+			err)
+		db.log.Printf("[ERROR] %s\n", err.Error())
+		return err
+	} else if id, err = res.LastInsertId(); err != nil {
+		db.log.Printf("[ERROR] Cannot get ID of new change of %s: %s\n",
+			c.Package,
 			err.Error())
-		return nil, err
-	} else if db.tx != nil {
-		stmt = db.tx.Stmt(stmt)
+		return err
 	}
 
-	var rows *sql.Rows
-
-EXEC_QUERY:
-	if rows, err = stmt.Query(n); err != nil {
-		if worthARetry(err) {
-			waitForRetry()
-			goto EXEC_QUERY
-		}
+	c.ID = id
+	return nil
+} // func (db *Database) changeAdd(ctx context.Context, tx *sql.Tx, c *event.Change) error
+
+// ChangeGetByEvent fetches the changes the given Event made, ordered by
+// the name of the package.
+func (db *Database) ChangeGetByEvent(ctx context.Context, id int64) ([]event.Change, error) {
+	return db.getChanges(ctx, query.ChangeGetByEvent, id)
+} // func (db *Database) ChangeGetByEvent(ctx context.Context, id int64) ([]event.Change, error)
+
+// ChangeGetByPackage fetches the history of the given package, most recent
+// changes first.
+func (db *Database) ChangeGetByPackage(ctx context.Context, name string) ([]event.Change, error) {
+	return db.getChanges(ctx, query.ChangeGetByPackage, name)
+} // func (db *Database) ChangeGetByPackage(ctx context.Context, name string) ([]event.Change, error)
+
+// ChangeGetByPeriod fetches the changes made by the Events that started
+// between begin (inclusive) and end (exclusive), in the order they were made.
+// To see what changed on a given day, pass midnight of that day and of the
+// next.
+func (db *Database) ChangeGetByPeriod(ctx context.Context, begin, end time.Time) ([]event.Change, error) {
+	return db.getChanges(ctx, query.ChangeGetByPeriod, begin.Unix(), end.Unix())
+} // func (db *Database) ChangeGetByPeriod(ctx context.Context, begin, end time.Time) ([]event.Change, error)
+
+// getChanges runs one of the queries that fetch changes.
+func (db *Database) getChanges(ctx context.Context, qid query.ID, args ...any) ([]event.Change, error) {
+	var (
+		err  error
+		rows *sql.Rows
+	)
 
+	if rows, err = db.query(ctx, qid, args...); err != nil {
 		return nil, err
 	}
 
 	defer rows.Close() // nolint: errcheck,gosec
-	var results = make([]event.Event, 0, n)
+	var results = make([]event.Change, 0)
 
 	for rows.Next() {
 		var (
-			ev    event.Event
+			c     event.Change
 			stamp int64
 		)
 
-		if err = rows.Scan(&ev.ID, &ev.Type, &stamp, &ev.Status); err != nil {
+		if err = rows.Scan(&c.ID, &c.EventID, &stamp, &c.Package, &c.Action, &c.OldVersion, &c.NewVersion); err != nil {
 			db.log.Printf("[ERROR] Cannot scan row: %s\n", err.Error())
 			return nil, err
 		}
 
-		ev.Timestamp = time.Unix(stamp, 0)
-		results = append(results, ev)
+		c.Timestamp = time.Unix(stamp, 0)
+		results = append(results, c)
 	}
 
-	return results, nil
-} // func (db *Database) EventGetRecentErr(n int) ([]event.Event, error)
+	return results, rows.Err()
+} // func (db *Database) getChanges(ctx context.Context, qid query.ID, args ...any) ([]event.Change, error)
@@ -10,7 +10,11 @@
 // operations we can perform using the package manager.
 package event
 
-import "time"
+import (
+	"time"
+
+	"github.com/blicero/pkman/database/action"
+)
 
 // ID is the type used to represents events/operations.
 type ID uint8
@@ -40,10 +44,50 @@ func AllEvents() []ID {
 	}
 } // func AllEvents() []Event
 
+// MarshalText renders the ID by its name, so events show up readably in
+// JSON or YAML output.
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+} // func (id ID) MarshalText() ([]byte, error)
+
 // Event represents one operation on the package manager.
+// Timestamp is when the operation started, End when it was finished. Status
+// is the exit status of the package manager, or -1 if it failed without
+// one. Command holds the command lines the package manager was run with,
+// User the name of the user who asked for the operation, and Packages the
+// packages given as arguments, if any. Output is what the package manager
+// printed; only Database.EventGetByID loads it.
 type Event struct {
-	ID        int64
-	Type      ID
-	Timestamp time.Time
-	Status    int64
+	ID        int64     `json:"id" yaml:"id"`
+	Type      ID        `json:"type" yaml:"type"`
+	Timestamp time.Time `json:"timestamp" yaml:"timestamp"`
+	End       time.Time `json:"end" yaml:"end"`
+	Status    int64     `json:"status" yaml:"status"`
+	Command   string    `json:"command" yaml:"command"`
+	User      string    `json:"user" yaml:"user"`
+	Packages  []string  `json:"packages" yaml:"packages"`
+	Output    string    `json:"output,omitempty" yaml:"output,omitempty"`
+}
+
+// Duration returns how long the operation took. For events recorded before
+// we kept track of that, it is 0.
+func (ev *Event) Duration() time.Duration {
+	if ev.End.Before(ev.Timestamp) {
+		return 0
+	}
+
+	return ev.End.Sub(ev.Timestamp)
+} // func (ev *Event) Duration() time.Duration
+
+// Change is what an Event did to a single package.
+// Timestamp is that of the Event. OldVersion is empty for packages that were
+// installed, NewVersion for packages that were removed.
+type Change struct {
+	ID         int64     `json:"id" yaml:"id"`
+	EventID    int64     `json:"event_id" yaml:"event_id"`
+	Timestamp  time.Time `json:"timestamp" yaml:"timestamp"`
+	Package    string    `json:"package" yaml:"package"`
+	Action     action.ID `json:"action" yaml:"action"`
+	OldVersion string    `json:"old_version" yaml:"old_version"`
+	NewVersion string    `json:"new_version" yaml:"new_version"`
 }
@@ -0,0 +1,239 @@
+// /home/krylon/go/src/github.com/blicero/pkman/database/migrate.go
+// -*- mode: go; coding: utf-8; -*-
+// Created on 19. 10. 2026 by Benjamin Walkenhorst
+// (c) 2026 Benjamin Walkenhorst
+// Time-stamp: <>
+
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSchemaTooNew is returned by OpenDB for databases that were migrated by
+// a newer version of pkman than the one trying to open them.
+var ErrSchemaTooNew = errors.New("database schema is newer than this version of pkman")
+
+// migration is a step from one version of the schema to the next.
+type migration struct {
+	version int
+	desc    string
+	queries []string
+}
+
+// SchemaVersion is the version of the schema this version of pkman
+// expects.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+} // func SchemaVersion() int
+
+// migrate brings the schema of the database up to date, running each
+// migration that has not been applied yet in a transaction of its own.
+// Before it touches a database that holds data, it saves a backup of it next
+// to the original.
+func (db *Database) migrate() error {
+	var (
+		err     error
+		version int
+		tracked bool
+		latest  = SchemaVersion()
+	)
+
+	if version, tracked, err = db.schemaVersion(); err != nil {
+		return err
+	} else if version > latest {
+		return fmt.Errorf("%w: %s has version %d, we only know up to %d",
+			ErrSchemaTooNew,
+			db.path,
+			version,
+			latest)
+	} else if version > 0 && version < latest {
+		if err = db.backup(version); err != nil {
+			return err
+		}
+	}
+
+	if !tracked {
+		if _, err = db.db.Exec(qSchemaVersion); err != nil {
+			return fmt.Errorf("Cannot create schema_version table: %w", err)
+		} else if version > 0 {
+			// The database was created before we kept track of
+			// its version.
+			if _, err = db.db.Exec("INSERT INTO schema_version (version, timestamp) VALUES (?, ?)",
+				version,
+				time.Now().Unix()); err != nil {
+				return fmt.Errorf("Cannot record schema version: %w", err)
+			}
+		}
+	}
+
+	for i := range migrations {
+		var m = &migrations[i]
+
+		if m.version <= version {
+			continue
+		}
+
+		db.log.Printf("[INFO] Migrating %s to schema version %d: %s\n",
+			db.path,
+			m.version,
+			m.desc)
+
+		if err = db.runMigration(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+} // func (db *Database) migrate() error
+
+// runMigration applies a single migration, and records that it did.
+func (db *Database) runMigration(m *migration) error {
+	var (
+		err error
+		tx  *sql.Tx
+	)
+
+	if tx, err = db.db.Begin(); err != nil {
+		return fmt.Errorf("Cannot start transaction for migration %d: %w",
+			m.version,
+			err)
+	}
+
+	for _, q := range m.queries {
+		if _, err = tx.Exec(q); err != nil {
+			tx.Rollback() // nolint: errcheck
+			return fmt.Errorf("Migration %d (%s) failed: %w\n%s",
+				m.version,
+				m.desc,
+				err,
+				q)
+		}
+	}
+
+	if _, err = tx.Exec("INSERT INTO schema_version (version, timestamp) VALUES (?, ?)",
+		m.version,
+		time.Now().Unix()); err != nil {
+		tx.Rollback() // nolint: errcheck
+		return fmt.Errorf("Cannot record migration %d: %w",
+			m.version,
+			err)
+	} else if err = tx.Commit(); err != nil {
+		return fmt.Errorf("Cannot commit migration %d: %w",
+			m.version,
+			err)
+	}
+
+	return nil
+} // func (db *Database) runMigration(m *migration) error
+
+// schemaVersion returns the version of the database's schema, and whether
+// the database keeps track of it. For databases that do not, it guesses the
+// version from the tables and columns they have.
+func (db *Database) schemaVersion() (int, bool, error) {
+	var (
+		err     error
+		version int
+		ok      bool
+	)
+
+	if ok, err = db.hasTable("schema_version"); err != nil {
+		return 0, false, err
+	} else if !ok {
+		version, err = db.legacyVersion()
+		return version, false, err
+	} else if err = db.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version); err != nil {
+		return 0, true, fmt.Errorf("Cannot query schema version: %w", err)
+	}
+
+	return version, true, nil
+} // func (db *Database) schemaVersion() (int, bool, error)
+
+// legacyVersion guesses the schema version of a database created before we
+// kept track of it.
+func (db *Database) legacyVersion() (int, error) {
+	var checks = []struct {
+		table, column string
+	}{
+		{"event", ""},
+		{"event", "end_time"},
+		{"pkg_change", ""},
+		{"event", "output"},
+	}
+
+	for i, c := range checks {
+		var (
+			err error
+			ok  bool
+		)
+
+		if c.column == "" {
+			ok, err = db.hasTable(c.table)
+		} else {
+			ok, err = db.hasColumn(c.table, c.column)
+		}
+
+		if err != nil {
+			return 0, err
+		} else if !ok {
+			return i, nil
+		}
+	}
+
+	return len(checks), nil
+} // func (db *Database) legacyVersion() (int, error)
+
+func (db *Database) hasTable(table string) (bool, error) {
+	var (
+		err error
+		cnt int
+	)
+
+	if err = db.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?",
+		table).Scan(&cnt); err != nil {
+		return false, fmt.Errorf("Cannot look for table %s: %w", table, err)
+	}
+
+	return cnt > 0, nil
+} // func (db *Database) hasTable(table string) (bool, error)
+
+func (db *Database) hasColumn(table, column string) (bool, error) {
+	var (
+		err error
+		cnt int
+	)
+
+	if err = db.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?",
+		table,
+		column).Scan(&cnt); err != nil {
+		return false, fmt.Errorf("Cannot look for column %s.%s: %w", table, column, err)
+	}
+
+	return cnt > 0, nil
+} // func (db *Database) hasColumn(table, column string) (bool, error)
+
+// backup saves a copy of the database before it is migrated from the given
+// version, named after the version and the time, e.g.
+// pkman.db.v3-20261020-053109.bak.
+func (db *Database) backup(version int) error {
+	var (
+		err  error
+		path = fmt.Sprintf("%s.v%d-%s.bak",
+			db.path,
+			version,
+			time.Now().Format("20060102-150405"))
+	)
+
+	if _, err = db.db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("Cannot back up database to %s: %w", path, err)
+	}
+
+	db.log.Printf("[INFO] Saved a backup of %s to %s\n",
+		db.path,
+		path)
+
+	return nil
+} // func (db *Database) backup(version int) error
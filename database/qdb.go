@@ -9,13 +9,20 @@ package database
 import "github.com/blicero/pkman/database/query"
 
 var qDb = map[query.ID]string{
-	query.EventAdd: "INSERT INTO event (event, timestamp, status) VALUES (?, ?, ?)",
+	query.EventAdd: `
+INSERT INTO event (event, timestamp, end_time, status, cmdline, username, packages, output)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+`,
 	query.EventGetRecent: `
 SELECT
     id,
     event,
     timestamp,
-    status
+    end_time,
+    status,
+    cmdline,
+    username,
+    packages
 FROM event
 ORDER BY timestamp DESC
 LIMIT ?
@@ -23,8 +30,13 @@ LIMIT ?
 	query.EventGetRecentByType: `
 SELECT
     id,
+    event,
     timestamp,
-    status
+    end_time,
+    status,
+    cmdline,
+    username,
+    packages
 FROM event
 WHERE event = ?
 ORDER BY timestamp DESC
@@ -35,10 +47,73 @@ SELECT
     id,
     event,
     timestamp,
-    status
+    end_time,
+    status,
+    cmdline,
+    username,
+    packages
 FROM event
 WHERE status <> 0
 ORDER BY timestamp DESC
 LIMIT ?
+`,
+	query.EventGetByID: `
+SELECT
+    event,
+    timestamp,
+    end_time,
+    status,
+    cmdline,
+    username,
+    packages,
+    output
+FROM event
+WHERE id = ?
+`,
+	query.ChangeAdd: `
+INSERT INTO pkg_change (event_id, package, action, old_version, new_version)
+VALUES (?, ?, ?, ?, ?)
+`,
+	query.ChangeGetByEvent: `
+SELECT
+    c.id,
+    c.event_id,
+    e.timestamp,
+    c.package,
+    c.action,
+    c.old_version,
+    c.new_version
+FROM pkg_change c
+INNER JOIN event e ON c.event_id = e.id
+WHERE c.event_id = ?
+ORDER BY c.package
+`,
+	query.ChangeGetByPackage: `
+SELECT
+    c.id,
+    c.event_id,
+    e.timestamp,
+    c.package,
+    c.action,
+    c.old_version,
+    c.new_version
+FROM pkg_change c
+INNER JOIN event e ON c.event_id = e.id
+WHERE c.package = ?
+ORDER BY e.timestamp DESC, c.id DESC
+`,
+	query.ChangeGetByPeriod: `
+SELECT
+    c.id,
+    c.event_id,
+    e.timestamp,
+    c.package,
+    c.action,
+    c.old_version,
+    c.new_version
+FROM pkg_change c
+INNER JOIN event e ON c.event_id = e.id
+WHERE e.timestamp >= ? AND e.timestamp < ?
+ORDER BY e.timestamp, c.id
 `,
 }
@@ -6,8 +6,26 @@
 
 package database
 
-var qInit = []string{
-	`
+// qSchemaVersion creates the table that records which migrations have been
+// applied to the database, and when.
+const qSchemaVersion = `
+CREATE TABLE IF NOT EXISTS schema_version (
+    version	INTEGER PRIMARY KEY,
+    timestamp	INTEGER NOT NULL
+) STRICT
+`
+
+// migrations are the steps that take the schema of the database from one
+// version to the next. A fresh database is created by running all of them,
+// so this is the one place the schema is defined.
+// Migrations are only ever appended. Once a version of pkman that knows a
+// migration has been released, it must not change.
+var migrations = []migration{
+	{
+		version: 1,
+		desc:    "Create event table",
+		queries: []string{
+			`
 CREATE TABLE event (
     id		INTEGER PRIMARY KEY,
     event	INTEGER NOT NULL,
@@ -15,7 +33,47 @@ CREATE TABLE event (
     status	INTEGER NOT NULL
 ) STRICT
 `,
-	"CREATE INDEX ev_event_idx ON event (event)",
-	"CREATE INDEX ev_timestamp_idx ON event (timestamp)",
-	"CREATE INDEX ev_status_idx ON event (status)",
+			"CREATE INDEX ev_event_idx ON event (event)",
+			"CREATE INDEX ev_timestamp_idx ON event (timestamp)",
+			"CREATE INDEX ev_status_idx ON event (status)",
+		},
+	},
+	{
+		version: 2,
+		desc:    "Record when events ended, their command lines, users and packages",
+		queries: []string{
+			"ALTER TABLE event ADD COLUMN end_time INTEGER NOT NULL DEFAULT 0",
+			"ALTER TABLE event ADD COLUMN cmdline TEXT NOT NULL DEFAULT ''",
+			"ALTER TABLE event ADD COLUMN username TEXT NOT NULL DEFAULT ''",
+			"ALTER TABLE event ADD COLUMN packages TEXT NOT NULL DEFAULT ''",
+		},
+	},
+	{
+		version: 3,
+		desc:    "Create table of the changes events made to single packages",
+		queries: []string{
+			`
+CREATE TABLE pkg_change (
+    id			INTEGER PRIMARY KEY,
+    event_id		INTEGER NOT NULL,
+    package		TEXT NOT NULL,
+    action		INTEGER NOT NULL,
+    old_version		TEXT NOT NULL DEFAULT '',
+    new_version		TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY (event_id) REFERENCES event (id)
+        ON UPDATE RESTRICT
+        ON DELETE CASCADE
+) STRICT
+`,
+			"CREATE INDEX chg_event_idx ON pkg_change (event_id)",
+			"CREATE INDEX chg_package_idx ON pkg_change (package)",
+		},
+	},
+	{
+		version: 4,
+		desc:    "Record the output of events",
+		queries: []string{
+			"ALTER TABLE event ADD COLUMN output TEXT NOT NULL DEFAULT ''",
+		},
+	},
 }
@@ -17,4 +17,9 @@ const (
 	EventGetRecent
 	EventGetRecentByType
 	EventGetRecentErr
+	EventGetByID
+	ChangeAdd
+	ChangeGetByEvent
+	ChangeGetByPackage
+	ChangeGetByPeriod
 )
@@ -11,3 +11,7 @@ require (
 	github.com/blicero/krylib v0.0.0-20230308180103-2ef208d8985d
 	github.com/mattn/go-sqlite3 v1.14.16
 )
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require github.com/BurntSushi/toml v1.5.0
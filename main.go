@@ -11,14 +11,9 @@ import (
 	"os"
 
 	"github.com/blicero/pkman/cli"
-	"github.com/blicero/pkman/common"
 )
 
 func main() {
-	fmt.Printf("%s %s built on %s\n",
-		common.AppName,
-		common.Version,
-		common.BuildStamp.Format(common.TimestampFormat))
 	var (
 		err error
 		c   *cli.CLI
@@ -32,5 +27,11 @@ func main() {
 		os.Exit(1)
 	}
 
-	c.Run()
+	if err = c.Run(); err != nil {
+		fmt.Fprintf(
+			os.Stderr,
+			"%s\n",
+			err.Error())
+		os.Exit(cli.ExitStatus(err))
+	}
 }